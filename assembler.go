@@ -0,0 +1,597 @@
+package teal
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	pushVersion              = 3
+	backBranchVersion        = 4
+	optimizeConstantsVersion = 4
+)
+
+var langOpsByName = func() map[string]LangOp {
+	res := map[string]LangOp{}
+	for _, op := range BuiltInLangSpec.Ops {
+		res[op.Name] = op
+	}
+	return res
+}()
+
+type asmLabelRef struct {
+	name string
+	pos  int
+}
+
+type asmInstr struct {
+	line int
+	bs   []byte
+	refs []asmLabelRef
+
+	c *asmConst
+}
+
+type asmConst struct {
+	v []byte
+	i uint64
+}
+
+type asmPool struct {
+	ints bool
+
+	vals []asmConst
+	idx  map[string]int
+
+	refs   []*asmInstr
+	blocks int
+}
+
+func (p *asmPool) key(c asmConst) string {
+	if p.ints {
+		return strconv.FormatUint(c.i, 10)
+	}
+	return string(c.v)
+}
+
+func (p *asmPool) add(c asmConst) int {
+	k := p.key(c)
+	if i, ok := p.idx[k]; ok {
+		return i
+	}
+
+	i := len(p.vals)
+	p.vals = append(p.vals, c)
+	p.idx[k] = i
+
+	return i
+}
+
+func (p *asmPool) name() string {
+	if p.ints {
+		return "int"
+	}
+	return "byte"
+}
+
+func (p *asmPool) push(c asmConst) []byte {
+	if p.ints {
+		return binary.AppendUvarint([]byte{langOpsByName["pushint"].Opcode}, c.i)
+	}
+
+	bs := binary.AppendUvarint([]byte{langOpsByName["pushbytes"].Opcode}, uint64(len(c.v)))
+	return append(bs, c.v...)
+}
+
+func (p *asmPool) ref(i int) ([]byte, error) {
+	name := "bytec"
+	if p.ints {
+		name = "intc"
+	}
+
+	if i < 4 {
+		return []byte{langOpsByName[name+"_"+strconv.Itoa(i)].Opcode}, nil
+	}
+
+	if i > 0xff {
+		return nil, errors.Errorf("cannot have more than 256 %s constants", p.name())
+	}
+
+	return []byte{langOpsByName[name].Opcode, uint8(i)}, nil
+}
+
+func (p *asmPool) block(vals []asmConst) []byte {
+	if p.ints {
+		bs := binary.AppendUvarint([]byte{langOpsByName["intcblock"].Opcode}, uint64(len(vals)))
+		for _, v := range vals {
+			bs = binary.AppendUvarint(bs, v.i)
+		}
+		return bs
+	}
+
+	bs := binary.AppendUvarint([]byte{langOpsByName["bytecblock"].Opcode}, uint64(len(vals)))
+	for _, v := range vals {
+		bs = binary.AppendUvarint(bs, uint64(len(v.v)))
+		bs = append(bs, v.v...)
+	}
+	return bs
+}
+
+// finish resolves constant references and returns the values that have to be
+// prepended as a constant block, the same way goal does
+func (p *asmPool) finish(version uint64) ([]asmConst, error) {
+	if p.blocks > 0 || version < optimizeConstantsVersion {
+		for _, r := range p.refs {
+			bs, err := p.ref(p.idx[p.key(*r.c)])
+			if err != nil {
+				return nil, err
+			}
+			r.bs = bs
+		}
+
+		if p.blocks > 0 {
+			return nil, nil
+		}
+
+		return p.vals, nil
+	}
+
+	freqs := make([]int, len(p.vals))
+	for _, r := range p.refs {
+		freqs[p.idx[p.key(*r.c)]]++
+	}
+
+	order := make([]int, len(p.vals))
+	for i := range order {
+		order[i] = i
+	}
+
+	// stable sort by frequency, most used constants get the cheapest references
+	for i := 1; i < len(order); i++ {
+		for j := i; j > 0 && freqs[order[j]] > freqs[order[j-1]]; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+
+	var vals []asmConst
+	idx := make([]int, len(p.vals))
+
+	// constants used once, or past the 256 that fit in the block, are pushed
+	for _, o := range order {
+		idx[o] = -1
+		if freqs[o] > 1 && len(vals) <= 0xff {
+			idx[o] = len(vals)
+			vals = append(vals, p.vals[o])
+		}
+	}
+
+	for _, r := range p.refs {
+		o := p.idx[p.key(*r.c)]
+		if idx[o] < 0 {
+			r.bs = p.push(*r.c)
+			continue
+		}
+
+		bs, err := p.ref(idx[o])
+		if err != nil {
+			return nil, err
+		}
+		r.bs = bs
+	}
+
+	return vals, nil
+}
+
+type assembler struct {
+	version uint64
+
+	instrs []*asmInstr
+	pcs    []int
+	line   int
+
+	labels map[string]int
+
+	ints  asmPool
+	bytes asmPool
+}
+
+func (a *assembler) emit(name string, imms ...byte) error {
+	op, ok := langOpsByName[name]
+	if !ok {
+		return errors.Errorf("unknown opcode: %s", name)
+	}
+
+	a.instrs = append(a.instrs, &asmInstr{
+		line: a.line,
+		bs:   append([]byte{op.Opcode}, imms...),
+	})
+
+	return nil
+}
+
+func (a *assembler) emitVarUints(name string, vs ...uint64) error {
+	var bs []byte
+	for _, v := range vs {
+		bs = binary.AppendUvarint(bs, v)
+	}
+
+	return a.emit(name, bs...)
+}
+
+func (a *assembler) emitBytess(name string, vs ...[]byte) error {
+	bs := binary.AppendUvarint(nil, uint64(len(vs)))
+	for _, v := range vs {
+		bs = binary.AppendUvarint(bs, uint64(len(v)))
+		bs = append(bs, v...)
+	}
+
+	return a.emit(name, bs...)
+}
+
+func (a *assembler) emitBranch(name string, lbls ...*LabelExpr) error {
+	err := a.emit(name)
+	if err != nil {
+		return err
+	}
+
+	in := a.instrs[len(a.instrs)-1]
+	for _, lbl := range lbls {
+		in.refs = append(in.refs, asmLabelRef{name: lbl.Name, pos: len(in.bs)})
+		in.bs = append(in.bs, 0, 0)
+	}
+
+	return nil
+}
+
+func (a *assembler) emitSwitch(name string, lbls []*LabelExpr) error {
+	if len(lbls) > 0xff {
+		return errors.Errorf("%s cannot take more than 255 labels", name)
+	}
+
+	err := a.emitBranch(name)
+	if err != nil {
+		return err
+	}
+
+	in := a.instrs[len(a.instrs)-1]
+	in.bs = append(in.bs, uint8(len(lbls)))
+
+	for _, lbl := range lbls {
+		in.refs = append(in.refs, asmLabelRef{name: lbl.Name, pos: len(in.bs)})
+		in.bs = append(in.bs, 0, 0)
+	}
+
+	return nil
+}
+
+func (a *assembler) constant(p *asmPool, c asmConst) error {
+	if p.blocks > 0 && a.version >= backBranchVersion || p.blocks > 1 {
+		if a.version < pushVersion {
+			return errors.Errorf("%s used with manual %scblocks", p.name(), p.name())
+		}
+
+		a.instrs = append(a.instrs, &asmInstr{line: a.line, bs: p.push(c)})
+		return nil
+	}
+
+	if _, ok := p.idx[p.key(c)]; !ok {
+		if p.blocks > 0 {
+			return errors.Errorf("%s constant used without it in the %scblock", p.name(), p.name())
+		}
+		p.add(c)
+	}
+
+	in := &asmInstr{line: a.line, c: &c}
+	p.refs = append(p.refs, in)
+	a.instrs = append(a.instrs, in)
+
+	return nil
+}
+
+func (a *assembler) cblock(p *asmPool, vals []asmConst) error {
+	if len(p.refs) > 0 && p.blocks == 0 {
+		return errors.Errorf("%scblock following %s", p.name(), p.name())
+	}
+
+	if p.blocks == 0 {
+		for _, v := range vals {
+			p.add(v)
+		}
+	}
+
+	p.blocks++
+
+	a.instrs = append(a.instrs, &asmInstr{line: a.line, bs: p.block(vals)})
+
+	return nil
+}
+
+func methodSelector(sig string) ([]byte, error) {
+	if strings.HasPrefix(sig, "\"") {
+		v, err := parseStringLiteral(sig)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse method signature")
+		}
+		sig = string(v)
+	}
+
+	h := sha512.Sum512_256([]byte(sig))
+	return h[:4], nil
+}
+
+func (a *assembler) assemble(op Op) error {
+	switch op := op.(type) {
+	case *LabelExpr:
+		if _, ok := a.labels[op.Name]; ok {
+			return errors.Errorf("duplicate label: %s", op.Name)
+		}
+		a.labels[op.Name] = len(a.instrs)
+		return nil
+	case Nop:
+		return nil
+
+	case *IntExpr:
+		return a.constant(&a.ints, asmConst{i: op.Value})
+	case *ByteExpr:
+		return a.constant(&a.bytes, asmConst{v: op.Value})
+	case *AddrExpr:
+		addr, err := types.DecodeAddress(op.Address)
+		if err != nil {
+			return errors.Wrap(err, "failed to decode address")
+		}
+		return a.constant(&a.bytes, asmConst{v: addr[:]})
+	case *MethodExpr:
+		sel, err := methodSelector(op.Signature)
+		if err != nil {
+			return err
+		}
+		return a.constant(&a.bytes, asmConst{v: sel})
+
+	case *IntcBlockExpr:
+		var vals []asmConst
+		for _, v := range op.Values {
+			vals = append(vals, asmConst{i: v})
+		}
+		return a.cblock(&a.ints, vals)
+	case *BytecBlockExpr:
+		var vals []asmConst
+		for _, v := range op.Values {
+			vals = append(vals, asmConst{v: v})
+		}
+		return a.cblock(&a.bytes, vals)
+
+	case *PushIntExpr:
+		return a.emitVarUints("pushint", op.Value)
+	case *PushBytesExpr:
+		bs := binary.AppendUvarint(nil, uint64(len(op.Value)))
+		return a.emit("pushbytes", append(bs, op.Value...)...)
+	case *PushIntsExpr:
+		return a.emitVarUints("pushints", append([]uint64{uint64(len(op.Ints))}, op.Ints...)...)
+	case *PushBytessExpr:
+		return a.emitBytess("pushbytess", op.Bytess...)
+
+	case *BExpr:
+		return a.emitBranch("b", op.Label)
+	case *BzExpr:
+		return a.emitBranch("bz", op.Label)
+	case *BnzExpr:
+		return a.emitBranch("bnz", op.Label)
+	case *CallSubExpr:
+		return a.emitBranch("callsub", op.Label)
+	case *SwitchExpr:
+		return a.emitSwitch("switch", op.Targets)
+	case *MatchExpr:
+		return a.emitSwitch("match", op.Targets)
+
+	case *EcdsaVerifyExpr:
+		return a.emit("ecdsa_verify", uint8(op.Index))
+	case *EcdsaPkDecompressExpr:
+		return a.emit("ecdsa_pk_decompress", uint8(op.Index))
+	case *EcdsaPkRecoverExpr:
+		return a.emit("ecdsa_pk_recover", uint8(op.Index))
+	case *IntcExpr:
+		return a.emit("intc", op.Index)
+	case *BytecExpr:
+		return a.emit("bytec", op.Index)
+	case *ArgExpr:
+		return a.emit("arg", op.Index)
+	case *TxnExpr:
+		return a.emit("txn", uint8(op.Field))
+	case *GlobalExpr:
+		return a.emit("global", uint8(op.Field))
+	case *GtxnExpr:
+		return a.emit("gtxn", op.Group, uint8(op.Field))
+	case *LoadExpr:
+		return a.emit("load", op.Index)
+	case *StoreExpr:
+		return a.emit("store", op.Index)
+	case *TxnaExpr:
+		return a.emit("txna", uint8(op.Field), op.Index)
+	case *GtxnaExpr:
+		return a.emit("gtxna", op.Group, uint8(op.Field), op.Index)
+	case *GtxnsExpr:
+		return a.emit("gtxns", uint8(op.Field))
+	case *GtxnsaExpr:
+		return a.emit("gtxnsa", uint8(op.Field), op.Index)
+	case *GloadExpr:
+		return a.emit("gload", op.Group, op.Index)
+	case *GloadsExpr:
+		return a.emit("gloads", op.Index)
+	case *GaidExpr:
+		return a.emit("gaid", op.Group)
+	case *BuryExpr:
+		return a.emit("bury", op.Depth)
+	case *PopNExpr:
+		return a.emit("popn", op.Depth)
+	case *DupNExpr:
+		return a.emit("dupn", op.Count)
+	case *DigExpr:
+		return a.emit("dig", op.Index)
+	case *CoverExpr:
+		return a.emit("cover", op.Depth)
+	case *UncoverExpr:
+		return a.emit("uncover", op.Depth)
+	case *SubstringExpr:
+		return a.emit("substring", op.Start, op.End)
+	case *ExtractExpr:
+		return a.emit("extract", op.Start, op.Length)
+	case *Replace2Expr:
+		return a.emit("replace2", op.Start)
+	case *Base64DecodeExpr:
+		return a.emit("base64_decode", op.Index)
+	case *JsonRefExpr:
+		return a.emit("json_ref", op.Index)
+	case *AssetHoldingGetExpr:
+		return a.emit("asset_holding_get", uint8(op.Field))
+	case *AssetParamsGetExpr:
+		return a.emit("asset_params_get", uint8(op.Field))
+	case *AppParamsGetExpr:
+		return a.emit("app_params_get", uint8(op.Field))
+	case *AcctParamsGetExpr:
+		return a.emit("acct_params_get", uint8(op.Field))
+	case *ProtoExpr:
+		return a.emit("proto", op.Args, op.Results)
+	case *FrameDigExpr:
+		return a.emit("frame_dig", uint8(op.Index))
+	case *FrameBuryExpr:
+		return a.emit("frame_bury", uint8(op.Index))
+	case *ItxnFieldExpr:
+		return a.emit("itxn_field", uint8(op.Field))
+	case *ItxnExpr:
+		return a.emit("itxn", uint8(op.Field))
+	case *ItxnaExpr:
+		return a.emit("itxna", uint8(op.Field), op.Index)
+	case *GitxnExpr:
+		return a.emit("gitxn", op.Index, uint8(op.Field))
+	case *GitxnaExpr:
+		return a.emit("gitxna", op.Group, uint8(op.Field), op.Index)
+	case *TxnasExpr:
+		return a.emit("txnas", uint8(op.Field))
+	case *GtxnasExpr:
+		return a.emit("gtxnas", op.Index, uint8(op.Field))
+	case *GtxnsasExpr:
+		return a.emit("gtxnsas", uint8(op.Field))
+	case *ItxnasExpr:
+		return a.emit("itxnas", uint8(op.Field))
+	case *GitxnasExpr:
+		return a.emit("gitxnas", op.Index, uint8(op.Field))
+	case *VrfVerifyExpr:
+		return a.emit("vrf_verify", uint8(op.Field))
+	case *BlockExpr:
+		return a.emit("block", uint8(op.Field))
+
+	case *ExtractUint64Expr:
+		return a.emit("extract_uint64")
+
+	default:
+		// ops without immediate arguments are printed as their bare opcode name
+		name := op.String()
+
+		spec, ok := langOpsByName[name]
+		if !ok {
+			return errors.Errorf("unknown opcode: %s", strings.SplitN(name, " ", 2)[0])
+		}
+
+		if spec.Size != 1 {
+			return errors.Errorf("unsupported opcode: %s", name)
+		}
+
+		return a.emit(name)
+	}
+}
+
+func (a *assembler) link() ([]byte, error) {
+	ints, err := a.ints.finish(a.version)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := a.bytes.finish(a.version)
+	if err != nil {
+		return nil, err
+	}
+
+	pcs := make([]int, len(a.instrs)+1)
+	for i, in := range a.instrs {
+		pcs[i+1] = pcs[i] + len(in.bs)
+	}
+
+	for i, in := range a.instrs {
+		end := pcs[i+1]
+
+		for _, ref := range in.refs {
+			target, ok := a.labels[ref.name]
+			if !ok {
+				return nil, errors.Errorf("line %d: reference to a missing label: %s", in.line+1, ref.name)
+			}
+
+			offset := pcs[target] - end
+			if offset < 0 && a.version < backBranchVersion {
+				return nil, errors.Errorf("line %d: label %s is a back reference, back jump support was introduced in v%d", in.line+1, ref.name, backBranchVersion)
+			}
+			if offset > 0x7fff || offset < -0x8000 {
+				return nil, errors.Errorf("line %d: label %s is too far away", in.line+1, ref.name)
+			}
+
+			binary.BigEndian.PutUint16(in.bs[ref.pos:], uint16(int16(offset)))
+		}
+	}
+
+	res := binary.AppendUvarint(nil, a.version)
+
+	if len(ints) > 0 {
+		res = append(res, a.ints.block(ints)...)
+	}
+
+	if len(bytes) > 0 {
+		res = append(res, a.bytes.block(bytes)...)
+	}
+
+	a.pcs = make([]int, len(a.instrs))
+	for i, in := range a.instrs {
+		a.pcs[i] = len(res)
+		res = append(res, in.bs...)
+	}
+
+	return res, nil
+}
+
+// Assemble encodes the listing into AVM bytecode of the given version.
+func (l Listing) Assemble(version uint64) ([]byte, error) {
+	_, res, err := l.assemble(version)
+	return res, err
+}
+
+func (l Listing) assemble(version uint64) (*assembler, []byte, error) {
+	if version == 0 {
+		return nil, nil, errors.New("version must be at least 1")
+	}
+
+	a := &assembler{
+		version: version,
+		labels:  map[string]int{},
+		ints:    asmPool{ints: true, idx: map[string]int{}},
+		bytes:   asmPool{idx: map[string]int{}},
+	}
+
+	for i, op := range l {
+		a.line = i
+
+		err := a.assemble(op)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to assemble line %d", i+1)
+		}
+	}
+
+	res, err := a.link()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a, res, nil
+}
@@ -0,0 +1,150 @@
+package teal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAssembleExamples(t *testing.T) {
+	// expected bytecode as produced by goal clerk compile
+	tests := map[string]string{
+		"b.teal":          "0442000381018988fffa",
+		"byte.teal":       "01260304746573740974657374207465737409746573742274657374282828282828282828292a",
+		"err.teal":        "0100",
+		"match.teal":      "0831198d05000300060009000c000f42000f8100438101438102438103438104438003656e64",
+		"pushbytess.teal": "088203013101320133",
+		"pushints.teal":   "088303010203",
+		"simple.teal":     "08810143",
+		"ec.teal":         "",
+	}
+
+	for name, expected := range tests {
+		bs, err := os.ReadFile(filepath.Join("examples", "ok", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := Process(string(bs))
+
+		prog, err := res.Listing.Assemble(res.Version)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("expected %s to fail to assemble", name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed to assemble %s: %s", name, err)
+			continue
+		}
+
+		actual := hex.EncodeToString(prog)
+		if actual != expected {
+			t.Errorf("unexpected bytecode for %s - expected: %s, actual: %s", name, expected, actual)
+		}
+	}
+}
+
+func TestAssembleConstants(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Expected string
+	}{
+		{
+			Source:   "int 1\nint 2\nint 1\n+\n+",
+			Expected: "01200201022223220808",
+		},
+		{
+			Source:   "#pragma version 8\nint 5\nint 7\nint 7\nbyte 0x01\nbyte 0x01",
+			Expected: "0820010726010101810522222828",
+		},
+		{
+			Source:   "#pragma version 8\nintcblock 3 4\nint 5\nintc_1",
+			Expected: "0820020304810523",
+		},
+		{
+			Source:   "#pragma version 8\nmethod \"add(uint64,uint64)uint128\"",
+			Expected: "0880048aa3b61f",
+		},
+		{
+			Source:   "#pragma version 8\nint 1\nint 1\n+\nint 1000000\nint 1000000\nint 1000000\n*",
+			Expected: "082002c0843d012323082222220b",
+		},
+	}
+
+	for i, test := range tests {
+		res := Process(test.Source)
+
+		prog, err := res.Listing.Assemble(res.Version)
+		if err != nil {
+			t.Errorf("failed to assemble test %d: %s", i, err)
+			continue
+		}
+
+		actual := hex.EncodeToString(prog)
+		if actual != test.Expected {
+			t.Errorf("unexpected bytecode for test %d - expected: %s, actual: %s", i, test.Expected, actual)
+		}
+	}
+}
+
+func TestAssembleConstantLimit(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&sb, "int %d\nint %d\n", i, i)
+	}
+
+	res := Process("#pragma version 2\n" + sb.String())
+
+	_, err := res.Listing.Assemble(res.Version)
+	if err == nil {
+		t.Error("expected more than 256 constants to fail before v4")
+	}
+
+	res = Process("#pragma version 8\n" + sb.String())
+
+	prog, err := res.Listing.Assemble(res.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first 256 constants are pooled, the rest are pushed
+	if !bytes.Contains(prog, []byte{langOpsByName["pushint"].Opcode, 0xab, 0x02}) {
+		t.Errorf("expected the constants past the block to be pushed: %s", hex.EncodeToString(prog))
+	}
+}
+
+func TestAssembleBackJump(t *testing.T) {
+	res := Process("#pragma version 3\nloop:\nb loop")
+
+	_, err := res.Listing.Assemble(res.Version)
+	if err == nil {
+		t.Error("expected back jump to fail before v4")
+	}
+}
+
+func TestAssembleSpecOps(t *testing.T) {
+	for _, op := range BuiltInLangSpec.Ops {
+		if op.Size != 1 {
+			continue
+		}
+
+		res := Process(fmt.Sprintf("#pragma version %d\n%s", BuiltInLangSpec.EvalMaxVersion, op.Name))
+
+		prog, err := res.Listing.Assemble(res.Version)
+		if err != nil {
+			t.Errorf("failed to assemble %s: %s", op.Name, err)
+			continue
+		}
+
+		if len(prog) != 2 || prog[1] != op.Opcode {
+			t.Errorf("unexpected bytecode for %s: %s", op.Name, hex.EncodeToString(prog))
+		}
+	}
+}
@@ -0,0 +1,547 @@
+package teal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"math/bits"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	maxByteMathSize = 64
+	maxKeySize      = 64
+	maxKvSize       = 128
+	maxBoxSize      = 32768
+	maxLogCalls     = 32
+	maxLogSize      = 1024
+
+	maxAppProgramCost = 700
+	maxLogicSigCost   = 20000
+
+	appCostPoolingVersion      = 4
+	logicSigCostPoolingVersion = 9
+
+	maxTxGroupSize = 16
+	maxStackDepth  = 1000
+	maxCallDepth   = 1000
+)
+
+func avmSha256(bs []byte) []byte {
+	h := sha256.Sum256(bs)
+	return h[:]
+}
+
+func avmSha512256(bs []byte) []byte {
+	h := sha512.Sum512_256(bs)
+	return h[:]
+}
+
+func avmSha3256(bs []byte) []byte {
+	h := sha3.Sum256(bs)
+	return h[:]
+}
+
+func avmKeccak256(bs []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(bs)
+	return h.Sum(nil)
+}
+
+func avmBig(bs []byte) (*big.Int, error) {
+	if len(bs) > maxByteMathSize {
+		return nil, errors.Errorf("math attempted on large byte-array (%d)", len(bs))
+	}
+
+	return new(big.Int).SetBytes(bs), nil
+}
+
+func avmBigs(a, b []byte) (*big.Int, *big.Int, error) {
+	x, err := avmBig(a)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	y, err := avmBig(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return x, y, nil
+}
+
+func avmBigCmp(a, b []byte) (int, error) {
+	x, y, err := avmBigs(a, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return x.Cmp(y), nil
+}
+
+// avmBitwise applies f to the byte-arrays left padded with zeros to the same length
+func avmBitwise(a, b []byte, f func(x, y byte) byte) []byte {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	res := make([]byte, n)
+	for i := 0; i < n; i++ {
+		var x, y byte
+		if j := i - (n - len(a)); j >= 0 {
+			x = a[j]
+		}
+		if j := i - (n - len(b)); j >= 0 {
+			y = b[j]
+		}
+		res[i] = f(x, y)
+	}
+
+	return res
+}
+
+func avmSubstring(bs []byte, s, e uint64) ([]byte, error) {
+	if e < s {
+		return nil, errors.Errorf("substring end before start (%d < %d)", e, s)
+	}
+
+	if e > uint64(len(bs)) {
+		return nil, errors.Errorf("substring range beyond length of string (%d > %d)", e, len(bs))
+	}
+
+	return append([]byte{}, bs[s:e]...), nil
+}
+
+func avmExtract(bs []byte, s, l uint64) ([]byte, error) {
+	e := s + l
+	if e < s || e > uint64(len(bs)) {
+		return nil, errors.Errorf("extraction end %d is beyond length: %d", e, len(bs))
+	}
+
+	return append([]byte{}, bs[s:e]...), nil
+}
+
+func avmExtractUint(bs []byte, s uint64, size uint64) (uint64, error) {
+	v, err := avmExtract(bs, s, size)
+	if err != nil {
+		return 0, err
+	}
+
+	var res uint64
+	for _, b := range v {
+		res = res<<8 | uint64(b)
+	}
+
+	return res, nil
+}
+
+func avmReplace(bs []byte, s uint64, r []byte) ([]byte, error) {
+	e := s + uint64(len(r))
+	if e < s || e > uint64(len(bs)) {
+		return nil, errors.Errorf("replacement end %d beyond original length: %d", e, len(bs))
+	}
+
+	res := append([]byte{}, bs...)
+	copy(res[s:], r)
+
+	return res, nil
+}
+
+func avmExp(a, b uint64) (uint64, error) {
+	if a == 0 && b == 0 {
+		return 0, errors.New("0^0 is undefined")
+	}
+
+	res := uint64(1)
+	for i := uint64(0); i < b; i++ {
+		hi, lo := bits.Mul64(res, a)
+		if hi != 0 {
+			return 0, errors.Errorf("%d^%d overflow", a, b)
+		}
+		res = lo
+
+		if res == 0 || res == 1 {
+			break
+		}
+	}
+
+	return res, nil
+}
+
+func avmSqrt(v uint64) uint64 {
+	r := new(big.Int).Sqrt(new(big.Int).SetUint64(v))
+	return r.Uint64()
+}
+
+func avmBase64Decode(enc Base64Encoding, bs []byte) ([]byte, error) {
+	var encoding *base64.Encoding
+
+	switch enc {
+	case URLEncoding:
+		encoding = base64.URLEncoding
+	case StdEncoding:
+		encoding = base64.StdEncoding
+	default:
+		return nil, errors.Errorf("invalid base64_decode encoding: %d", enc)
+	}
+
+	if len(bs)%4 != 0 {
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+
+	return encoding.Strict().DecodeString(string(bs))
+}
+
+func avmJsonRef(t JSONRefType, doc []byte, key []byte) (VmValue, error) {
+	var obj map[string]json.RawMessage
+
+	err := json.Unmarshal(doc, &obj)
+	if err != nil {
+		return VmValue{}, errors.Wrap(err, "invalid json text")
+	}
+
+	raw, ok := obj[string(key)]
+	if !ok {
+		return VmValue{}, errors.Errorf("key %s not found in JSON text", key)
+	}
+
+	switch t {
+	case JSONString:
+		var s string
+		err := json.Unmarshal(raw, &s)
+		if err != nil {
+			return VmValue{}, errors.Wrap(err, "value is not a string")
+		}
+		return BytesValue([]byte(s)), nil
+	case JSONUint64:
+		var u uint64
+		err := json.Unmarshal(raw, &u)
+		if err != nil {
+			return VmValue{}, errors.Wrap(err, "value is not a uint64")
+		}
+		return Uint64Value(u), nil
+	case JSONObject:
+		var o map[string]json.RawMessage
+		err := json.Unmarshal(raw, &o)
+		if err != nil {
+			return VmValue{}, errors.Wrap(err, "value is not an object")
+		}
+		return BytesValue(bytes.TrimSpace(raw)), nil
+	default:
+		return VmValue{}, errors.Errorf("invalid json_ref type: %d", t)
+	}
+}
+
+// secp256k1 is not supported by crypto/elliptic so the few operations the AVM needs are implemented on affine coordinates
+
+type avmPoint struct {
+	x, y *big.Int
+}
+
+var (
+	secp256k1P  = avmHexInt("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+	secp256k1N  = avmHexInt("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	secp256k1Gx = avmHexInt("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798")
+	secp256k1Gy = avmHexInt("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8")
+)
+
+func avmHexInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex number")
+	}
+
+	return v
+}
+
+func secp256k1Add(a, b *avmPoint) *avmPoint {
+	if a == nil {
+		return b
+	}
+
+	if b == nil {
+		return a
+	}
+
+	p := secp256k1P
+
+	var l *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		s := new(big.Int).Add(a.y, b.y)
+		if s.Mod(s, p).Sign() == 0 {
+			return nil
+		}
+
+		n := new(big.Int).Mul(a.x, a.x)
+		n.Mul(n, big.NewInt(3))
+		d := new(big.Int).Lsh(a.y, 1)
+		l = n.Mul(n, d.ModInverse(d, p))
+	} else {
+		n := new(big.Int).Sub(b.y, a.y)
+		d := new(big.Int).Sub(b.x, a.x)
+		d.Mod(d, p)
+		l = n.Mul(n, d.ModInverse(d, p))
+	}
+	l.Mod(l, p)
+
+	x := new(big.Int).Mul(l, l)
+	x.Sub(x, a.x)
+	x.Sub(x, b.x)
+	x.Mod(x, p)
+
+	y := new(big.Int).Sub(a.x, x)
+	y.Mul(y, l)
+	y.Sub(y, a.y)
+	y.Mod(y, p)
+
+	return &avmPoint{x: x, y: y}
+}
+
+func secp256k1Mul(a *avmPoint, k *big.Int) *avmPoint {
+	var res *avmPoint
+
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		res = secp256k1Add(res, res)
+		if k.Bit(i) == 1 {
+			res = secp256k1Add(res, a)
+		}
+	}
+
+	return res
+}
+
+func secp256k1Y(x *big.Int, odd bool) (*big.Int, error) {
+	p := secp256k1P
+
+	if x.Cmp(p) >= 0 {
+		return nil, errors.New("invalid x coordinate")
+	}
+
+	// y^2 = x^3 + 7
+	y2 := new(big.Int).Exp(x, big.NewInt(3), p)
+	y2.Add(y2, big.NewInt(7))
+	y2.Mod(y2, p)
+
+	y := new(big.Int).ModSqrt(y2, p)
+	if y == nil {
+		return nil, errors.New("point is not on the curve")
+	}
+
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(p, y)
+	}
+
+	return y, nil
+}
+
+func secp256k1OnCurve(x, y *big.Int) bool {
+	p := secp256k1P
+
+	if x.Cmp(p) >= 0 || y.Cmp(p) >= 0 {
+		return false
+	}
+
+	l := new(big.Int).Mul(y, y)
+	l.Mod(l, p)
+
+	r := new(big.Int).Exp(x, big.NewInt(3), p)
+	r.Add(r, big.NewInt(7))
+	r.Mod(r, p)
+
+	return l.Cmp(r) == 0
+}
+
+func avmEcdsaVerify(curve EcdsaCurve, data, r, s, x, y []byte) (bool, error) {
+	if len(data) != 32 {
+		return false, errors.Errorf("the signed data must be 32 bytes long, not %d", len(data))
+	}
+
+	for _, v := range [][]byte{r, s, x, y} {
+		if len(v) > 32 {
+			return false, errors.Errorf("value too long (%d > 32)", len(v))
+		}
+	}
+
+	ri := new(big.Int).SetBytes(r)
+	si := new(big.Int).SetBytes(s)
+	xi := new(big.Int).SetBytes(x)
+	yi := new(big.Int).SetBytes(y)
+
+	switch curve {
+	case Secp256k1:
+		n := secp256k1N
+
+		if !secp256k1OnCurve(xi, yi) {
+			return false, nil
+		}
+
+		if ri.Sign() == 0 || ri.Cmp(n) >= 0 || si.Sign() == 0 || si.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+			return false, nil
+		}
+
+		e := new(big.Int).SetBytes(data)
+		w := new(big.Int).ModInverse(si, n)
+
+		u1 := new(big.Int).Mul(e, w)
+		u1.Mod(u1, n)
+		u2 := new(big.Int).Mul(ri, w)
+		u2.Mod(u2, n)
+
+		g := &avmPoint{x: secp256k1Gx, y: secp256k1Gy}
+		q := &avmPoint{x: xi, y: yi}
+
+		p := secp256k1Add(secp256k1Mul(g, u1), secp256k1Mul(q, u2))
+		if p == nil {
+			return false, nil
+		}
+
+		return new(big.Int).Mod(p.x, n).Cmp(ri) == 0, nil
+	case Secp256r1:
+		c := elliptic.P256()
+		if !c.IsOnCurve(xi, yi) {
+			return false, nil
+		}
+
+		return ecdsa.Verify(&ecdsa.PublicKey{Curve: c, X: xi, Y: yi}, data, ri, si), nil
+	default:
+		return false, errors.Errorf("invalid curve: %d", curve)
+	}
+}
+
+func avmEcdsaDecompress(curve EcdsaCurve, pk []byte) ([]byte, []byte, error) {
+	if len(pk) != 33 || (pk[0] != 2 && pk[0] != 3) {
+		return nil, nil, errors.New("invalid compressed public key")
+	}
+
+	switch curve {
+	case Secp256k1:
+		x := new(big.Int).SetBytes(pk[1:])
+		y, err := secp256k1Y(x, pk[0] == 3)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32)), nil
+	case Secp256r1:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pk)
+		if x == nil {
+			return nil, nil, errors.New("invalid compressed public key")
+		}
+
+		return x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32)), nil
+	default:
+		return nil, nil, errors.Errorf("invalid curve: %d", curve)
+	}
+}
+
+func avmEcdsaRecover(curve EcdsaCurve, data []byte, v uint64, r, s []byte) ([]byte, []byte, error) {
+	if curve != Secp256k1 {
+		return nil, nil, errors.Errorf("ecdsa_pk_recover is not supported for curve: %d", curve)
+	}
+
+	if len(data) != 32 {
+		return nil, nil, errors.Errorf("the signed data must be 32 bytes long, not %d", len(data))
+	}
+
+	if v > 3 {
+		return nil, nil, errors.Errorf("invalid recovery id: %d", v)
+	}
+
+	if len(r) > 32 || len(s) > 32 {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	n := secp256k1N
+
+	ri := new(big.Int).SetBytes(r)
+	si := new(big.Int).SetBytes(s)
+
+	if ri.Sign() == 0 || ri.Cmp(n) >= 0 || si.Sign() == 0 || si.Cmp(n) >= 0 {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	rx := new(big.Int).Set(ri)
+	if v >= 2 {
+		rx.Add(rx, n)
+	}
+
+	ry, err := secp256k1Y(rx, v&1 == 1)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid signature")
+	}
+
+	e := new(big.Int).SetBytes(data)
+	e.Neg(e)
+	e.Mod(e, n)
+
+	rinv := new(big.Int).ModInverse(ri, n)
+
+	g := &avmPoint{x: secp256k1Gx, y: secp256k1Gy}
+	rp := &avmPoint{x: rx, y: ry}
+
+	// Q = r^-1 * (s*R - e*G)
+	q := secp256k1Add(secp256k1Mul(rp, si), secp256k1Mul(g, e))
+	if q == nil {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	q = secp256k1Mul(q, rinv)
+	if q == nil {
+		return nil, nil, errors.New("invalid signature")
+	}
+
+	return q.x.FillBytes(make([]byte, 32)), q.y.FillBytes(make([]byte, 32)), nil
+}
+
+func avmUint128(hi, lo uint64) *big.Int {
+	v := new(big.Int).SetUint64(hi)
+	v.Lsh(v, 64)
+	return v.Or(v, new(big.Int).SetUint64(lo))
+}
+
+func avmSplit128(v *big.Int) (uint64, uint64) {
+	lo := new(big.Int).And(v, new(big.Int).SetUint64(^uint64(0)))
+	hi := new(big.Int).Rsh(v, 64)
+	return hi.Uint64(), lo.Uint64()
+}
+
+func avmEd25519Verify(data, sig, pk []byte) (bool, error) {
+	if len(pk) != ed25519.PublicKeySize {
+		return false, errors.Errorf("invalid public key length: %d", len(pk))
+	}
+
+	if len(sig) != ed25519.SignatureSize {
+		return false, errors.Errorf("invalid signature length: %d", len(sig))
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(pk), data, sig), nil
+}
+
+func avmBoxName(name []byte) error {
+	if len(name) == 0 || len(name) > maxKeySize {
+		return errors.Errorf("invalid box name length: %d", len(name))
+	}
+
+	return nil
+}
+
+func avmKv(key []byte, v VmValue) error {
+	if len(key) > maxKeySize {
+		return errors.Errorf("key too long (%d > %d)", len(key), maxKeySize)
+	}
+
+	if bs, ok := v.Bytes(); ok && len(key)+len(bs) > maxKvSize {
+		return errors.Errorf("key/value total too long (%d > %d)", len(key)+len(bs), maxKvSize)
+	}
+
+	return nil
+}
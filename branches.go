@@ -0,0 +1,159 @@
+package teal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultMaxBranches = 1024
+	DefaultMaxSteps    = 1000000
+	DefaultMaxUnroll   = 16
+)
+
+// VmCutPath is a path the vm stopped exploring because of a limit
+type VmCutPath struct {
+	Branch int
+	Line   int
+	Target string
+	Reason string
+}
+
+// WithMaxBranches limits the number of branches, the forks beyond the limit are not explored - 0 means no limit
+func WithMaxBranches(n int) VmOption {
+	return func(v *Vm) {
+		v.MaxBranches = n
+	}
+}
+
+// WithMaxSteps limits the number of steps of all of the branches together - 0 means no limit
+func WithMaxSteps(n int) VmOption {
+	return func(v *Vm) {
+		v.MaxSteps = n
+	}
+}
+
+// WithMaxUnroll limits how many times a branch may jump back to the same line when not running concrete - 0 means no limit
+func WithMaxUnroll(n int) VmOption {
+	return func(v *Vm) {
+		v.MaxUnroll = n
+	}
+}
+
+func (v *Vm) canBranch(n int) bool {
+	return v.MaxBranches <= 0 || len(v.Branches)+n <= v.MaxBranches
+}
+
+func (v *Vm) cut(b *VmBranch, target string, reason string) {
+	v.Cuts = append(v.Cuts, VmCutPath{
+		Branch: b.Id,
+		Line:   b.Line,
+		Target: target,
+		Reason: reason,
+	})
+}
+
+// cutAll ends all of the running branches
+func (v *Vm) cutAll(reason string) {
+	for _, b := range v.Branches {
+		if b.Line == ExitLine {
+			continue
+		}
+
+		v.cut(b, "", reason)
+
+		b.Outcome = VmCut
+		b.exit()
+	}
+
+	v.Branch = nil
+}
+
+// arrive counts the jumps back to the current line and cuts the branch once it unrolled the loop too many times
+func (b *VmBranch) arrive(from int) {
+	if b.vm.Concrete || b.vm.MaxUnroll <= 0 || b.Line < 0 || b.Line > from {
+		return
+	}
+
+	b.visits[b.Line]++
+
+	if b.visits[b.Line] > b.vm.MaxUnroll {
+		b.vm.cut(b, "", fmt.Sprintf("loop unrolling bound of %d reached", b.vm.MaxUnroll))
+
+		b.Outcome = VmCut
+		b.exit()
+	}
+}
+
+// key returns the hash of everything the further execution of the branch depends on
+func (b *VmBranch) key() [32]byte {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%d %d %v %v\n", b.Line, b.Budget, b.intc, b.bytec)
+
+	for _, v := range b.Stack.Items {
+		fmt.Fprintf(&sb, "s %s\n", v)
+	}
+
+	// branches only merge under the same path conditions, the order they were added in doesn't matter
+	cs := make([]string, len(b.Constraints))
+	for i, c := range b.Constraints {
+		cs[i] = c.String()
+	}
+	sort.Strings(cs)
+
+	for _, c := range cs {
+		fmt.Fprintf(&sb, "c %s\n", c)
+	}
+
+	for i, v := range b.Scratch.Items {
+		if v.T != VmTypeNone {
+			fmt.Fprintf(&sb, "x %d %s\n", i, v)
+		}
+	}
+
+	for _, f := range b.Frames {
+		fmt.Fprintf(&sb, "f %d %d %d %d %t\n", f.Return, f.NumArgs, f.NumReturns, f.p, f.proto)
+	}
+
+	for _, v := range b.Logs {
+		fmt.Fprintf(&sb, "l %s\n", v)
+	}
+
+	for _, g := range b.Inner {
+		for _, t := range g {
+			fmt.Fprintf(&sb, "i %v %v\n", t.Txn, t.Unknown)
+		}
+	}
+
+	for _, t := range b.pending {
+		fmt.Fprintf(&sb, "p %v %v\n", t.Txn, t.Unknown)
+	}
+
+	if b.state != nil {
+		fmt.Fprintf(&sb, "g %v\nl %v\nb %v\na %v\nh %v\n", b.state.globals, b.state.locals, b.state.boxes, b.state.balances, b.state.holdings)
+		fmt.Fprintf(&sb, "ug %v\nul %v\n", b.state.unknownGlobals, b.state.unknownLocals)
+	}
+
+	return sha256.Sum256([]byte(sb.String()))
+}
+
+// merge ends the branch if another branch already reached the same state
+func (b *VmBranch) merge() {
+	if b.vm.Concrete || b.Line == ExitLine {
+		return
+	}
+
+	k := b.key()
+
+	if id, ok := b.vm.states[k]; ok && id != b.Id {
+		b.Outcome = VmMerged
+		b.MergedWith = id
+		b.exit()
+		return
+	}
+
+	b.vm.states[k] = b.Id
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dragmz/teal"
+	"github.com/pkg/errors"
+)
+
+type args struct {
+	Path   string
+	Budget int
+	Json   bool
+}
+
+func format(r teal.CostRange) string {
+	if r.Unbounded {
+		return fmt.Sprintf("%d..unbounded", r.Min)
+	}
+
+	return fmt.Sprintf("%d..%d", r.Min, r.Max)
+}
+
+func printRanges(title string, rs map[string]teal.CostRange) {
+	if len(rs) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(rs))
+	for name := range rs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%s:\n", title)
+	for _, name := range names {
+		fmt.Printf("  %s: %s\n", name, format(rs[name]))
+	}
+}
+
+func run(a args) error {
+	bs, err := os.ReadFile(a.Path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read source file")
+	}
+
+	res := teal.Process(string(bs))
+	for _, d := range res.Diagnostics {
+		if d.Severity() == teal.DiagErr {
+			return errors.Errorf("%d:%d-%d: %s", d.Line(), d.Begin(), d.End(), d)
+		}
+	}
+
+	r, err := teal.EstimateCost(res.Listing, res.Version)
+	if err != nil {
+		return errors.Wrap(err, "failed to estimate cost")
+	}
+
+	if a.Json {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		err = e.Encode(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to write report")
+		}
+	} else {
+		fmt.Printf("program: %s\n", format(r.Program))
+		if len(r.WorstPath) > 0 {
+			fmt.Printf("worst path: %s\n", strings.Join(r.WorstPath, " -> "))
+		}
+		printRanges("subroutines", r.Subroutines)
+		printRanges("labels", r.Labels)
+	}
+
+	if a.Budget > 0 {
+		if r.Program.Unbounded {
+			return errors.Errorf("program cost is unbounded, budget: %d", a.Budget)
+		}
+
+		if r.Program.Max > a.Budget {
+			return errors.Errorf("program cost %d exceeds budget %d", r.Program.Max, a.Budget)
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	var a args
+
+	flag.StringVar(&a.Path, "path", "", "source file path")
+	flag.IntVar(&a.Budget, "budget", 0, "fail if the max program cost exceeds the budget")
+	flag.BoolVar(&a.Json, "json", false, "write the report as json")
+	flag.Parse()
+
+	err := run(a)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -16,6 +16,7 @@ import (
 
 type args struct {
 	Path string
+	App  bool
 }
 
 func ToSarifLevel(s teal.DiagnosticSeverity) string {
@@ -102,7 +103,12 @@ func run(a args) error {
 			return err
 		}
 
-		res := teal.Process(string(s))
+		var opts []teal.ProcessOption
+		if a.App {
+			opts = append(opts, teal.WithAppRules())
+		}
+
+		res := teal.Process(string(s), opts...)
 
 		ab, err := filepath.Abs(path)
 		if err != nil {
@@ -163,6 +169,7 @@ func main() {
 	var a args
 
 	flag.StringVar(&a.Path, "path", "", "path to scan")
+	flag.BoolVar(&a.App, "app", false, "check the application security rules")
 	flag.Parse()
 
 	err := run(a)
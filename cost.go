@@ -0,0 +1,538 @@
+package teal
+
+import (
+	"math"
+	"strings"
+)
+
+// LangOpCost is the documented opcode cost of an op
+type LangOpCost struct {
+	Base int
+
+	// PerBytes is the cost added per each started Chunk bytes of the last argument
+	PerBytes int
+	Chunk    int
+
+	// Imms are the costs keyed by the name of the immediate argument
+	Imms map[string]int
+}
+
+// Range returns the min and max cost for the immediate and the last argument length range
+func (c LangOpCost) Range(imm string, minLen, maxLen int) CostRange {
+	if v, ok := c.Imms[imm]; ok {
+		return CostRange{Min: v, Max: v}
+	}
+
+	if len(c.Imms) > 0 {
+		min := math.MaxInt
+		for _, v := range c.Imms {
+			if v < min {
+				min = v
+			}
+		}
+		return CostRange{Min: min, Max: c.Base}
+	}
+
+	if c.Chunk == 0 {
+		return CostRange{Min: c.Base, Max: c.Base}
+	}
+
+	f := func(l int) int {
+		return c.Base + c.PerBytes*((l+c.Chunk-1)/c.Chunk)
+	}
+
+	return CostRange{Min: f(minLen), Max: f(maxLen)}
+}
+
+type langOpVersionCost struct {
+	version uint64
+	cost    LangOpCost
+}
+
+// langOpCosts are the costs of the ops that do not cost 1 as documented in the langspec, keyed by the version the cost applies from
+var langOpCosts = map[string][]langOpVersionCost{
+	"sha256":              {{1, LangOpCost{Base: 7}}, {2, LangOpCost{Base: 35}}},
+	"keccak256":           {{1, LangOpCost{Base: 26}}, {2, LangOpCost{Base: 130}}},
+	"sha512_256":          {{1, LangOpCost{Base: 9}}, {2, LangOpCost{Base: 45}}},
+	"sha3_256":            {{1, LangOpCost{Base: 130}}},
+	"ed25519verify":       {{1, LangOpCost{Base: 1900}}},
+	"ed25519verify_bare":  {{1, LangOpCost{Base: 1900}}},
+	"ecdsa_verify":        {{1, LangOpCost{Base: 2500, Imms: map[string]int{"Secp256k1": 1700, "Secp256r1": 2500}}}},
+	"ecdsa_pk_decompress": {{1, LangOpCost{Base: 2400, Imms: map[string]int{"Secp256k1": 650, "Secp256r1": 2400}}}},
+	"ecdsa_pk_recover":    {{1, LangOpCost{Base: 2000}}},
+	"vrf_verify":          {{1, LangOpCost{Base: 5700}}},
+	"divmodw":             {{1, LangOpCost{Base: 20}}},
+	"sqrt":                {{1, LangOpCost{Base: 4}}},
+	"expw":                {{1, LangOpCost{Base: 10}}},
+	"bsqrt":               {{1, LangOpCost{Base: 40}}},
+	"b+":                  {{1, LangOpCost{Base: 10}}},
+	"b-":                  {{1, LangOpCost{Base: 10}}},
+	"b/":                  {{1, LangOpCost{Base: 20}}},
+	"b*":                  {{1, LangOpCost{Base: 20}}},
+	"b%":                  {{1, LangOpCost{Base: 20}}},
+	"b|":                  {{1, LangOpCost{Base: 6}}},
+	"b&":                  {{1, LangOpCost{Base: 6}}},
+	"b^":                  {{1, LangOpCost{Base: 6}}},
+	"b~":                  {{1, LangOpCost{Base: 4}}},
+	"base64_decode":       {{1, LangOpCost{Base: 1, PerBytes: 1, Chunk: 16}}},
+	"json_ref":            {{1, LangOpCost{Base: 25, PerBytes: 2, Chunk: 7}}},
+}
+
+// langOpCost returns the cost of the op at the program version
+func langOpCost(name string, version uint64) (LangOpCost, bool) {
+	if _, ok := langOpsByName[name]; !ok {
+		return LangOpCost{}, false
+	}
+
+	c := LangOpCost{Base: 1}
+	for _, vc := range langOpCosts[name] {
+		if version >= vc.version {
+			c = vc.cost
+		}
+	}
+
+	return c, true
+}
+
+// CostRange is the min and max opcode cost of a piece of code
+type CostRange struct {
+	Min int
+	Max int
+
+	// Unbounded is set if the max cost is not bounded because of a loop or a recursion, Max is not meaningful then
+	Unbounded bool
+}
+
+func (r CostRange) add(o CostRange) CostRange {
+	return CostRange{Min: r.Min + o.Min, Max: r.Max + o.Max, Unbounded: r.Unbounded || o.Unbounded}
+}
+
+// CostReport is the result of the static cost analysis of a program
+type CostReport struct {
+	// Program is the cost of the whole program
+	Program CostRange
+
+	// Labels are the costs from a label to the end of the program or of the enclosing subroutine
+	Labels map[string]CostRange
+
+	// Subroutines are the costs of the callsub targets and the proto functions
+	Subroutines map[string]CostRange
+
+	// WorstPath are the labels visited by the most expensive path, empty if the path is unbounded
+	WorstPath []string
+}
+
+// costPaths are the costs from an op until the program ends (exit) or the subroutine returns (ret)
+type costPaths struct {
+	exit costPath
+	ret  costPath
+}
+
+type costPath struct {
+	ok        bool
+	min       int
+	max       int
+	unbounded bool
+}
+
+func (p costPath) add(c CostRange) costPath {
+	if !p.ok {
+		return p
+	}
+
+	return costPath{ok: true, min: p.min + c.Min, max: p.max + c.Max, unbounded: p.unbounded || c.Unbounded}
+}
+
+func (p costPath) union(o costPath) costPath {
+	if !p.ok {
+		return o
+	}
+
+	if !o.ok {
+		return p
+	}
+
+	r := p
+	if o.min < r.min {
+		r.min = o.min
+	}
+	if o.max > r.max {
+		r.max = o.max
+	}
+	r.unbounded = p.unbounded || o.unbounded
+
+	return r
+}
+
+func (p costPath) withOk(ok bool) costPath {
+	if !ok {
+		return costPath{}
+	}
+	return p
+}
+
+func (p costPath) Range() CostRange {
+	return CostRange{Min: p.min, Max: p.max, Unbounded: p.unbounded}
+}
+
+func (p costPaths) total() costPath {
+	return p.exit.union(p.ret)
+}
+
+type costAnalyzer struct {
+	l       Listing
+	version uint64
+	labels  map[string]int
+
+	costs []CostRange
+	paths []costPaths
+	loops []bool
+}
+
+func opName(op Op) string {
+	if n, ok := op.(NamedExpr); ok {
+		return n.Name()
+	}
+
+	fs := strings.Fields(op.String())
+	if len(fs) == 0 {
+		return ""
+	}
+
+	return fs[0]
+}
+
+func opImmediate(op Op) string {
+	switch op := op.(type) {
+	case *EcdsaVerifyExpr:
+		return op.Index.String()
+	case *EcdsaPkDecompressExpr:
+		return op.Index.String()
+	case *EcdsaPkRecoverExpr:
+		return op.Index.String()
+	}
+
+	fs := strings.Fields(op.String())
+	if len(fs) < 2 {
+		return ""
+	}
+
+	return fs[1]
+}
+
+// opCost returns the cost of the op at the index, the length of a byte constant pushed right before is used if available
+func (a *costAnalyzer) opCost(i int) (CostRange, error) {
+	op := a.l[i]
+
+	if _, ok := op.(Nop); ok {
+		return CostRange{}, nil
+	}
+
+	c, ok := langOpCost(opName(op), a.version)
+	if !ok {
+		return CostRange{Min: 1, Max: 1}, nil
+	}
+
+	minLen, maxLen := 0, MaxStringSize
+
+	for j := i - 1; j >= 0; j-- {
+		p := a.l[j]
+		if _, ok := p.(Nop); ok {
+			if _, ok := p.(*LabelExpr); ok {
+				break
+			}
+			continue
+		}
+
+		switch p := p.(type) {
+		case *ByteExpr:
+			minLen, maxLen = len(p.Value), len(p.Value)
+		case *PushBytesExpr:
+			minLen, maxLen = len(p.Value), len(p.Value)
+		}
+
+		break
+	}
+
+	return c.Range(opImmediate(op), minLen, maxLen), nil
+}
+
+// next returns the indexes the execution may continue at after the op, excluding the subroutine calls
+func (a *costAnalyzer) next(i int) []int {
+	target := func(l *LabelExpr) int {
+		if ln, ok := a.labels[l.Name]; ok {
+			return ln
+		}
+		return -1
+	}
+
+	switch op := a.l[i].(type) {
+	case Terminator:
+		return nil
+	case *BExpr:
+		return []int{target(op.Label)}
+	case *BnzExpr:
+		return []int{target(op.Label), i + 1}
+	case *BzExpr:
+		return []int{target(op.Label), i + 1}
+	case *SwitchExpr:
+		res := []int{i + 1}
+		for _, t := range op.Targets {
+			res = append(res, target(t))
+		}
+		return res
+	case *MatchExpr:
+		res := []int{i + 1}
+		for _, t := range op.Targets {
+			res = append(res, target(t))
+		}
+		return res
+	default:
+		return []int{i + 1}
+	}
+}
+
+// edges returns the indexes the execution may continue at after the op, including the subroutine calls
+func (a *costAnalyzer) edges(i int) []int {
+	res := a.next(i)
+	if op, ok := a.l[i].(*CallSubExpr); ok {
+		if ln, ok := a.labels[op.Label.Name]; ok {
+			res = append(res, ln)
+		}
+	}
+	return res
+}
+
+// findLoops marks the ops that may reach a loop or a recursion
+func (a *costAnalyzer) findLoops() {
+	const (
+		white = iota
+		gray
+		black
+	)
+
+	colors := make([]int, len(a.l))
+
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		if i < 0 || i >= len(a.l) {
+			return false
+		}
+
+		switch colors[i] {
+		case gray:
+			return true
+		case black:
+			return a.loops[i]
+		}
+
+		colors[i] = gray
+
+		loop := false
+		for _, n := range a.edges(i) {
+			if visit(n) {
+				loop = true
+			}
+		}
+
+		colors[i] = black
+		a.loops[i] = loop
+
+		return loop
+	}
+
+	for i := range a.l {
+		visit(i)
+	}
+}
+
+func (a *costAnalyzer) at(i int) costPaths {
+	if i == len(a.l) {
+		return costPaths{exit: costPath{ok: true}}
+	}
+
+	if i < 0 || i > len(a.l) {
+		return costPaths{}
+	}
+
+	return a.paths[i]
+}
+
+func (a *costAnalyzer) eval(i int) costPaths {
+	c := a.costs[i]
+
+	var res costPaths
+
+	switch op := a.l[i].(type) {
+	case *RetSubExpr:
+		res.ret = costPath{ok: true}.add(c)
+		return res
+	case *CallSubExpr:
+		var sub costPaths
+		if entry, ok := a.labels[op.Label.Name]; ok {
+			sub = a.at(entry)
+		}
+
+		cont := a.at(i + 1)
+
+		res.exit = sub.exit.union(cont.exit.add(sub.ret.Range()).withOk(sub.ret.ok)).add(c)
+		res.ret = cont.ret.add(sub.ret.Range()).withOk(sub.ret.ok).add(c)
+	case Terminator:
+		res.exit = costPath{ok: true}.add(c)
+		return res
+	default:
+		for _, n := range a.next(i) {
+			p := a.at(n)
+			res.exit = res.exit.union(p.exit)
+			res.ret = res.ret.union(p.ret)
+		}
+
+		res.exit = res.exit.add(c)
+		res.ret = res.ret.add(c)
+	}
+
+	// the max cost of a loop grows with each iteration, only the min cost is kept
+	if a.loops[i] {
+		res.exit.max, res.exit.unbounded = res.exit.min, res.exit.ok
+		res.ret.max, res.ret.unbounded = res.ret.min, res.ret.ok
+	}
+
+	return res
+}
+
+func (a *costAnalyzer) solve() {
+	for changed := true; changed; {
+		changed = false
+		for i := len(a.l) - 1; i >= 0; i-- {
+			p := a.eval(i)
+			if p != a.paths[i] {
+				a.paths[i] = p
+				changed = true
+			}
+		}
+	}
+}
+
+// worstPath follows the most expensive path from the op until the program ends or the subroutine returns
+func (a *costAnalyzer) worstPath(i int, ret bool, path []string) []string {
+	for i >= 0 && i < len(a.l) {
+		if lbl, ok := a.l[i].(*LabelExpr); ok {
+			path = append(path, lbl.Name)
+		}
+
+		p := a.paths[i].exit
+		if ret {
+			p = a.paths[i].ret
+		}
+
+		c := a.costs[i].Max
+
+		switch op := a.l[i].(type) {
+		case Terminator:
+			return path
+		case *CallSubExpr:
+			entry := a.labels[op.Label.Name]
+			sub := a.paths[entry]
+
+			if !ret && sub.exit.ok && sub.exit.max+c == p.max {
+				return a.worstPath(entry, false, path)
+			}
+
+			path = a.worstPath(entry, true, path)
+			i++
+		default:
+			next := -1
+			for _, n := range a.next(i) {
+				np := a.at(n).exit
+				if ret {
+					np = a.at(n).ret
+				}
+				if np.ok && np.max+c == p.max {
+					next = n
+					break
+				}
+			}
+
+			if next == -1 {
+				return path
+			}
+
+			i = next
+		}
+	}
+
+	return path
+}
+
+func (a *costAnalyzer) subroutines() map[string]bool {
+	res := map[string]bool{}
+
+	for i, op := range a.l {
+		switch op := op.(type) {
+		case *CallSubExpr:
+			res[op.Label.Name] = true
+		case *LabelExpr:
+			for j := i + 1; j < len(a.l); j++ {
+				if _, ok := a.l[j].(*ProtoExpr); ok {
+					res[op.Name] = true
+				}
+				if _, ok := a.l[j].(Nop); !ok {
+					break
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// EstimateCost computes the min and max opcode costs of the program listing at the program version, its labels and subroutines
+func EstimateCost(l Listing, version uint64) (CostReport, error) {
+	a := &costAnalyzer{
+		l:       l,
+		version: version,
+		labels:  map[string]int{},
+		costs:   make([]CostRange, len(l)),
+		paths:   make([]costPaths, len(l)),
+		loops:   make([]bool, len(l)),
+	}
+
+	for i, op := range l {
+		if lbl, ok := op.(*LabelExpr); ok {
+			if _, ok := a.labels[lbl.Name]; !ok {
+				a.labels[lbl.Name] = i
+			}
+		}
+
+		c, err := a.opCost(i)
+		if err != nil {
+			return CostReport{}, err
+		}
+		a.costs[i] = c
+	}
+
+	a.findLoops()
+	a.solve()
+
+	r := CostReport{
+		Program:     a.at(0).total().Range(),
+		Labels:      map[string]CostRange{},
+		Subroutines: map[string]CostRange{},
+	}
+
+	for name, i := range a.labels {
+		r.Labels[name] = a.paths[i].total().Range()
+	}
+
+	for name := range a.subroutines() {
+		if i, ok := a.labels[name]; ok {
+			r.Subroutines[name] = a.paths[i].total().Range()
+		}
+	}
+
+	if !r.Program.Unbounded {
+		r.WorstPath = a.worstPath(0, false, []string{})
+	}
+
+	return r, nil
+}
@@ -0,0 +1,150 @@
+package teal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLangOpCost(t *testing.T) {
+	type test struct {
+		name    string
+		version uint64
+		imm     string
+		r       CostRange
+	}
+
+	tests := []test{
+		{name: "+", version: 8, r: CostRange{Min: 1, Max: 1}},
+		{name: "sha256", version: 1, r: CostRange{Min: 7, Max: 7}},
+		{name: "sha256", version: 8, r: CostRange{Min: 35, Max: 35}},
+		{name: "base64_decode", version: 8, r: CostRange{Min: 1, Max: 257}},
+		{name: "json_ref", version: 8, r: CostRange{Min: 25, Max: 25 + 2*586}},
+		{name: "ecdsa_verify", version: 8, imm: "Secp256r1", r: CostRange{Min: 2500, Max: 2500}},
+		{name: "ecdsa_verify", version: 8, r: CostRange{Min: 1700, Max: 2500}},
+	}
+
+	for _, ts := range tests {
+		c, ok := langOpCost(ts.name, ts.version)
+		if !ok {
+			t.Fatalf("missing cost of %s", ts.name)
+		}
+
+		r := c.Range(ts.imm, 0, MaxStringSize)
+		if r != ts.r {
+			t.Errorf("unexpected range of %s v%d: %+v, expected: %+v", ts.name, ts.version, r, ts.r)
+		}
+	}
+
+	if _, ok := langOpCost("unknown", 8); ok {
+		t.Error("unexpected cost of an unknown op")
+	}
+}
+
+func TestLangOpCosts(t *testing.T) {
+	for name := range langOpCosts {
+		if _, ok := langOpsByName[name]; !ok {
+			t.Errorf("cost of an op missing from the langspec: %s", name)
+		}
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	res := Process(`#pragma version 8
+	txn ApplicationID
+	bz create
+	callsub hash
+	byte "abc"
+	base64_decode StdEncoding
+	pop
+	b done
+create:
+	byte "x"
+	ecdsa_pk_decompress Secp256r1
+	pop
+	pop
+done:
+	int 1
+	return
+hash:
+	proto 0 0
+	byte "x"
+	sha256
+	keccak256
+	pop
+	retsub
+`)
+
+	r, err := EstimateCost(res.Listing, res.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, a CostRange, e CostRange) {
+		if a != e {
+			t.Errorf("unexpected %s cost: %+v, expected: %+v", name, a, e)
+		}
+	}
+
+	check("hash", r.Subroutines["hash"], CostRange{Min: 169, Max: 169})
+	check("done", r.Labels["done"], CostRange{Min: 2, Max: 2})
+	check("create", r.Labels["create"], CostRange{Min: 2405, Max: 2405})
+	check("program", r.Program, CostRange{Min: 3 + 169 + 1 + 2 + 1 + 1 + 2, Max: 2 + 2405})
+
+	if p := strings.Join(r.WorstPath, " "); p != "create done" {
+		t.Errorf("unexpected worst path: %s", p)
+	}
+}
+
+func TestEstimateCostLoop(t *testing.T) {
+	res := Process(`#pragma version 8
+	int 3
+loop:
+	int 1
+	-
+	dup
+	bnz loop
+	return
+`)
+
+	r, err := EstimateCost(res.Listing, res.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Program.Unbounded {
+		t.Errorf("expected unbounded program cost")
+	}
+
+	if r.Program.Min != 6 {
+		t.Errorf("unexpected min program cost: %d", r.Program.Min)
+	}
+
+	if len(r.WorstPath) != 0 {
+		t.Errorf("unexpected worst path: %v", r.WorstPath)
+	}
+}
+
+func TestEstimateCostVersion(t *testing.T) {
+	tests := []struct {
+		Version uint64
+		Cost    int
+	}{
+		{Version: 1, Cost: 1 + 7 + 26 + 9 + 1},
+		{Version: 2, Cost: 1 + 35 + 130 + 45 + 1},
+		{Version: 8, Cost: 1 + 35 + 130 + 45 + 1},
+	}
+
+	for _, test := range tests {
+		res := Process(fmt.Sprintf("#pragma version %d\nbyte 0x00\nsha256\nkeccak256\nsha512_256\nlen", test.Version))
+
+		r, err := EstimateCost(res.Listing, res.Version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.Program != (CostRange{Min: test.Cost, Max: test.Cost}) {
+			t.Errorf("unexpected cost at v%d: %+v, expected: %d", test.Version, r.Program, test.Cost)
+		}
+	}
+}
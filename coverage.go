@@ -0,0 +1,146 @@
+package teal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Coverage counts the executions of the program lines across many vm runs
+type Coverage struct {
+	Process *ProcessResult
+
+	// Hits are the execution counts of the listing lines
+	Hits []int
+}
+
+// CoverageReport are the hit counts of the executable source lines (1-based) and of the labels
+type CoverageReport struct {
+	Lines  map[string]int `json:"lines"`
+	Labels map[string]int `json:"labels"`
+}
+
+func NewCoverage(res *ProcessResult) *Coverage {
+	return &Coverage{
+		Process: res,
+		Hits:    make([]int, len(res.Listing)),
+	}
+}
+
+// Add counts the lines executed by the branches of the vm, the lines executed before a fork are counted once
+func (c *Coverage) Add(v *Vm) {
+	for _, b := range v.Branches {
+		for _, ln := range b.TraceLines[b.traced:] {
+			if ln >= 0 && ln < len(c.Hits) {
+				c.Hits[ln]++
+			}
+		}
+	}
+}
+
+// executable returns whether the listing line holds an op
+func (c *Coverage) executable(ln int) bool {
+	_, nop := c.Process.Listing[ln].(Nop)
+	return !nop
+}
+
+// labels returns the hit counts of the labels - the hits of the first op following the label
+func (c *Coverage) labels() ([]string, []int, map[string]int) {
+	var names []string
+	lines := []int{}
+	hits := map[string]int{}
+
+	for i, op := range c.Process.Listing {
+		l, ok := op.(*LabelExpr)
+		if !ok {
+			continue
+		}
+
+		names = append(names, l.Name)
+		lines = append(lines, i)
+
+		for j := i + 1; j < len(c.Process.Listing); j++ {
+			if c.executable(j) {
+				hits[l.Name] = c.Hits[j]
+				break
+			}
+		}
+	}
+
+	return names, lines, hits
+}
+
+// Report returns the hit counts keyed by the source line number
+func (c *Coverage) Report() CoverageReport {
+	r := CoverageReport{
+		Lines: map[string]int{},
+	}
+
+	for i, n := range c.Hits {
+		if c.executable(i) {
+			r.Lines[strconv.Itoa(i+1)] = n
+		}
+	}
+
+	_, _, r.Labels = c.labels()
+
+	return r
+}
+
+// WriteJson writes the report as json
+func (c *Coverage) WriteJson(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	err := e.Encode(c.Report())
+	if err != nil {
+		return errors.Wrap(err, "failed to write coverage json")
+	}
+
+	return nil
+}
+
+// WriteLcov writes the report in the lcov tracefile format, the labels are reported as functions
+func (c *Coverage) WriteLcov(w io.Writer, path string) error {
+	names, lines, hits := c.labels()
+
+	fmt.Fprintf(w, "TN:\nSF:%s\n", path)
+
+	fnh := 0
+	for i, name := range names {
+		fmt.Fprintf(w, "FN:%d,%s\n", lines[i]+1, name)
+	}
+
+	for _, name := range names {
+		fmt.Fprintf(w, "FNDA:%d,%s\n", hits[name], name)
+		if hits[name] > 0 {
+			fnh++
+		}
+	}
+
+	fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(names), fnh)
+
+	lf, lh := 0, 0
+	for i, n := range c.Hits {
+		if !c.executable(i) {
+			continue
+		}
+
+		fmt.Fprintf(w, "DA:%d,%d\n", i+1, n)
+
+		lf++
+		if n > 0 {
+			lh++
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", lf, lh)
+	if err != nil {
+		return errors.Wrap(err, "failed to write lcov")
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package teal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+func TestCoverage(t *testing.T) {
+	src := `#pragma version 8
+txn OnCompletion
+switch noop optin
+err
+noop:
+int 1
+return
+optin:
+int 1
+return`
+
+	c := NewCoverage(Process(src))
+
+	for i := 0; i < 2; i++ {
+		g := []types.SignedTxn{{Txn: types.Transaction{Type: types.ApplicationCallTx}}}
+
+		vm := NewVm(Process(src), WithConcrete(), WithGroup(g, 0))
+		vm.Run()
+
+		c.Add(vm)
+	}
+
+	r := c.Report()
+
+	expected := map[string]int{"2": 2, "3": 2, "4": 0, "6": 2, "7": 2, "9": 0, "10": 0}
+	if len(r.Lines) != len(expected) {
+		t.Errorf("unexpected lines: %v", r.Lines)
+	}
+
+	for ln, n := range expected {
+		if r.Lines[ln] != n {
+			t.Errorf("unexpected hits of line %s: %d, expected: %d", ln, r.Lines[ln], n)
+		}
+	}
+
+	if r.Labels["noop"] != 2 || r.Labels["optin"] != 0 {
+		t.Errorf("unexpected label hits: %v", r.Labels)
+	}
+
+	var b bytes.Buffer
+
+	err := c.WriteLcov(&b, "approval.teal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range []string{"SF:approval.teal", "FN:5,noop", "FNDA:0,optin", "DA:4,0", "DA:6,2", "LF:7", "LH:4", "end_of_record"} {
+		if !strings.Contains(b.String(), l+"\n") {
+			t.Errorf("missing %s in lcov:\n%s", l, b.String())
+		}
+	}
+}
+
+func TestCoverageForks(t *testing.T) {
+	src := "#pragma version 8\ntxn Fee\nbnz a\nint 1\nreturn\na:\nint 2\nreturn"
+
+	res := Process(src)
+	c := NewCoverage(res)
+
+	vm := NewVm(res)
+	vm.Run()
+
+	c.Add(vm)
+
+	for ln, n := range map[int]int{1: 1, 2: 1, 3: 1, 4: 1, 6: 1, 7: 1} {
+		if c.Hits[ln] != n {
+			t.Errorf("unexpected hits of line %d: %d, expected: %d", ln+1, c.Hits[ln], n)
+		}
+	}
+}
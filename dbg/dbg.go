@@ -102,7 +102,8 @@ type dapInitializeRequestParams struct {
 }
 
 type dapLaunchRequestParams struct {
-	Program string `json:"program"`
+	Program  string `json:"program"`
+	Concrete bool   `json:"concrete,omitempty"`
 }
 
 type dapStackTraceRequestParams struct {
@@ -351,8 +352,13 @@ func (l *dbg) handle(h dapHeader, b []byte) error {
 
 			res := teal.Process(src)
 
+			var opts []teal.VmOption
+			if lreq.Arguments.Concrete {
+				opts = append(opts, teal.WithConcrete())
+			}
+
 			l.vm = &dbgVm{
-				tvm:  teal.NewVm(res),
+				tvm:  teal.NewVm(res, opts...),
 				name: lreq.Arguments.Program,
 				path: lreq.Arguments.Program,
 			}
@@ -584,6 +590,41 @@ func (l *dbg) handle(h dapHeader, b []byte) error {
 									Name:  "Budget",
 									Value: strconv.Itoa(b.Budget),
 								})
+
+								if b.Outcome != teal.VmRunning {
+									vs = append(vs, dapVariable{
+										Name:  "Outcome",
+										Value: b.Outcome.String(),
+									})
+
+									if b.Err != nil {
+										vs = append(vs, dapVariable{
+											Name:  "Error",
+											Value: b.Err.Error(),
+										})
+									}
+
+									if b.Outcome == teal.VmMerged {
+										vs = append(vs, dapVariable{
+											Name:  "Merged With",
+											Value: strconv.Itoa(b.MergedWith),
+										})
+									}
+
+									if b.Result.T != teal.VmTypeNone {
+										vs = append(vs, dapVariable{
+											Name:  "Result",
+											Value: b.Result.String(),
+										})
+									}
+								}
+
+								if v, ok := b.ReturnValue(); ok {
+									vs = append(vs, dapVariable{
+										Name:  "Return",
+										Value: v.String(),
+									})
+								}
 							case 1:
 								for i := len(b.Stack.Items) - 1; i >= 0; i-- {
 									vs = append(vs, dapVariable{
@@ -593,18 +634,18 @@ func (l *dbg) handle(h dapHeader, b []byte) error {
 								}
 							case 2:
 								s := 0
-								e := len(l.vm.tvm.Scratch.Items)
+								e := len(b.Scratch.Items)
 
 								if vreq.Arguments.Start != nil {
 									s = *vreq.Arguments.Start
 								}
 
-								if vreq.Arguments.Count != nil {
+								if vreq.Arguments.Count != nil && s+*vreq.Arguments.Count < e {
 									e = s + *vreq.Arguments.Count
 								}
 
 								for i := s; i < e; i++ {
-									v := l.vm.tvm.Scratch.Items[i]
+									v := b.Scratch.Items[i]
 									if v.T != teal.VmTypeNone {
 										vs = append(vs, dapVariable{
 											Name:  strconv.Itoa(i),
@@ -631,6 +672,13 @@ func (l *dbg) handle(h dapHeader, b []byte) error {
 										Value: v.String(),
 									})
 								}
+							case 4:
+								for i, v := range b.Logs {
+									vs = append(vs, dapVariable{
+										Name:  strconv.Itoa(i),
+										Value: v.String(),
+									})
+								}
 							}
 						}
 					}
@@ -676,6 +724,13 @@ func (l *dbg) handle(h dapHeader, b []byte) error {
 							VariablesReference: 4 + 10*b.Id,
 							IndexedVariables:   &tracelen,
 						})
+
+						logslen := len(b.Logs)
+						ss = append(ss, dapScope{
+							Name:               "Logs",
+							VariablesReference: 5 + 10*b.Id,
+							IndexedVariables:   &logslen,
+						})
 					}
 				}
 			}
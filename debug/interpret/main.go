@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
 	"github.com/dragmz/teal"
@@ -9,26 +10,179 @@ import (
 )
 
 type args struct {
-	Path string
+	Path     string
+	Concrete bool
+	Ledger   string
+	App      uint64
+	Dryrun   string
+	Txn      int
+
+	MaxBranches int
+	MaxSteps    int
+	MaxUnroll   int
+
+	Inputs bool
+	Trace  string
 }
 
-func run(a args) error {
+func printInputs(cs []teal.VmConstraint, solve func() (teal.VmInputs, error)) {
+	for _, c := range cs {
+		fmt.Printf("  constraint: %s\n", c)
+	}
+
+	ins, err := solve()
+	if err != nil {
+		fmt.Printf("  inputs: %s\n", err)
+		return
+	}
+
+	for _, in := range ins.Sorted() {
+		fmt.Printf("  input: %s = %s\n", in, ins[in])
+	}
+}
+
+func load(a args) (*teal.Vm, error) {
+	opts := []teal.VmOption{
+		teal.WithMaxBranches(a.MaxBranches),
+		teal.WithMaxSteps(a.MaxSteps),
+		teal.WithMaxUnroll(a.MaxUnroll),
+	}
+
+	if a.Concrete {
+		opts = append(opts, teal.WithConcrete())
+	}
+
+	if a.Trace != "" {
+		opts = append(opts, teal.WithTrace())
+	}
+
+	if a.App != 0 {
+		opts = append(opts, teal.WithApp(a.App))
+	}
+
+	if a.Dryrun != "" {
+		bs, err := os.ReadFile(a.Dryrun)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read dryrun file")
+		}
+
+		d, err := teal.ReadDryrun(bs)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse dryrun file")
+		}
+
+		f := d.Field(a.Txn)
+		if f == "" {
+			return nil, errors.Errorf("transaction %d is not approved by a program", a.Txn)
+		}
+
+		return d.NewVm(a.Txn, f, opts...)
+	}
+
 	bs, err := os.ReadFile(a.Path)
 	if err != nil {
-		return errors.Wrap(err, "failed to read source file")
+		return nil, errors.Wrap(err, "failed to read source file")
 	}
 
 	res := teal.Process(string(bs))
 
-	vm := teal.NewVm(res)
+	if a.Ledger != "" {
+		lbs, err := os.ReadFile(a.Ledger)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read ledger file")
+		}
+
+		l, err := teal.ReadMemoryLedger(lbs)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse ledger file")
+		}
+
+		opts = append(opts, teal.WithLedger(l))
+	}
+
+	return teal.NewVm(res, opts...), nil
+}
+
+func run(a args) error {
+	vm, err := load(a)
+	if err != nil {
+		return err
+	}
 
 	vm.Run()
+
+	if a.Trace != "" {
+		f, err := os.Create(a.Trace)
+		if err != nil {
+			return errors.Wrap(err, "failed to create trace file")
+		}
+		defer f.Close()
+
+		err = vm.WriteTrace(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	if vm.Error != nil {
+		return errors.Errorf("program failed at line %d: %s", vm.ErrorLine+1, vm.Error)
+	}
+
+	for _, b := range vm.Branches {
+		fmt.Printf("%s: %v\n", b.Name, b.Stack.Items)
+
+		if b.Outcome != teal.VmRunning {
+			fmt.Printf("  outcome: %s\n", b.Outcome)
+		}
+
+		if b.Outcome == teal.VmMerged {
+			fmt.Printf("  merged with: %d\n", b.MergedWith)
+		}
+
+		if b.Err != nil {
+			fmt.Printf("  error: %s\n", b.Err)
+		}
+
+		for i, l := range b.Logs {
+			fmt.Printf("  log %d: %s\n", i, l)
+		}
+
+		if a.Inputs && b.Outcome != teal.VmMerged && b.Outcome != teal.VmCut {
+			printInputs(b.Constraints, b.Solve)
+		}
+	}
+
+	if a.Inputs {
+		for _, f := range vm.Failures {
+			fmt.Printf("assert failure: branch %d at line %d\n", f.Branch, f.Line+1)
+			printInputs(f.Constraints, f.Solve)
+		}
+	}
+
+	for _, c := range vm.Cuts {
+		if c.Target != "" {
+			fmt.Printf("cut: branch %d at line %d to %s: %s\n", c.Branch, c.Line+1, c.Target, c.Reason)
+		} else {
+			fmt.Printf("cut: branch %d at line %d: %s\n", c.Branch, c.Line+1, c.Reason)
+		}
+	}
+
 	return nil
 }
 
 func main() {
 	var a args
 	flag.StringVar(&a.Path, "path", "", "source file path")
+	flag.BoolVar(&a.Concrete, "concrete", false, "compute actual values")
+	flag.StringVar(&a.Ledger, "ledger", "", "ledger json file path")
+	flag.Uint64Var(&a.App, "app", 0, "current app id")
+	flag.StringVar(&a.Dryrun, "dryrun", "", "dryrun or simulate request json file path")
+	flag.IntVar(&a.Txn, "txn", 0, "index of the dryrun transaction to execute")
+	flag.IntVar(&a.MaxBranches, "max-branches", teal.DefaultMaxBranches, "max number of branches, 0 for no limit")
+	flag.IntVar(&a.MaxSteps, "max-steps", teal.DefaultMaxSteps, "max number of steps of all branches, 0 for no limit")
+	flag.IntVar(&a.MaxUnroll, "max-unroll", teal.DefaultMaxUnroll, "max number of loop iterations per branch, 0 for no limit")
+	flag.BoolVar(&a.Inputs, "inputs", false, "print the path constraints and the inputs that reach each branch and assert failure")
+	flag.StringVar(&a.Trace, "trace", "", "trace json output file path")
 	flag.Parse()
 
 	err := run(a)
@@ -17,9 +17,6 @@ type args struct {
 	Algod      string
 	AlgodToken string
 
-	DevAlgod      string
-	DevAlgodToken string
-
 	Round uint64
 }
 
@@ -29,11 +26,6 @@ func run(a args) error {
 		return errors.Wrap(err, "failed to make algod client")
 	}
 
-	dac, err := algod.MakeClient(a.DevAlgod, a.DevAlgodToken)
-	if err != nil {
-		return errors.Wrap(err, "failed to make dev algod client")
-	}
-
 	if a.Round == 0 {
 		status, err := ac.Status().Do(context.Background())
 		if err != nil {
@@ -63,12 +55,12 @@ func run(a args) error {
 				err := func() error {
 					fmt.Println("Program length:", len(tx.Txn.ApprovalProgram))
 
-					resp, err := dac.TealDisassemble(tx.Txn.ApprovalProgram).Do(context.Background())
+					l, err := teal.Disassemble(tx.Txn.ApprovalProgram)
 					if err != nil {
 						return errors.Wrap(err, "failed to disassemble")
 					}
 
-					res := teal.Process(resp.Result)
+					res := teal.Process(l.String())
 					if len(res.Diagnostics) > 0 {
 						for _, err := range res.Diagnostics {
 							fmt.Printf("%d:%d:%d: %s\n", b.Round, txidx, err.Line(), err)
@@ -99,9 +91,6 @@ func main() {
 	flag.StringVar(&a.Algod, "algod", "https://mainnet-api.algonode.network", "algod address")
 	flag.StringVar(&a.AlgodToken, "algod-token", "", "algod token")
 
-	flag.StringVar(&a.DevAlgod, "dev-algod", "", "dev algod token")
-	flag.StringVar(&a.DevAlgodToken, "dev-algod-token", "", "dev algod token")
-
 	flag.Uint64Var(&a.Round, "round", 0, "first round to process")
 
 	flag.Parse()
@@ -0,0 +1,463 @@
+package teal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+var langOpsByCode = func() map[byte]LangOp {
+	res := map[byte]LangOp{}
+	for _, op := range BuiltInLangSpec.Ops {
+		res[op.Opcode] = op
+	}
+	return res
+}()
+
+var langOpsImms = func() map[byte][]immArg {
+	res := map[byte][]immArg{}
+	for _, op := range BuiltInLangSpec.Ops {
+		l := &immLexer{s: []byte(op.ImmediateNote)}
+
+		args, err := l.tokenize()
+		if err != nil {
+			panic(errors.Wrapf(err, "failed to parse immediate note of %s", op.Name))
+		}
+
+		res[op.Opcode] = args
+	}
+	return res
+}()
+
+var disSimpleOps = func() map[string]Op {
+	res := map[string]Op{}
+	for _, op := range []Op{
+		Err, Sha256, Keccak256, Sha512256, ED25519Verify, PlusOp, MinusOp, Div, Mul, Lt, Gt, Le, Ge, And, Or, Eq, Neq, Not, Len, Itob, Btoi, Modulo,
+		BitOr, BitAnd, BitXor, BitNot, Mulw, Addw, DivModw, Intc0, Intc1, Intc2, Intc3, Bytec0, Bytec1, Bytec2, Bytec3, Arg0, Arg1, Arg2, Arg3,
+		Gaids, Loads, Stores, Return, Assert, Pop, Dup, Dup2, Swap, Select, Concat, Substring3, GetBit, SetBit, GetByte, SetByte, Extract3,
+		Extract16Bits, Extract32Bits, Extract64Bits, Replace3, Balance, AppOptedIn, AppLocalGet, AppLocalGetEx, AppGlobalGet, AppGlobalGetEx,
+		AppLocalPut, AppGlobalPut, AppLocalDel, AppGlobalDel, MinBalanceOp, Ed25519VerifyBare, RetSub, ShiftLeft, ShiftRight, Sqrt, BitLen, Exp,
+		Expw, Bsqrt, Divw, Sha3256, BytesPlus, BytesMinus, BytesDiv, BytesMul, BytesLt, BytesGt, BytesLe, BytesGe, BytesEq, BytesNeq, BytesModulo,
+		BytesBitOr, BytesBitAnd, BytesBitXor, BytesBitNot, BytesZero, Log, ItxnBegin, ItxnSubmit, ItxnNext, BoxCreate, BoxExtract, BoxReplace,
+		BoxDel, BoxLen, BoxGet, BoxPut, Args, Gloadss,
+	} {
+		res[op.String()] = op
+	}
+	return res
+}()
+
+type disInstr struct {
+	pc  int
+	end int
+
+	spec LangOp
+
+	args    []uint64
+	ints    []uint64
+	bytess  [][]byte
+	offsets []int
+}
+
+type disassembler struct {
+	bs []byte
+	i  int
+}
+
+func (d *disassembler) readByte() (byte, error) {
+	if d.i >= len(d.bs) {
+		return 0, errors.Errorf("unexpected end of program at pc %d", d.i)
+	}
+
+	b := d.bs[d.i]
+	d.i++
+
+	return b, nil
+}
+
+func (d *disassembler) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.bs[d.i:])
+	if n <= 0 {
+		return 0, errors.Errorf("invalid varuint at pc %d", d.i)
+	}
+
+	d.i += n
+
+	return v, nil
+}
+
+func (d *disassembler) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.bs)-d.i) {
+		return nil, errors.Errorf("unexpected end of program at pc %d", d.i)
+	}
+
+	v := d.bs[d.i : d.i+int(n)]
+	d.i += int(n)
+
+	return v, nil
+}
+
+func (d *disassembler) readOffset() (int, error) {
+	bs, err := d.readBytes(2)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(int16(binary.BigEndian.Uint16(bs))), nil
+}
+
+func (d *disassembler) readImm(arg immArg, in *disInstr) error {
+	switch arg.t {
+	case "uint8", "int8":
+		v, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		in.args = append(in.args, uint64(v))
+	case "varuint":
+		v, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		in.args = append(in.args, v)
+	case "int16":
+		v, err := d.readOffset()
+		if err != nil {
+			return err
+		}
+		in.offsets = append(in.offsets, v)
+	case "bytes":
+		if len(in.args) == 0 {
+			return errors.Errorf("missing length of %s bytes", in.spec.Name)
+		}
+
+		v, err := d.readBytes(in.args[len(in.args)-1])
+		if err != nil {
+			return err
+		}
+		in.args = in.args[:len(in.args)-1]
+		in.bytess = append(in.bytess, v)
+	default:
+		return errors.Errorf("unsupported immediate argument of %s: %s", in.spec.Name, arg.v)
+	}
+
+	return nil
+}
+
+func (d *disassembler) readArray(arg immArg, in *disInstr) error {
+	if len(in.args) == 0 || len(arg.r) == 0 {
+		return errors.Errorf("missing count of %s items", in.spec.Name)
+	}
+
+	n := in.args[len(in.args)-1]
+	in.args = in.args[:len(in.args)-1]
+
+	item := arg.r[0]
+
+	for i := uint64(0); i < n; i++ {
+		switch {
+		case item.sub != nil:
+			l, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+
+			v, err := d.readBytes(l)
+			if err != nil {
+				return err
+			}
+
+			in.bytess = append(in.bytess, v)
+		case item.t == "varuint":
+			v, err := d.readUvarint()
+			if err != nil {
+				return err
+			}
+
+			in.ints = append(in.ints, v)
+		default:
+			err := d.readImm(item, in)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *disassembler) read() (*disInstr, error) {
+	pc := d.i
+
+	code, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	spec, ok := langOpsByCode[code]
+	if !ok {
+		return nil, errors.Errorf("invalid opcode 0x%02x at pc %d", code, pc)
+	}
+
+	in := &disInstr{
+		pc:   pc,
+		spec: spec,
+	}
+
+	for _, arg := range langOpsImms[code] {
+		switch arg.kind {
+		case immArray:
+			err = d.readArray(arg, in)
+		default:
+			err = d.readImm(arg, in)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s at pc %d", spec.Name, pc)
+		}
+	}
+
+	in.end = d.i
+
+	return in, nil
+}
+
+func disTxnField(v uint64) (TxnField, error) {
+	f := TxnField(v)
+	if _, ok := txnFieldSpecByField(f); !ok {
+		return f, errors.Errorf("invalid txn field: %d", v)
+	}
+	return f, nil
+}
+
+func (in *disInstr) op(labels map[int]string) (Op, error) {
+	a := in.args
+
+	targets := make([]*LabelExpr, len(in.offsets))
+	for i, offset := range in.offsets {
+		targets[i] = &LabelExpr{Name: labels[in.end+offset]}
+	}
+
+	switch in.spec.Name {
+	case "intcblock":
+		return &IntcBlockExpr{Values: in.ints}, nil
+	case "bytecblock":
+		return &BytecBlockExpr{Values: in.bytess}, nil
+	case "pushint":
+		return &PushIntExpr{Value: a[0]}, nil
+	case "pushbytes":
+		return &PushBytesExpr{Value: in.bytess[0]}, nil
+	case "pushints":
+		return &PushIntsExpr{Ints: in.ints}, nil
+	case "pushbytess":
+		return &PushBytessExpr{Bytess: in.bytess}, nil
+
+	case "b":
+		return &BExpr{Label: targets[0]}, nil
+	case "bz":
+		return &BzExpr{Label: targets[0]}, nil
+	case "bnz":
+		return &BnzExpr{Label: targets[0]}, nil
+	case "callsub":
+		return &CallSubExpr{Label: targets[0]}, nil
+	case "switch":
+		return &SwitchExpr{Targets: targets}, nil
+	case "match":
+		return &MatchExpr{Targets: targets}, nil
+
+	case "ecdsa_verify":
+		return &EcdsaVerifyExpr{Index: EcdsaCurve(a[0])}, nil
+	case "ecdsa_pk_decompress":
+		return &EcdsaPkDecompressExpr{Index: EcdsaCurve(a[0])}, nil
+	case "ecdsa_pk_recover":
+		return &EcdsaPkRecoverExpr{Index: EcdsaCurve(a[0])}, nil
+	case "intc":
+		return &IntcExpr{Index: uint8(a[0])}, nil
+	case "bytec":
+		return &BytecExpr{Index: uint8(a[0])}, nil
+	case "arg":
+		return &ArgExpr{Index: uint8(a[0])}, nil
+	case "global":
+		f := GlobalField(a[0])
+		if _, ok := globalFieldSpecByField(f); !ok {
+			return nil, errors.Errorf("invalid global field: %d", a[0])
+		}
+		return &GlobalExpr{Field: f}, nil
+	case "load":
+		return &LoadExpr{Index: uint8(a[0])}, nil
+	case "store":
+		return &StoreExpr{Index: uint8(a[0])}, nil
+	case "gload":
+		return &GloadExpr{Group: uint8(a[0]), Index: uint8(a[1])}, nil
+	case "gloads":
+		return &GloadsExpr{Index: uint8(a[0])}, nil
+	case "gaid":
+		return &GaidExpr{Group: uint8(a[0])}, nil
+	case "bury":
+		return &BuryExpr{Depth: uint8(a[0])}, nil
+	case "popn":
+		return &PopNExpr{Depth: uint8(a[0])}, nil
+	case "dupn":
+		return &DupNExpr{Count: uint8(a[0])}, nil
+	case "dig":
+		return &DigExpr{Index: uint8(a[0])}, nil
+	case "cover":
+		return &CoverExpr{Depth: uint8(a[0])}, nil
+	case "uncover":
+		return &UncoverExpr{Depth: uint8(a[0])}, nil
+	case "substring":
+		return &SubstringExpr{Start: uint8(a[0]), End: uint8(a[1])}, nil
+	case "extract":
+		return &ExtractExpr{Start: uint8(a[0]), Length: uint8(a[1])}, nil
+	case "replace2":
+		return &Replace2Expr{Start: uint8(a[0])}, nil
+	case "base64_decode":
+		return &Base64DecodeExpr{Index: uint8(a[0])}, nil
+	case "json_ref":
+		return &JsonRefExpr{Index: uint8(a[0])}, nil
+	case "asset_holding_get":
+		return &AssetHoldingGetExpr{Field: AssetHoldingField(a[0])}, nil
+	case "asset_params_get":
+		return &AssetParamsGetExpr{Field: AssetParamsField(a[0])}, nil
+	case "app_params_get":
+		return &AppParamsGetExpr{Field: AppParamsField(a[0])}, nil
+	case "acct_params_get":
+		return &AcctParamsGetExpr{Field: AcctParamsField(a[0])}, nil
+	case "proto":
+		return &ProtoExpr{Args: uint8(a[0]), Results: uint8(a[1])}, nil
+	case "frame_dig":
+		return &FrameDigExpr{Index: int8(a[0])}, nil
+	case "frame_bury":
+		return &FrameBuryExpr{Index: int8(a[0])}, nil
+	case "vrf_verify":
+		return &VrfVerifyExpr{Field: VrfStandard(a[0])}, nil
+	case "block":
+		return &BlockExpr{Field: BlockField(a[0])}, nil
+	case "gtxn", "gtxna", "gitxn", "gitxna", "gtxnas", "gitxnas":
+		f, err := disTxnField(a[1])
+		if err != nil {
+			return nil, err
+		}
+
+		switch in.spec.Name {
+		case "gtxn":
+			return &GtxnExpr{Group: uint8(a[0]), Field: f}, nil
+		case "gtxna":
+			return &GtxnaExpr{Group: uint8(a[0]), Field: f, Index: uint8(a[2])}, nil
+		case "gitxn":
+			return &GitxnExpr{Index: uint8(a[0]), Field: f}, nil
+		case "gitxna":
+			return &GitxnaExpr{Group: uint8(a[0]), Field: f, Index: uint8(a[2])}, nil
+		case "gtxnas":
+			return &GtxnasExpr{Index: uint8(a[0]), Field: f}, nil
+		default:
+			return &GitxnasExpr{Index: uint8(a[0]), Field: f}, nil
+		}
+	case "txn", "txna", "gtxns", "gtxnsa", "itxn_field", "itxn", "itxna", "txnas", "gtxnsas", "itxnas":
+		f, err := disTxnField(a[0])
+		if err != nil {
+			return nil, err
+		}
+
+		switch in.spec.Name {
+		case "txn":
+			return &TxnExpr{Field: f}, nil
+		case "txna":
+			return &TxnaExpr{Field: f, Index: uint8(a[1])}, nil
+		case "gtxns":
+			return &GtxnsExpr{Field: f}, nil
+		case "gtxnsa":
+			return &GtxnsaExpr{Field: f, Index: uint8(a[1])}, nil
+		case "itxn_field":
+			return &ItxnFieldExpr{Field: f}, nil
+		case "itxn":
+			return &ItxnExpr{Field: f}, nil
+		case "itxna":
+			return &ItxnaExpr{Field: f, Index: uint8(a[1])}, nil
+		case "txnas":
+			return &TxnasExpr{Field: f}, nil
+		case "gtxnsas":
+			return &GtxnsasExpr{Field: f}, nil
+		default:
+			return &ItxnasExpr{Field: f}, nil
+		}
+	}
+
+	op, ok := disSimpleOps[in.spec.Name]
+	if !ok {
+		return nil, errors.Errorf("unsupported opcode: %s", in.spec.Name)
+	}
+
+	return op, nil
+}
+
+// Disassemble decodes AVM bytecode into a listing, generating labels for branch targets.
+func Disassemble(bs []byte) (Listing, error) {
+	d := &disassembler{bs: bs}
+
+	version, err := d.readUvarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read version")
+	}
+
+	var ins []*disInstr
+	starts := map[int]bool{}
+
+	for d.i < len(d.bs) {
+		in, err := d.read()
+		if err != nil {
+			return nil, err
+		}
+
+		starts[in.pc] = true
+		ins = append(ins, in)
+	}
+
+	starts[len(d.bs)] = true
+
+	var pcs []int
+	targets := map[int]bool{}
+
+	for _, in := range ins {
+		for _, offset := range in.offsets {
+			target := in.end + offset
+			if !starts[target] {
+				return nil, errors.Errorf("invalid branch target at pc %d: %d", in.pc, target)
+			}
+
+			if !targets[target] {
+				targets[target] = true
+				pcs = append(pcs, target)
+			}
+		}
+	}
+
+	sort.Ints(pcs)
+
+	labels := map[int]string{}
+	for i, pc := range pcs {
+		labels[pc] = fmt.Sprintf("label%d", i+1)
+	}
+
+	l := Listing{&PragmaExpr{Version: uint8(version)}}
+
+	for _, in := range ins {
+		if name, ok := labels[in.pc]; ok {
+			l = append(l, &LabelExpr{Name: name})
+		}
+
+		op, err := in.op(labels)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode pc %d", in.pc)
+		}
+
+		l = append(l, op)
+	}
+
+	if name, ok := labels[len(d.bs)]; ok {
+		l = append(l, &LabelExpr{Name: name})
+	}
+
+	return l, nil
+}
@@ -0,0 +1,156 @@
+package teal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testRoundTrip(t *testing.T, name string, prog []byte) {
+	l, err := Disassemble(prog)
+	if err != nil {
+		t.Errorf("failed to disassemble %s: %s", name, err)
+		return
+	}
+
+	res := Process(l.String())
+	for _, d := range res.Diagnostics {
+		if d.Severity() == DiagErr {
+			t.Errorf("unexpected diagnostic in disassembled %s at line %d: %s", name, d.Line(), d)
+		}
+	}
+
+	actual, err := res.Listing.Assemble(res.Version)
+	if err != nil {
+		t.Errorf("failed to reassemble %s: %s", name, err)
+		return
+	}
+
+	if !bytes.Equal(prog, actual) {
+		t.Errorf("unexpected bytecode after round trip of %s - expected: %s, actual: %s\n%s", name, hex.EncodeToString(prog), hex.EncodeToString(actual), l)
+	}
+}
+
+func TestDisassembleExamples(t *testing.T) {
+	fs, err := os.ReadDir(filepath.Join("examples", "ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range fs {
+		bs, err := os.ReadFile(filepath.Join("examples", "ok", f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res := Process(string(bs))
+
+		prog, err := res.Listing.Assemble(res.Version)
+		if err != nil {
+			continue
+		}
+
+		testRoundTrip(t, f.Name(), prog)
+	}
+}
+
+func TestDisassembleImmediates(t *testing.T) {
+	src := strings.Join([]string{
+		"#pragma version 8",
+		"intcblock 1 2 300",
+		"bytecblock 0x01 0x0203",
+		"main:",
+		"intc 2",
+		"bytec 1",
+		"txn Sender",
+		"global GroupSize",
+		"gtxn 1 Amount",
+		"txna Accounts 1",
+		"gtxna 0 ApplicationArgs 2",
+		"gtxns Fee",
+		"gtxnsa Accounts 0",
+		"txnas Accounts",
+		"gtxnas 1 Accounts",
+		"gtxnsas ApplicationArgs",
+		"load 3",
+		"store 4",
+		"gload 1 2",
+		"gloads 3",
+		"gaid 2",
+		"bury 1",
+		"popn 2",
+		"dupn 3",
+		"dig 1",
+		"cover 2",
+		"uncover 3",
+		"substring 1 2",
+		"extract 1 2",
+		"replace2 3",
+		"base64_decode URLEncoding",
+		"json_ref JSONString",
+		"asset_holding_get AssetBalance",
+		"asset_params_get AssetTotal",
+		"app_params_get AppCreator",
+		"acct_params_get AcctBalance",
+		"ecdsa_verify Secp256k1",
+		"itxn_begin",
+		"itxn_field Fee",
+		"itxn_submit",
+		"itxn Fee",
+		"itxna Logs 1",
+		"itxnas Logs",
+		"gitxn 0 Fee",
+		"gitxna 1 Logs 2",
+		"gitxnas 0 Logs",
+		"vrf_verify VrfAlgorand",
+		"block BlkSeed",
+		"pushint 1000",
+		"pushbytes 0xabcd",
+		"pushints 1 2",
+		"pushbytess 0x01 0x02",
+		"extract_uint64",
+		"bnz main",
+		"bz end",
+		"switch main end",
+		"match main end",
+		"callsub sub",
+		"b end",
+		"sub:",
+		"proto 1 2",
+		"frame_dig -1",
+		"frame_bury 0",
+		"retsub",
+		"end:",
+	}, "\n")
+
+	res := Process(src)
+
+	prog, err := res.Listing.Assemble(res.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testRoundTrip(t, "immediates", prog)
+}
+
+func TestDisassembleInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"08ff",
+		"084200",
+		"08420005",
+		"088004aa",
+	}
+
+	for _, test := range tests {
+		bs, _ := hex.DecodeString(test)
+
+		_, err := Disassemble(bs)
+		if err == nil {
+			t.Errorf("expected %s to fail to disassemble", test)
+		}
+	}
+}
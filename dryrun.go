@@ -0,0 +1,302 @@
+package teal
+
+import (
+	"encoding/base64"
+
+	"github.com/algorand/go-algorand-sdk/client/v2/common/models"
+	"github.com/algorand/go-algorand-sdk/encoding/json"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	DryrunLogicSig   = "lsig"
+	DryrunApproval   = "approv"
+	DryrunClearState = "clearp"
+)
+
+type simulateGroup struct {
+	Txns []types.SignedTxn `json:"txns"`
+}
+
+// dryrunRequest accepts both the dryrun and the simulate request formats
+type dryrunRequest struct {
+	models.DryrunRequest
+	TxnGroups []simulateGroup `json:"txn-groups"`
+}
+
+// Dryrun is a transaction group with the ledger state and the program sources it is executed against
+type Dryrun struct {
+	Group   []types.SignedTxn
+	Ledger  *MemoryLedger
+	Globals VmGlobals
+	Sources []models.DryrunSource
+}
+
+func memoryStateOf(kvs []models.TealKeyValue) (MemoryState, error) {
+	s := MemoryState{}
+
+	for _, kv := range kvs {
+		k, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key: %s", kv.Key)
+		}
+
+		switch kv.Value.Type {
+		case 1:
+			v, err := base64.StdEncoding.DecodeString(kv.Value.Bytes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid value of key: %s", k)
+			}
+			s[string(k)] = BytesValue(v)
+		case 2:
+			s[string(k)] = Uint64Value(kv.Value.Uint)
+		default:
+			return nil, errors.Errorf("invalid value type of key %s: %d", k, kv.Value.Type)
+		}
+	}
+
+	return s, nil
+}
+
+func memoryAppOf(p models.ApplicationParams) (*MemoryApp, error) {
+	g, err := memoryStateOf(p.GlobalState)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read global state")
+	}
+
+	return &MemoryApp{
+		Creator:            p.Creator,
+		ApprovalProgram:    p.ApprovalProgram,
+		ClearStateProgram:  p.ClearStateProgram,
+		GlobalNumUint:      p.GlobalStateSchema.NumUint,
+		GlobalNumByteSlice: p.GlobalStateSchema.NumByteSlice,
+		LocalNumUint:       p.LocalStateSchema.NumUint,
+		LocalNumByteSlice:  p.LocalStateSchema.NumByteSlice,
+		ExtraProgramPages:  p.ExtraProgramPages,
+		Global:             g,
+	}, nil
+}
+
+func memoryAssetOf(p models.AssetParams) *MemoryAsset {
+	return &MemoryAsset{
+		Creator:       p.Creator,
+		Total:         p.Total,
+		Decimals:      p.Decimals,
+		DefaultFrozen: p.DefaultFrozen,
+		UnitName:      p.UnitName,
+		Name:          p.Name,
+		URL:           p.Url,
+		MetadataHash:  p.MetadataHash,
+		Manager:       p.Manager,
+		Reserve:       p.Reserve,
+		Freeze:        p.Freeze,
+		Clawback:      p.Clawback,
+	}
+}
+
+func dryrunLedger(r models.DryrunRequest) (*MemoryLedger, error) {
+	l := NewMemoryLedger()
+
+	for _, a := range r.Accounts {
+		_, err := types.DecodeAddress(a.Address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid account address: %s", a.Address)
+		}
+
+		acc := &MemoryAccount{
+			Balance:  a.Amount,
+			AuthAddr: a.AuthAddr,
+			Assets:   map[uint64]*MemoryHolding{},
+			Apps:     map[uint64]MemoryState{},
+		}
+
+		for _, h := range a.Assets {
+			acc.Assets[h.AssetId] = &MemoryHolding{Amount: h.Amount, Frozen: h.IsFrozen}
+		}
+
+		for _, ls := range a.AppsLocalState {
+			s, err := memoryStateOf(ls.KeyValue)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read local state of app %d of account %s", ls.Id, a.Address)
+			}
+			acc.Apps[ls.Id] = s
+		}
+
+		for _, app := range a.CreatedApps {
+			ma, err := memoryAppOf(app.Params)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read app %d", app.Id)
+			}
+			if ma.Creator == "" {
+				ma.Creator = a.Address
+			}
+			l.Apps[app.Id] = ma
+		}
+
+		for _, asset := range a.CreatedAssets {
+			ma := memoryAssetOf(asset.Params)
+			if ma.Creator == "" {
+				ma.Creator = a.Address
+			}
+			l.Assets[asset.Index] = ma
+		}
+
+		l.Accounts[a.Address] = acc
+	}
+
+	for _, app := range r.Apps {
+		ma, err := memoryAppOf(app.Params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read app %d", app.Id)
+		}
+		l.Apps[app.Id] = ma
+	}
+
+	return l, nil
+}
+
+// ReadDryrun reads an algod dryrun request or a simulate request with a single transaction group
+func ReadDryrun(bs []byte) (*Dryrun, error) {
+	var r dryrunRequest
+
+	err := json.LenientDecode(bs, &r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse request")
+	}
+
+	group := r.Txns
+
+	if len(r.TxnGroups) > 0 {
+		if len(group) > 0 || len(r.TxnGroups) > 1 {
+			return nil, errors.New("only a single transaction group is supported")
+		}
+		group = r.TxnGroups[0].Txns
+	}
+
+	if len(group) == 0 {
+		return nil, errors.New("no transactions")
+	}
+
+	l, err := dryrunLedger(r.DryrunRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	g := DefaultGlobals()
+	g.Round = r.Round
+	g.LatestTimestamp = r.LatestTimestamp
+
+	return &Dryrun{
+		Group:   group,
+		Ledger:  l,
+		Globals: g,
+		Sources: r.Sources,
+	}, nil
+}
+
+// Field returns the kind of the program the transaction is approved by, or an empty string if there is none
+func (d *Dryrun) Field(i int) string {
+	if i < 0 || i >= len(d.Group) {
+		return ""
+	}
+
+	stx := d.Group[i]
+	if len(stx.Lsig.Logic) > 0 {
+		return DryrunLogicSig
+	}
+
+	for _, s := range d.Sources {
+		if s.FieldName == DryrunLogicSig && s.TxnIndex == uint64(i) {
+			return DryrunLogicSig
+		}
+	}
+
+	if stx.Txn.Type != types.ApplicationCallTx {
+		return ""
+	}
+
+	if stx.Txn.OnCompletion == types.ClearStateOC {
+		return DryrunClearState
+	}
+
+	return DryrunApproval
+}
+
+// Source returns the source of the program of the transaction, sources of the request take precedence
+// over the disassembled programs of the transaction and the ledger
+func (d *Dryrun) Source(i int, field string) (string, error) {
+	if i < 0 || i >= len(d.Group) {
+		return "", errors.Errorf("transaction index %d out of range (group size: %d)", i, len(d.Group))
+	}
+
+	txn := d.Group[i].Txn
+	app := uint64(txn.ApplicationID)
+
+	for _, s := range d.Sources {
+		if s.FieldName != field {
+			continue
+		}
+
+		if field == DryrunLogicSig && s.TxnIndex == uint64(i) || field != DryrunLogicSig && s.AppIndex == app {
+			return s.Source, nil
+		}
+	}
+
+	var prog []byte
+
+	switch field {
+	case DryrunLogicSig:
+		prog = d.Group[i].Lsig.Logic
+	case DryrunApproval, DryrunClearState:
+		if app == 0 {
+			prog = txn.ApprovalProgram
+			if field == DryrunClearState {
+				prog = txn.ClearStateProgram
+			}
+		} else if a, ok := d.Ledger.Apps[app]; ok {
+			prog = a.ApprovalProgram
+			if field == DryrunClearState {
+				prog = a.ClearStateProgram
+			}
+		}
+	default:
+		return "", errors.Errorf("unknown program field: %s", field)
+	}
+
+	if len(prog) == 0 {
+		return "", errors.Errorf("missing %s program of transaction %d", field, i)
+	}
+
+	l, err := Disassemble(prog)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to disassemble %s program of transaction %d", field, i)
+	}
+
+	return l.String(), nil
+}
+
+// NewVm creates a vm executing the program of the transaction against the group, the ledger and the globals
+func (d *Dryrun) NewVm(i int, field string, opts ...VmOption) (*Vm, error) {
+	src, err := d.Source(i, field)
+	if err != nil {
+		return nil, err
+	}
+
+	g := d.Globals
+	if a, ok := d.Ledger.Apps[uint64(d.Group[i].Txn.ApplicationID)]; ok && a.Creator != "" {
+		g.CreatorAddress, err = types.DecodeAddress(a.Creator)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid creator address: %s", a.Creator)
+		}
+	}
+
+	var mode ProgramMode = ModeApp
+	if field == DryrunLogicSig {
+		mode = ModeSig
+	}
+
+	opts = append([]VmOption{WithGroup(d.Group, i), WithLedger(d.Ledger), WithGlobals(g), WithMode(mode)}, opts...)
+
+	return NewVm(Process(src), opts...), nil
+}
@@ -0,0 +1,102 @@
+package teal
+
+import (
+	"testing"
+)
+
+const testDryrun = `{
+  "accounts": [
+    {
+      "address": "AEAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAKE3PRHE",
+      "amount": 2000000,
+      "apps-local-state": [{"id": 5, "key-value": [{"key": "bGV2ZWw=", "value": {"type": 2, "uint": 3}}]}]
+    }
+  ],
+  "apps": [
+    {
+      "id": 5,
+      "params": {
+        "creator": "AEAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAKE3PRHE",
+        "global-state": [{"key": "Y291bnRlcg==", "value": {"type": 2, "uint": 41}}]
+      }
+    }
+  ],
+  "round": 100,
+  "sources": [
+    {
+      "app-index": 5,
+      "field-name": "approv",
+      "source": "#pragma version 8\ntxna ApplicationArgs 0\nbyte \"inc\"\n==\nassert\nbyte \"counter\"\napp_global_get\nint 0\nbyte \"level\"\napp_local_get\n+\nglobal Round\n+\nglobal CreatorAddress\ntxn Sender\n==\nassert"
+    }
+  ],
+  "txns": [
+    {
+      "txn": {
+        "apaa": ["aW5j"],
+        "apid": 5,
+        "snd": "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+        "type": "appl"
+      }
+    }
+  ]
+}`
+
+func TestDryrun(t *testing.T) {
+	d, err := ReadDryrun([]byte(testDryrun))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f := d.Field(0); f != DryrunApproval {
+		t.Fatalf("unexpected program field: %s", f)
+	}
+
+	vm, err := d.NewVm(0, d.Field(0), WithConcrete())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm.Run()
+
+	if vm.Error != nil {
+		t.Fatal(vm.Error)
+	}
+
+	items := vm.Branches[0].Stack.Items
+	if len(items) != 1 || !items[0].equal(Uint64Value(144)) {
+		t.Errorf("unexpected stack: %v", items)
+	}
+}
+
+func TestDryrunSimulate(t *testing.T) {
+	d, err := ReadDryrun([]byte(`{
+  "txn-groups": [
+    {
+      "txns": [
+        {"txn": {"amt": 5, "rcv": "AQAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", "type": "pay"}},
+        {"txn": {"apap": "CIEBQw==", "apsu": "CIEBQw==", "type": "appl"}}
+      ]
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Group) != 2 {
+		t.Fatalf("unexpected group size: %d", len(d.Group))
+	}
+
+	if f := d.Field(0); f != "" {
+		t.Errorf("unexpected program field of payment: %s", f)
+	}
+
+	src, err := d.Source(1, DryrunApproval)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if src != "#pragma version 8\npushint 1\nreturn\n" {
+		t.Errorf("unexpected source: %q", src)
+	}
+}
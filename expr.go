@@ -2,10 +2,17 @@ package teal
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
 	"strconv"
 	"strings"
+
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
 )
 
 type Branch interface {
@@ -21,8 +28,7 @@ type Nop interface {
 type ErrExpr struct{}
 
 func (e *ErrExpr) Execute(b *VmBranch) error {
-	b.exit()
-	return nil
+	return b.fail(errors.New("err opcode executed"))
 }
 
 func (e *ErrExpr) String() string {
@@ -46,10 +52,9 @@ func (e *Sha3256Expr) Cost(b *VmBranch) []int {
 }
 
 func (e *Sha3256Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmSha3256(vs[0].b()))}, nil
+	})
 }
 
 var Sha3256 = &Sha3256Expr{}
@@ -72,11 +77,9 @@ func (e *Sha256Expr) Cost(b *VmBranch) []int {
 }
 
 func (e *Sha256Expr) Execute(b *VmBranch) error {
-	v := b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes, src: vmOpSource{e: e, args: []vmSource{v}}})
-
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmSha256(vs[0].b()))}, nil
+	})
 }
 
 func (e *Sha256Expr) Name() string {
@@ -103,11 +106,9 @@ func (e *Keccak256Expr) Cost(b *VmBranch) []int {
 }
 
 func (e *Keccak256Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmKeccak256(vs[0].b()))}, nil
+	})
 }
 
 var Keccak256 = &Keccak256Expr{}
@@ -130,11 +131,9 @@ func (e *Sha512256Expr) Cost(b *VmBranch) []int {
 }
 
 func (e *Sha512256Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmSha512256(vs[0].b()))}, nil
+	})
 }
 
 var Sha512256 = &Sha512256Expr{}
@@ -152,15 +151,20 @@ func (e *ED25519VerifyExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *ED25519VerifyExpr) Execute(b *VmBranch) error {
-	v1 := b.pop(VmTypeBytes)
-	v2 := b.pop(VmTypeBytes)
-	v3 := b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64, src: vmOpSource{e: e, args: []vmSource{
-		v1, v2, v3,
-	}}})
+	return b.eval(e, "BBB", "U", func(vs []VmValue) ([]VmValue, error) {
+		h, err := b.vm.programHash()
+		if err != nil {
+			return nil, err
+		}
 
-	b.Line++
-	return nil
+		data := append(append([]byte("ProgData"), h...), vs[0].b()...)
+
+		ok, err := avmEd25519Verify(data, vs[1].b(), vs[2].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(ok)}, nil
+	})
 }
 
 func (e *ED25519VerifyExpr) Name() string {
@@ -188,16 +192,13 @@ func (e *EcdsaVerifyExpr) Name() string {
 }
 
 func (e *EcdsaVerifyExpr) Execute(b *VmBranch) error {
-	v1 := b.pop(VmTypeBytes)
-	v2 := b.pop(VmTypeBytes)
-	v3 := b.pop(VmTypeBytes)
-	v4 := b.pop(VmTypeBytes)
-	v5 := b.pop(VmTypeBytes)
-
-	b.push(VmValue{T: VmTypeUint64, src: vmOpSource{e: e, args: []vmSource{v1, v2, v3, v4, v5}}})
-
-	b.Line++
-	return nil
+	return b.eval(e, "BBBBB", "U", func(vs []VmValue) ([]VmValue, error) {
+		ok, err := avmEcdsaVerify(e.Index, vs[0].b(), vs[1].b(), vs[2].b(), vs[3].b(), vs[4].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(ok)}, nil
+	})
 }
 
 // ecdsa_pk_decompress
@@ -211,12 +212,13 @@ func (e *EcdsaPkDecompressExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *EcdsaPkDecompressExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.eval(e, "B", "BB", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmEcdsaDecompress(e.Index, vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(x), BytesValue(y)}, nil
+	})
 }
 
 func (e *EcdsaPkDecompressExpr) String() string {
@@ -234,15 +236,13 @@ func (e *EcdsaPkRecoverExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *EcdsaPkRecoverExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.eval(e, "BUBB", "BB", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmEcdsaRecover(e.Index, vs[0].b(), vs[1].u(), vs[2].b(), vs[3].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(x), BytesValue(y)}, nil
+	})
 }
 
 func (e *EcdsaPkRecoverExpr) String() string {
@@ -259,12 +259,30 @@ func (e *PlusExpr) String() string {
 }
 
 func (e *PlusExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, c := bits.Add64(vs[0].u(), vs[1].u(), 0)
+		if c != 0 {
+			return nil, errors.New("overflow")
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *PlusExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	alo, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	lo, c := bits.Add64(alo, blo, 0)
+	if c != 0 {
+		return 0, math.MaxUint64
+	}
+
+	hi, c := bits.Add64(ahi, bhi, 0)
+	if c != 0 {
+		hi = math.MaxUint64
+	}
+
+	return lo, hi
 }
 
 var PlusOp = &PlusExpr{}
@@ -275,18 +293,34 @@ type MinusExpr struct {
 }
 
 func (e *MinusExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, c := bits.Sub64(vs[0].u(), vs[1].u(), 0)
+		if c != 0 {
+			return nil, errors.New("underflow")
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
 }
 
 func (e *MinusExpr) String() string {
 	return "-"
 }
 
+func (e *MinusExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	alo, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	var lo, hi uint64
+	if alo > bhi {
+		lo = alo - bhi
+	}
+	if ahi > blo {
+		hi = ahi - blo
+	}
+
+	return lo, hi
+}
+
 var MinusOp = &MinusExpr{}
 
 // /
@@ -298,12 +332,12 @@ func (e *DivExpr) String() string {
 }
 
 func (e *DivExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[1].u() == 0 {
+			return nil, errors.New("divide by zero")
+		}
+		return []VmValue{Uint64Value(vs[0].u() / vs[1].u())}, nil
+	})
 }
 
 var Div = &DivExpr{}
@@ -317,12 +351,13 @@ func (e *MulExpr) String() string {
 }
 
 func (e *MulExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		hi, lo := bits.Mul64(vs[0].u(), vs[1].u())
+		if hi != 0 {
+			return nil, errors.New("overflow")
+		}
+		return []VmValue{Uint64Value(lo)}, nil
+	})
 }
 
 var Mul = &MulExpr{}
@@ -337,12 +372,23 @@ func (e *LtExpr) String() string {
 }
 
 func (e *LtExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() < vs[1].u())}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *LtExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	alo, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	switch {
+	case ahi < blo:
+		return 1, 1
+	case alo >= bhi:
+		return 0, 0
+	default:
+		return 0, 1
+	}
 }
 
 var Lt = &LtExpr{}
@@ -353,18 +399,29 @@ type GtExpr struct {
 }
 
 func (e *GtExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() > vs[1].u())}, nil
+	})
 }
 
 func (e *GtExpr) String() string {
 	return ">"
 }
 
+func (e *GtExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	alo, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	switch {
+	case alo > bhi:
+		return 1, 1
+	case ahi <= blo:
+		return 0, 0
+	default:
+		return 0, 1
+	}
+}
+
 var Gt = &GtExpr{}
 
 // <=
@@ -373,18 +430,29 @@ type LtEqExpr struct {
 }
 
 func (e *LtEqExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() <= vs[1].u())}, nil
+	})
 }
 
 func (e *LtEqExpr) String() string {
 	return "<="
 }
 
+func (e *LtEqExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	alo, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	switch {
+	case ahi <= blo:
+		return 1, 1
+	case alo > bhi:
+		return 0, 0
+	default:
+		return 0, 1
+	}
+}
+
 var Le = &LtEqExpr{}
 
 // >=
@@ -397,12 +465,23 @@ func (e *GtEqExpr) String() string {
 }
 
 func (e *GtEqExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() >= vs[1].u())}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *GtEqExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	alo, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	switch {
+	case alo >= bhi:
+		return 1, 1
+	case ahi < blo:
+		return 0, 0
+	default:
+		return 0, 1
+	}
 }
 
 var Ge = &GtEqExpr{}
@@ -413,18 +492,29 @@ type AndExpr struct {
 }
 
 func (e *AndExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() != 0 && vs[1].u() != 0)}, nil
+	})
 }
 
 func (e *AndExpr) String() string {
 	return "&&"
 }
 
+func (e *AndExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	a, aok := vs[0].truth()
+	b, bok := vs[1].truth()
+
+	switch {
+	case aok && !a || bok && !b:
+		return 0, 0
+	case aok && bok:
+		return 1, 1
+	default:
+		return 0, 1
+	}
+}
+
 var And = &AndExpr{}
 
 // ||
@@ -437,12 +527,23 @@ func (e *OrExpr) String() string {
 }
 
 func (e *OrExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() != 0 || vs[1].u() != 0)}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *OrExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	a, aok := vs[0].truth()
+	b, bok := vs[1].truth()
+
+	switch {
+	case aok && a || bok && b:
+		return 1, 1
+	case aok && bok:
+		return 0, 0
+	default:
+		return 0, 1
+	}
 }
 
 var Or = &OrExpr{}
@@ -453,18 +554,31 @@ type EqExpr struct {
 }
 
 func (e *EqExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
-	b.pop(VmTypeAny)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "..", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[0].T != vs[1].T {
+			return nil, errors.Errorf("cannot compare %s to %s", vs[0].T, vs[1].T)
+		}
+		return []VmValue{vmBool(vs[0].equal(vs[1]))}, nil
+	})
 }
 
 func (e *EqExpr) String() string {
 	return "=="
 }
 
+func (e *EqExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	if vs[0].T == VmTypeUint64 && vs[1].T == VmTypeUint64 {
+		alo, ahi := vs[0].bounds()
+		blo, bhi := vs[1].bounds()
+
+		if ahi < blo || bhi < alo {
+			return 0, 0
+		}
+	}
+
+	return 0, 1
+}
+
 var Eq = &EqExpr{}
 
 // !=
@@ -473,18 +587,23 @@ type NeqExpr struct {
 }
 
 func (e *NeqExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
-	b.pop(VmTypeAny)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "..", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[0].T != vs[1].T {
+			return nil, errors.Errorf("cannot compare %s to %s", vs[0].T, vs[1].T)
+		}
+		return []VmValue{vmBool(!vs[0].equal(vs[1]))}, nil
+	})
 }
 
 func (e *NeqExpr) String() string {
 	return "!="
 }
 
+func (e *NeqExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	lo, hi := Eq.Bounds(vs)
+	return 1 - hi, 1 - lo
+}
+
 var Neq = &NeqExpr{}
 
 // !
@@ -496,11 +615,17 @@ func (e *NotExpr) String() string {
 }
 
 func (e *NotExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "U", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{vmBool(vs[0].u() == 0)}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *NotExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	if t, ok := vs[0].truth(); ok {
+		return vmBool(!t).u(), vmBool(!t).u()
+	}
+
+	return 0, 1
 }
 
 var Not = &NotExpr{}
@@ -514,11 +639,13 @@ func (e *LenExpr) String() string {
 }
 
 func (e *LenExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "B", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{Uint64Value(uint64(len(vs[0].b())))}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *LenExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	return 0, MaxStringSize
 }
 
 var Len = &LenExpr{}
@@ -532,11 +659,11 @@ func (e *ItobExpr) String() string {
 }
 
 func (e *ItobExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.eval(e, "U", "B", func(vs []VmValue) ([]VmValue, error) {
+		bs := make([]byte, 8)
+		binary.BigEndian.PutUint64(bs, vs[0].u())
+		return []VmValue{BytesValue(bs)}, nil
+	})
 }
 
 var Itob = &ItobExpr{}
@@ -550,11 +677,18 @@ func (e *BtoiExpr) String() string {
 }
 
 func (e *BtoiExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "B", "U", func(vs []VmValue) ([]VmValue, error) {
+		bs := vs[0].b()
+		if len(bs) > 8 {
+			return nil, errors.Errorf("arg too long (%d)", len(bs))
+		}
 
-	b.Line++
-	return nil
+		var r uint64
+		for _, c := range bs {
+			r = r<<8 | uint64(c)
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
 }
 
 var Btoi = &BtoiExpr{}
@@ -568,12 +702,27 @@ func (e *ModExpr) String() string {
 }
 
 func (e *ModExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[1].u() == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		return []VmValue{Uint64Value(vs[0].u() % vs[1].u())}, nil
+	})
+}
 
-	b.Line++
-	return nil
+func (e *ModExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	_, ahi := vs[0].bounds()
+	blo, bhi := vs[1].bounds()
+
+	if blo == 0 {
+		return 0, ahi
+	}
+
+	if bhi-1 < ahi {
+		return 0, bhi - 1
+	}
+
+	return 0, ahi
 }
 
 var Modulo = &ModExpr{}
@@ -583,12 +732,9 @@ var Modulo = &ModExpr{}
 type BitOrExpr struct{}
 
 func (e *BitOrExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{Uint64Value(vs[0].u() | vs[1].u())}, nil
+	})
 }
 
 func (e *BitOrExpr) String() string {
@@ -606,12 +752,9 @@ func (e *BitAndExpr) String() string {
 }
 
 func (e *BitAndExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{Uint64Value(vs[0].u() & vs[1].u())}, nil
+	})
 }
 
 var BitAnd = &BitAndExpr{}
@@ -621,12 +764,9 @@ var BitAnd = &BitAndExpr{}
 type BitXorExpr struct{}
 
 func (e *BitXorExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{Uint64Value(vs[0].u() ^ vs[1].u())}, nil
+	})
 }
 
 func (e *BitXorExpr) String() string {
@@ -644,11 +784,9 @@ func (e *BitNotExpr) String() string {
 }
 
 func (e *BitNotExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "U", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{Uint64Value(^vs[0].u())}, nil
+	})
 }
 
 var BitNot = &BitNotExpr{}
@@ -658,13 +796,10 @@ var BitNot = &BitNotExpr{}
 type MulwExpr struct{}
 
 func (e *MulwExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "UU", func(vs []VmValue) ([]VmValue, error) {
+		hi, lo := bits.Mul64(vs[0].u(), vs[1].u())
+		return []VmValue{Uint64Value(hi), Uint64Value(lo)}, nil
+	})
 }
 
 func (e *MulwExpr) String() string {
@@ -682,13 +817,10 @@ func (e *AddwExpr) String() string {
 }
 
 func (e *AddwExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "UU", func(vs []VmValue) ([]VmValue, error) {
+		r, c := bits.Add64(vs[0].u(), vs[1].u(), 0)
+		return []VmValue{Uint64Value(c), Uint64Value(r)}, nil
+	})
 }
 
 var Addw = &AddwExpr{}
@@ -708,17 +840,19 @@ func (e *DivModwExpr) Cost(b *VmBranch) []int {
 var DivModw = &DivModwExpr{}
 
 func (e *DivModwExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
+	return b.eval(e, "UUUU", "UUUU", func(vs []VmValue) ([]VmValue, error) {
+		x := avmUint128(vs[0].u(), vs[1].u())
+		y := avmUint128(vs[2].u(), vs[3].u())
+		if y.Sign() == 0 {
+			return nil, errors.New("divide by zero")
+		}
 
-	b.Line++
-	return nil
+		q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+
+		qhi, qlo := avmSplit128(q)
+		rhi, rlo := avmSplit128(r)
+		return []VmValue{Uint64Value(qhi), Uint64Value(qlo), Uint64Value(rhi), Uint64Value(rlo)}, nil
+	})
 }
 
 // intcblock uint ...
@@ -743,6 +877,12 @@ func (e *IntcBlockExpr) String() string {
 	return fmt.Sprintf("intcblock %s", str)
 }
 
+func (e *IntcBlockExpr) Execute(b *VmBranch) error {
+	b.intc = e.Values
+	b.Line++
+	return nil
+}
+
 // intc i
 
 type IntcExpr struct {
@@ -754,10 +894,7 @@ func (e *IntcExpr) String() string {
 }
 
 func (e *IntcExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushIntc(e.Index)
 }
 
 // intc_0
@@ -769,10 +906,7 @@ func (e *Intc0Expr) String() string {
 }
 
 func (e *Intc0Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushIntc(0)
 }
 
 var Intc0 = &Intc0Expr{}
@@ -785,10 +919,7 @@ func (e *Intc1Expr) String() string {
 	return "intc_1"
 }
 func (e *Intc1Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushIntc(1)
 }
 
 var Intc1 = &Intc1Expr{}
@@ -801,10 +932,7 @@ func (e *Intc2Expr) String() string {
 	return "intc_2"
 }
 func (e *Intc2Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushIntc(2)
 }
 
 var Intc2 = &Intc2Expr{}
@@ -817,10 +945,7 @@ func (e *Intc3Expr) String() string {
 	return "intc_3"
 }
 func (e *Intc3Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushIntc(3)
 }
 
 var Intc3 = &Intc3Expr{}
@@ -847,6 +972,12 @@ func (e *BytecBlockExpr) String() string {
 	return fmt.Sprintf("bytecblock %s", str)
 }
 
+func (e *BytecBlockExpr) Execute(b *VmBranch) error {
+	b.bytec = e.Values
+	b.Line++
+	return nil
+}
+
 // bytec 1
 
 type BytecExpr struct {
@@ -854,10 +985,7 @@ type BytecExpr struct {
 }
 
 func (e *BytecExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.pushBytec(e.Index)
 }
 
 func (e *BytecExpr) String() string {
@@ -873,10 +1001,7 @@ func (e *Bytec0Expr) String() string {
 }
 
 func (e *Bytec0Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.pushBytec(0)
 }
 
 var Bytec0 = &Bytec0Expr{}
@@ -890,10 +1015,7 @@ func (e *Bytec1Expr) String() string {
 }
 
 func (e *Bytec1Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.pushBytec(1)
 }
 
 var Bytec1 = &Bytec1Expr{}
@@ -906,10 +1028,7 @@ func (e *Bytec2Expr) String() string {
 	return "bytec_2"
 }
 func (e *Bytec2Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.pushBytec(2)
 }
 
 var Bytec2 = &Bytec2Expr{}
@@ -922,10 +1041,7 @@ func (e *Bytec3Expr) String() string {
 	return "bytec_3"
 }
 func (e *Bytec3Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
-
-	b.Line++
-	return nil
+	return b.pushBytec(3)
 }
 
 var Bytec3 = &Bytec3Expr{}
@@ -941,7 +1057,10 @@ func (e *ArgExpr) String() string {
 }
 
 func (e *ArgExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
+	err := b.pushArg(Uint64Value(uint64(e.Index)))
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -955,7 +1074,10 @@ func (e *Arg0Expr) String() string {
 	return "arg_0"
 }
 func (e *Arg0Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
+	err := b.pushArg(Uint64Value(0))
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -971,7 +1093,10 @@ func (e *Arg1Expr) String() string {
 	return "arg_1"
 }
 func (e *Arg1Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
+	err := b.pushArg(Uint64Value(1))
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -987,13 +1112,19 @@ func (e *Arg2Expr) String() string {
 	return "arg_2"
 }
 func (e *Arg2Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
+	err := b.pushArg(Uint64Value(2))
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
 }
 func (e *Arg3Expr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes})
+	err := b.pushArg(Uint64Value(3))
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -1022,13 +1153,11 @@ func (e *TxnExpr) String() string {
 }
 
 func (e *TxnExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushTxn(Uint64Value(uint64(b.vm.GroupIndex)), e.Field, nil)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -1062,12 +1191,7 @@ func (e *GlobalExpr) String() string {
 }
 
 func (e *GlobalExpr) Execute(b *VmBranch) error {
-	spec, ok := globalFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
-	}
-
-	b.push(VmValue{T: spec.Type().Vm()})
+	b.pushGlobal(e.Field)
 
 	b.Line++
 	return nil
@@ -1084,8 +1208,12 @@ func (e *LoadExpr) String() string {
 }
 
 func (e *LoadExpr) Execute(b *VmBranch) error {
-	v := b.vm.Scratch.Items[e.Index]
-	b.push(VmValue{T: VmTypeAny, src: v})
+	v, err := b.load(uint64(e.Index))
+	if err != nil {
+		return b.fail(err)
+	}
+
+	b.push(v)
 
 	b.Line++
 	return nil
@@ -1100,7 +1228,10 @@ type StoreExpr struct {
 func (e *StoreExpr) Execute(b *VmBranch) error {
 	v := b.pop(VmTypeAny)
 
-	b.store(VmValue{T: VmTypeUint64, src: vmUint64Const{uint64(e.Index)}}, v)
+	err := b.store(uint64(e.Index), v)
+	if err != nil {
+		return b.fail(err)
+	}
 
 	b.Line++
 	return nil
@@ -1122,12 +1253,12 @@ func (e *TxnaExpr) String() string {
 }
 
 func (e *TxnaExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
-	}
+	ai := Uint64Value(uint64(e.Index))
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	err := b.pushTxn(Uint64Value(uint64(b.vm.GroupIndex)), e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -1143,13 +1274,11 @@ func (e *GtxnExpr) String() string {
 }
 
 func (e *GtxnExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushTxn(Uint64Value(uint64(e.Group)), e.Field, nil)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -1165,13 +1294,12 @@ func (e *GtxnsExpr) String() string {
 }
 
 func (e *GtxnsExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
-	}
+	gi := b.pop(VmTypeUint64)
 
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: spec.Type().Vm()})
+	err := b.pushTxn(gi, e.Field, nil)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -1183,7 +1311,11 @@ type ProtoExpr struct {
 }
 
 func (e *ProtoExpr) Execute(b *VmBranch) error {
-	b.prepare(e.Args, e.Results)
+	err := b.prepare(e.Args, e.Results)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
+
 	b.Line++
 	return nil
 }
@@ -1264,7 +1396,7 @@ func (e *PushIntExpr) String() string {
 }
 
 func (e *PushIntExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
+	b.push(Uint64Value(e.Value))
 	b.Line++
 	return nil
 }
@@ -1335,11 +1467,19 @@ func (e *BoxGetExpr) String() string {
 }
 
 func (e *BoxGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "BU", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeBytes), b.input(VmTypeUint64)}, nil
+		}
+
+		v, ok := b.state.Box(b.vm.App, vs[0].b())
+		return []VmValue{BytesValue(v), vmBool(ok)}, nil
+	})
 }
 
 var BoxGet = &BoxGetExpr{}
@@ -1352,10 +1492,23 @@ func (e *BoxPutExpr) String() string {
 }
 
 func (e *BoxPutExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if b.state == nil {
+			return nil, nil
+		}
+
+		if v, ok := b.state.Box(b.vm.App, vs[0].b()); ok && len(v) != len(vs[1].b()) {
+			return nil, errors.Errorf("box size mismatch (%d != %d)", len(vs[1].b()), len(v))
+		}
+
+		b.state.setBox(b.vm.App, vs[0].b(), vs[1].b())
+		return nil, nil
+	})
 }
 
 var BoxPut = &BoxPutExpr{}
@@ -1364,11 +1517,31 @@ type BoxCreateExpr struct {
 }
 
 func (e *BoxCreateExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BU", "U", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if vs[1].u() > maxBoxSize {
+			return nil, errors.Errorf("box size too large (%d > %d)", vs[1].u(), maxBoxSize)
+		}
+
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeUint64)}, nil
+		}
+
+		if v, ok := b.state.Box(b.vm.App, vs[0].b()); ok {
+			if uint64(len(v)) != vs[1].u() {
+				return nil, errors.Errorf("box size mismatch (%d != %d)", vs[1].u(), len(v))
+			}
+
+			return []VmValue{Uint64Value(0)}, nil
+		}
+
+		b.state.setBox(b.vm.App, vs[0].b(), make([]byte, vs[1].u()))
+		return []VmValue{Uint64Value(1)}, nil
+	})
 }
 
 type BoxLenExpr struct {
@@ -1379,11 +1552,19 @@ func (e *BoxLenExpr) String() string {
 }
 
 func (e *BoxLenExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "UU", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeUint64), b.input(VmTypeUint64)}, nil
+		}
+
+		v, ok := b.state.Box(b.vm.App, vs[0].b())
+		return []VmValue{Uint64Value(uint64(len(v))), vmBool(ok)}, nil
+	})
 }
 
 var BoxDel = &BoxDelExpr{}
@@ -1396,10 +1577,23 @@ func (e *BoxDelExpr) String() string {
 }
 
 func (e *BoxDelExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "U", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeUint64)}, nil
+		}
+
+		_, ok := b.state.Box(b.vm.App, vs[0].b())
+		if ok {
+			b.state.delBox(b.vm.App, vs[0].b())
+		}
+
+		return []VmValue{vmBool(ok)}, nil
+	})
 }
 
 var BoxLen = &BoxLenExpr{}
@@ -1420,11 +1614,29 @@ func (e *BoxReplaceExpr) String() string {
 }
 
 func (e *BoxReplaceExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.Line++
-	return nil
+	return b.eval(e, "BUB", "", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if b.state == nil {
+			return nil, nil
+		}
+
+		v, ok := b.state.Box(b.vm.App, vs[0].b())
+		if !ok {
+			return nil, errors.New("no such box")
+		}
+
+		r, err := avmReplace(v, vs[1].u(), vs[2].b())
+		if err != nil {
+			return nil, err
+		}
+
+		b.state.setBox(b.vm.App, vs[0].b(), r)
+		return nil, nil
+	})
 }
 
 type BoxExtractExpr struct {
@@ -1437,12 +1649,28 @@ func (e *BoxExtractExpr) String() string {
 }
 
 func (e *BoxExtractExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BUU", "B", func(vs []VmValue) ([]VmValue, error) {
+		err := avmBoxName(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeBytes)}, nil
+		}
+
+		v, ok := b.state.Box(b.vm.App, vs[0].b())
+		if !ok {
+			return nil, errors.New("no such box")
+		}
+
+		r, err := avmExtract(v, vs[1].u(), vs[2].u())
+		if err != nil {
+			return nil, err
+		}
+
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 type PragmaExpr struct {
@@ -1462,8 +1690,21 @@ type BnzExpr struct {
 func (e *BnzExpr) IsBranch() {}
 
 func (e *BnzExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.fork(e.Label.Name)
+	v := b.pop(VmTypeUint64)
+
+	if c, ok := v.truth(); ok {
+		if c {
+			b.jump(e.Label.Name)
+		} else {
+			b.Line++
+		}
+		return nil
+	}
+
+	if nb := b.fork(e.Label.Name); nb != nil {
+		nb.assume(v, "!=", Uint64Value(0))
+	}
+	b.assume(v, "==", Uint64Value(0))
 	b.Line++
 	return nil
 }
@@ -1483,8 +1724,21 @@ type BzExpr struct {
 func (e *BzExpr) IsBranch() {}
 
 func (e *BzExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.fork(e.Label.Name)
+	v := b.pop(VmTypeUint64)
+
+	if c, ok := v.truth(); ok {
+		if !c {
+			b.jump(e.Label.Name)
+		} else {
+			b.Line++
+		}
+		return nil
+	}
+
+	if nb := b.fork(e.Label.Name); nb != nil {
+		nb.assume(v, "==", Uint64Value(0))
+	}
+	b.assume(v, "!=", Uint64Value(0))
 	b.Line++
 	return nil
 }
@@ -1534,11 +1788,27 @@ func (e *AppLocalGetExpr) String() string {
 }
 
 func (e *AppLocalGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeAny)
-	b.push(VmValue{T: VmTypeAny})
-	b.Line++
-	return nil
+	return b.eval(e, ".B", ".", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeAny)}, nil
+		}
+
+		addr, err := b.account(vs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if !b.state.knowsAppLocal(addr, b.vm.App, vs[1].b()) {
+			return []VmValue{b.input(VmTypeAny)}, nil
+		}
+
+		v, ok := b.state.AppLocal(addr, b.vm.App, vs[1].b())
+		if !ok {
+			v = Uint64Value(0)
+		}
+
+		return []VmValue{v}, nil
+	})
 }
 
 var AppLocalGet = &AppLocalGetExpr{}
@@ -1550,9 +1820,33 @@ func (e *AppLocalPutExpr) String() string {
 }
 
 func (e *AppLocalPutExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeAny)
+	v := b.pop(VmTypeAny)
+	key := b.pop(VmTypeBytes)
+	acct := b.pop(VmTypeAny)
+
+	if k, ok := key.Bytes(); ok {
+		err := avmKv(k, v)
+		if err != nil {
+			return b.fail(err)
+		}
+
+		if s, ok := b.ledger(acct); ok {
+			addr, err := b.account(acct)
+			if err != nil {
+				return b.fail(err)
+			}
+
+			err = s.setAppLocal(addr, b.vm.App, k, v)
+			if err != nil {
+				return b.fail(err)
+			}
+		} else if b.state != nil {
+			b.state.setUnknownAppLocal(b.vm.App)
+		}
+	} else if b.state != nil {
+		b.state.setUnknownAppLocal(b.vm.App)
+	}
+
 	b.Line++
 	return nil
 }
@@ -1566,8 +1860,22 @@ func (e *AppGlobalPutExpr) String() string {
 }
 
 func (e *AppGlobalPutExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
-	b.pop(VmTypeBytes)
+	v := b.pop(VmTypeAny)
+	key := b.pop(VmTypeBytes)
+
+	if k, ok := key.Bytes(); ok {
+		err := avmKv(k, v)
+		if err != nil {
+			return b.fail(err)
+		}
+
+		if b.state != nil {
+			b.state.setAppGlobal(b.vm.App, k, v)
+		}
+	} else if b.state != nil {
+		b.state.setUnknownAppGlobal(b.vm.App)
+	}
+
 	b.Line++
 	return nil
 }
@@ -1581,10 +1889,22 @@ func (e *AppGlobalGetExpr) String() string {
 }
 
 func (e *AppGlobalGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeAny})
-	b.Line++
-	return nil
+	return b.eval(e, "B", ".", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeAny)}, nil
+		}
+
+		if !b.state.knowsAppGlobal(b.vm.App, vs[0].b()) {
+			return []VmValue{b.input(VmTypeAny)}, nil
+		}
+
+		v, ok := b.state.AppGlobal(b.vm.App, vs[0].b())
+		if !ok {
+			v = Uint64Value(0)
+		}
+
+		return []VmValue{v}, nil
+	})
 }
 
 var AppGlobalGet = &AppGlobalGetExpr{}
@@ -1596,12 +1916,22 @@ func (e *AppGlobalGetExExpr) String() string {
 }
 
 func (e *AppGlobalGetExExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeAny})
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "UB", ".U", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeAny), b.input(VmTypeUint64)}, nil
+		}
+
+		if !b.state.knowsAppGlobal(b.app(vs[0]), vs[1].b()) {
+			return []VmValue{b.input(VmTypeAny), b.input(VmTypeUint64)}, nil
+		}
+
+		v, ok := b.state.AppGlobal(b.app(vs[0]), vs[1].b())
+		if !ok {
+			v = Uint64Value(0)
+		}
+
+		return []VmValue{v, vmBool(ok)}, nil
+	})
 }
 
 var AppGlobalGetEx = &AppGlobalGetExExpr{}
@@ -1613,13 +1943,27 @@ func (e *AppLocalGetExExpr) String() string {
 }
 
 func (e *AppLocalGetExExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeAny)
-	b.push(VmValue{T: VmTypeAny})
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, ".UB", ".U", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeAny), b.input(VmTypeUint64)}, nil
+		}
+
+		addr, err := b.account(vs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if !b.state.knowsAppLocal(addr, b.app(vs[1]), vs[2].b()) {
+			return []VmValue{b.input(VmTypeAny), b.input(VmTypeUint64)}, nil
+		}
+
+		v, ok := b.state.AppLocal(addr, b.app(vs[1]), vs[2].b())
+		if !ok {
+			v = Uint64Value(0)
+		}
+
+		return []VmValue{v, vmBool(ok)}, nil
+	})
 }
 
 var AppLocalGetEx = &AppLocalGetExExpr{}
@@ -1631,8 +1975,23 @@ func (e *AppLocalDelExpr) String() string {
 }
 
 func (e *AppLocalDelExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeAny)
+	key := b.pop(VmTypeBytes)
+	acct := b.pop(VmTypeAny)
+
+	if s, ok := b.ledger(key, acct); ok {
+		addr, err := b.account(acct)
+		if err != nil {
+			return b.fail(err)
+		}
+
+		err = s.setAppLocal(addr, b.vm.App, key.b(), VmValue{})
+		if err != nil {
+			return b.fail(err)
+		}
+	} else if b.state != nil {
+		b.state.setUnknownAppLocal(b.vm.App)
+	}
+
 	b.Line++
 	return nil
 }
@@ -1644,30 +2003,26 @@ type RetSubExpr struct{}
 var RetSub = &RetSubExpr{}
 
 func (e *RetSubExpr) Execute(b *VmBranch) error {
-	if len(b.Frames) > 0 {
-		f := b.Frames[len(b.Frames)-1]
-
-		rs := []VmValue{}
+	if len(b.Frames) == 0 {
+		return b.fail(errors.New("retsub with empty callstack"))
+	}
 
-		for i := uint8(0); i < f.NumReturns; i++ {
-			rs = append(rs, b.pop(VmTypeAny))
-		}
+	f := b.Frames[len(b.Frames)-1]
 
-		for i := uint8(0); i < f.NumArgs; i++ {
-			b.pop(VmTypeAny)
+	if f.proto {
+		n := len(b.Stack.Items)
+		if n < f.p+int(f.NumReturns) {
+			return b.fail(errors.Errorf("retsub executed with stack below frame (%d < %d + %d returns), did you pop args?", n, f.p, f.NumReturns))
 		}
 
-		for i := len(rs) - 1; i >= 0; i-- {
-			b.push(rs[i])
-		}
+		rs := append([]VmValue{}, b.Stack.Items[n-int(f.NumReturns):]...)
+		b.Stack.Items = append(b.Stack.Items[:f.p-int(f.NumArgs)], rs...)
+	}
 
-		b.Line = f.Return + 1
-		b.Name = f.Name
+	b.Line = f.Return + 1
+	b.Name = f.Name
 
-		b.Frames = b.Frames[:len(b.Frames)-1]
-	} else {
-		b.Line++
-	}
+	b.Frames = b.Frames[:len(b.Frames)-1]
 
 	return nil
 }
@@ -1682,8 +2037,7 @@ type ReturnExpr struct {
 }
 
 func (e *ReturnExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.exit()
+	b.finish(b.pop(VmTypeUint64))
 	return nil
 }
 
@@ -1700,13 +2054,21 @@ type SwitchExpr struct {
 func (e *SwitchExpr) IsBranch() {}
 
 func (e *SwitchExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	v := b.pop(VmTypeUint64)
+	lo, hi := v.bounds()
 
-	for _, t := range e.Targets {
-		b.fork(t.Name)
+	feasible := make([]bool, len(e.Targets))
+	for i := range e.Targets {
+		feasible[i] = uint64(i) >= lo && uint64(i) <= hi
 	}
 
-	b.Line++
+	b.follow(e.Targets, feasible, hi >= uint64(len(e.Targets)), func(nb *VmBranch, i int) {
+		if i == -1 {
+			nb.assume(v, ">=", Uint64Value(uint64(len(e.Targets))))
+		} else {
+			nb.assume(v, "==", Uint64Value(uint64(i)))
+		}
+	})
 
 	return nil
 }
@@ -1743,17 +2105,41 @@ type MatchExpr struct {
 func (e *MatchExpr) IsBranch() {}
 
 func (e *MatchExpr) Execute(b *VmBranch) error {
-	for range e.Targets {
-		b.pop(VmTypeAny)
+	v := b.pop(VmTypeAny)
+
+	vs := make([]VmValue, len(e.Targets))
+	for i := len(vs) - 1; i >= 0; i-- {
+		vs[i] = b.pop(VmTypeAny)
 	}
 
-	b.pop(VmTypeAny)
+	feasible := make([]bool, len(e.Targets))
+	next := true
+
+	for i, c := range vs {
+		if !next {
+			break
+		}
+
+		lo, hi := Eq.Bounds([]VmValue{c, v})
+		if c.Known() && v.Known() {
+			lo = vmBool(c.T == v.T && c.equal(v)).u()
+			hi = lo
+		}
 
-	for _, t := range e.Targets {
-		b.fork(t.Name)
+		feasible[i] = hi == 1
+		next = lo == 0
 	}
 
-	b.Line++
+	b.follow(e.Targets, feasible, next, func(nb *VmBranch, i int) {
+		for j, c := range vs {
+			if j == i {
+				nb.assume(v, "==", c)
+				break
+			}
+
+			nb.assume(v, "!=", c)
+		}
+	})
 
 	return nil
 }
@@ -1790,8 +2176,7 @@ type CallSubExpr struct {
 func (e *CallSubExpr) IsBranch() {}
 
 func (e *CallSubExpr) Execute(b *VmBranch) error {
-	b.call(e.Label.Name)
-	return nil
+	return b.call(e.Label.Name)
 }
 
 func (e *CallSubExpr) Labels() []*LabelExpr {
@@ -1815,7 +2200,22 @@ func (e *AssertExpr) String() string {
 }
 
 func (e *AssertExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	v := b.pop(VmTypeUint64)
+
+	c, ok := v.truth()
+	if ok && !c {
+		return b.fail(errors.New("assert failed"))
+	}
+
+	if !ok {
+		b.vm.Failures = append(b.vm.Failures, VmFailure{
+			Branch:      b.Id,
+			Line:        b.Line,
+			Constraints: append(append([]VmConstraint{}, b.Constraints...), VmConstraint{A: v, Op: "==", B: Uint64Value(0)}),
+		})
+		b.assume(v, "!=", Uint64Value(0))
+	}
+
 	b.Line++
 	return nil
 }
@@ -1848,7 +2248,13 @@ type BuryExpr struct {
 
 func (e *BuryExpr) Execute(b *VmBranch) error {
 	v := b.pop(VmTypeAny)
-	b.replace(e.Depth, v)
+
+	i := len(b.Stack.Items) - int(e.Depth)
+	if e.Depth == 0 || i < 0 {
+		return b.fail(errors.Errorf("bury %d with stack height %d", e.Depth, len(b.Stack.Items)+1))
+	}
+
+	b.replace(i, v)
 	b.Line++
 	return nil
 }
@@ -1897,11 +2303,13 @@ func (e *ExtractUint16Expr) String() string {
 }
 
 func (e *ExtractUint16Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmExtractUint(vs[0].b(), vs[1].u(), 2)
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
 }
 
 var Extract16Bits = &ExtractUint16Expr{}
@@ -1913,11 +2321,13 @@ func (e *ExtractUint32Expr) String() string {
 }
 
 func (e *ExtractUint32Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmExtractUint(vs[0].b(), vs[1].u(), 4)
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
 }
 
 var Extract32Bits = &ExtractUint32Expr{}
@@ -1928,12 +2338,24 @@ func (e *Extract64BitsExpr) String() string {
 	return "extract_uint64"
 }
 
+func (e *Extract64BitsExpr) Execute(b *VmBranch) error {
+	return b.eval(e, "BU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmExtractUint(vs[0].b(), vs[1].u(), 8)
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
+}
+
 func (e *ExtractUint64Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmExtractUint(vs[0].b(), vs[1].u(), 8)
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
 }
 
 var Extract64Bits = &Extract64BitsExpr{}
@@ -1943,15 +2365,17 @@ type Replace2Expr struct {
 }
 
 func (e *Replace2Expr) String() string {
-	return fmt.Sprintf("repace2 %d", e.Start)
+	return fmt.Sprintf("replace2 %d", e.Start)
 }
 
 func (e *Replace2Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmReplace(vs[0].b(), uint64(e.Start), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 type Replace3Expr struct{}
@@ -1961,12 +2385,13 @@ func (e *Replace3Expr) String() string {
 }
 
 func (e *Replace3Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BUB", "B", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmReplace(vs[0].b(), vs[1].u(), vs[2].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 var Replace3 = &Replace3Expr{}
@@ -1996,10 +2421,13 @@ func (e *Base64DecodeExpr) String() string {
 }
 
 func (e *Base64DecodeExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmBase64Decode(Base64Encoding(e.Index), vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 type JsonRefExpr struct {
@@ -2027,11 +2455,13 @@ func (e *JsonRefExpr) String() string {
 }
 
 func (e *JsonRefExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeAny})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", ".", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmJsonRef(JSONRefType(e.Index), vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{r}, nil
+	})
 }
 
 type Ed25519VerifyBareExpr struct{}
@@ -2045,12 +2475,13 @@ func (e *Ed25519VerifyBareExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *Ed25519VerifyBareExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BBB", "U", func(vs []VmValue) ([]VmValue, error) {
+		ok, err := avmEd25519Verify(vs[0].b(), vs[1].b(), vs[2].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(ok)}, nil
+	})
 }
 
 var Ed25519VerifyBare = &Ed25519VerifyBareExpr{}
@@ -2062,11 +2493,12 @@ func (e *BitLenExpr) String() string {
 }
 
 func (e *BitLenExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-
-	return nil
+	return b.eval(e, ".", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[0].T == VmTypeBytes {
+			return []VmValue{Uint64Value(uint64(new(big.Int).SetBytes(vs[0].b()).BitLen()))}, nil
+		}
+		return []VmValue{Uint64Value(uint64(bits.Len64(vs[0].u())))}, nil
+	})
 }
 
 var BitLen = &BitLenExpr{}
@@ -2082,13 +2514,20 @@ func (e *ExpwExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *ExpwExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
+	return b.eval(e, "UU", "UU", func(vs []VmValue) ([]VmValue, error) {
+		if vs[0].u() == 0 && vs[1].u() == 0 {
+			return nil, errors.New("0^0 is undefined")
+		}
 
-	return nil
+		r := new(big.Int).SetUint64(vs[0].u())
+		r.Exp(r, new(big.Int).SetUint64(vs[1].u()), nil)
+		if r.BitLen() > 128 {
+			return nil, errors.Errorf("%d^%d overflow", vs[0].u(), vs[1].u())
+		}
+
+		hi, lo := avmSplit128(r)
+		return []VmValue{Uint64Value(hi), Uint64Value(lo)}, nil
+	})
 }
 
 var Expw = &ExpwExpr{}
@@ -2099,7 +2538,19 @@ type ItxnaExpr struct {
 }
 
 func (e *ItxnaExpr) String() string {
-	return fmt.Sprintf("itxna %d %d", e.Field, e.Index)
+	return fmt.Sprintf("itxna %s %d", e.Field, e.Index)
+}
+
+func (e *ItxnaExpr) Execute(b *VmBranch) error {
+	ai := Uint64Value(uint64(e.Index))
+
+	err := b.pushInner(-1, e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
+
+	b.Line++
+	return nil
 }
 
 type GtxnasExpr struct {
@@ -2108,21 +2559,19 @@ type GtxnasExpr struct {
 }
 
 func (e *GtxnasExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	ai := b.pop(VmTypeUint64)
 
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushTxn(Uint64Value(uint64(e.Index)), e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
 
 func (e *GtxnasExpr) String() string {
-	return fmt.Sprintf("gtxnas %d %d", e.Field, e.Index)
+	return fmt.Sprintf("gtxnas %d %s", e.Index, e.Field)
 }
 
 type ArgsExpr struct{}
@@ -2132,8 +2581,13 @@ func (e *ArgsExpr) String() string {
 }
 
 func (e *ArgsExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeBytes})
+	i := b.pop(VmTypeUint64)
+
+	err := b.pushArg(i)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
+
 	b.Line++
 	return nil
 }
@@ -2148,10 +2602,7 @@ func (e *GloadssExpr) String() string {
 
 func (e *GloadssExpr) Execute(b *VmBranch) error {
 	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeAny})
-	b.Line++
-	return nil
+	return b.pushGroupScratch("gloadss", b.pop(VmTypeUint64))
 }
 
 var Gloadss = &GloadssExpr{}
@@ -2161,15 +2612,13 @@ type ItxnasExpr struct {
 }
 
 func (e *ItxnasExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	ai := b.pop(VmTypeUint64)
 
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushInner(-1, e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -2184,15 +2633,13 @@ type GitxnasExpr struct {
 }
 
 func (e *GitxnasExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	ai := b.pop(VmTypeUint64)
 
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushInner(int(e.Index), e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -2206,8 +2653,8 @@ type PushIntsExpr struct {
 }
 
 func (e *PushIntsExpr) Execute(b *VmBranch) error {
-	for range e.Ints {
-		b.push(VmValue{T: VmTypeBytes})
+	for _, v := range e.Ints {
+		b.push(Uint64Value(v))
 	}
 	b.Line++
 	return nil
@@ -2220,7 +2667,7 @@ func (e *PushIntsExpr) String() string {
 		ss = append(ss, strconv.FormatUint(i, 10))
 	}
 
-	return fmt.Sprintf("pushbytess %s", strings.Join(ss, " "))
+	return fmt.Sprintf("pushints %s", strings.Join(ss, " "))
 
 }
 
@@ -2233,7 +2680,17 @@ func (e *MethodExpr) String() string {
 }
 
 func (e *MethodExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeBytes, src: vmSignatureValue{v: e.Signature}})
+	if b.vm.Concrete {
+		sel, err := methodSelector(e.Signature)
+		if err != nil {
+			return b.fail(err)
+		}
+
+		b.push(BytesValue(sel))
+	} else {
+		b.push(VmValue{T: VmTypeBytes, src: vmSignatureValue{v: e.Signature}})
+	}
+
 	b.Line++
 	return nil
 }
@@ -2246,6 +2703,17 @@ func (e *AddrExpr) String() string {
 	return fmt.Sprintf("addr %s", e.Address)
 }
 
+func (e *AddrExpr) Execute(b *VmBranch) error {
+	addr, err := types.DecodeAddress(e.Address)
+	if err != nil {
+		return b.fail(errors.Wrapf(err, "invalid address: %s", e.Address))
+	}
+
+	b.push(addressBytes(addr))
+	b.Line++
+	return nil
+}
+
 type PushBytessExpr struct {
 	Bytess [][]byte
 }
@@ -2292,6 +2760,11 @@ func (e *EcAddExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *EcAddExpr) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.pop(VmTypeBytes)
 	b.push(VmValue{T: VmTypeBytes})
@@ -2323,6 +2796,11 @@ func (e *EcScalarMul) Cost(b *VmBranch) []int {
 }
 
 func (e *EcScalarMul) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.pop(VmTypeBytes)
 	b.push(VmValue{T: VmTypeBytes})
@@ -2354,6 +2832,11 @@ func (e *EcPairingCheckExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *EcPairingCheckExpr) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.pop(VmTypeBytes)
 	b.push(VmValue{T: VmTypeUint64})
@@ -2370,6 +2853,11 @@ func (e *EcMultiExpExpr) String() string {
 }
 
 func (e *EcMultiExpExpr) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.pop(VmTypeBytes)
 	b.push(VmValue{T: VmTypeBytes})
@@ -2401,6 +2889,11 @@ func (e *EcSubgroupCheckExpr) String() string {
 }
 
 func (e *EcSubgroupCheckExpr) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.push(VmValue{T: VmTypeUint64})
 	b.Line++
@@ -2431,6 +2924,11 @@ func (e *EcMapToExpr) String() string {
 }
 
 func (e *EcMapToExpr) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.push(VmValue{T: VmTypeBytes})
 	b.Line++
@@ -2457,10 +2955,14 @@ type FrameBuryExpr struct {
 }
 
 func (e *FrameBuryExpr) Execute(b *VmBranch) error {
-	f := b.Frames[len(b.Frames)-1]
-
 	v := b.pop(VmTypeAny)
-	b.replace(uint8(int8(f.p)+e.Index), v)
+
+	i, err := b.frame("frame_bury", e.Index)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
+
+	b.replace(i, v)
 
 	b.Line++
 	return nil
@@ -2475,10 +2977,12 @@ type FrameDigExpr struct {
 }
 
 func (e *FrameDigExpr) Execute(b *VmBranch) error {
-	f := b.Frames[len(b.Frames)-1]
+	i, err := b.frame("frame_dig", e.Index)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
-	v := b.Stack.Items[uint8(int8(f.p)+e.Index)]
-	b.push(v)
+	b.push(b.Stack.Items[i])
 
 	b.Line++
 	return nil
@@ -2495,12 +2999,20 @@ func (e *SetByteExpr) String() string {
 }
 
 func (e *SetByteExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BUU", "B", func(vs []VmValue) ([]VmValue, error) {
+		bs := vs[0].b()
+		if vs[1].u() >= uint64(len(bs)) {
+			return nil, errors.Errorf("index beyond length (%d >= %d)", vs[1].u(), len(bs))
+		}
+
+		if vs[2].u() > 255 {
+			return nil, errors.Errorf("value too large (%d > 255)", vs[2].u())
+		}
+
+		r := append([]byte{}, bs...)
+		r[vs[1].u()] = byte(vs[2].u())
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 var SetByte = &SetByteExpr{}
@@ -2582,16 +3094,21 @@ func (e *AssetParamsGetExpr) String() string {
 }
 
 func (e *AssetParamsGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	asset := b.pop(VmTypeUint64)
 
 	spec, ok := assetParamsFieldSpecByField(e.Field)
 	if !ok {
 		panic("unknown field")
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	if s, ok := b.ledger(asset); ok {
+		v, ok := s.AssetParams(b.asset(asset), e.Field)
+		b.pushEx(v, ok, spec.Type().Vm())
+	} else {
+		b.push(b.input(spec.Type().Vm()))
+		b.push(b.input(VmTypeUint64))
+	}
 
-	b.push(VmValue{T: VmTypeUint64})
 	b.Line++
 	return nil
 }
@@ -2603,11 +3120,9 @@ func (e *ConcatExpr) String() string {
 }
 
 func (e *ConcatExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(append(append([]byte{}, vs[0].b()...), vs[1].b()...))}, nil
+	})
 }
 
 var Concat = &ConcatExpr{}
@@ -2618,6 +3133,16 @@ func (e *ItxnBeginExpr) String() string {
 	return "itxn_begin"
 }
 
+func (e *ItxnBeginExpr) Execute(b *VmBranch) error {
+	err := b.beginInner()
+	if err != nil {
+		return b.fail(err)
+	}
+
+	b.Line++
+	return nil
+}
+
 var ItxnBegin = &ItxnBeginExpr{}
 
 type ItxnSubmitExpr struct{}
@@ -2626,6 +3151,16 @@ func (e *ItxnSubmitExpr) String() string {
 	return "itxn_submit"
 }
 
+func (e *ItxnSubmitExpr) Execute(b *VmBranch) error {
+	err := b.submitInner()
+	if err != nil {
+		return b.fail(err)
+	}
+
+	b.Line++
+	return nil
+}
+
 var ItxnSubmit = &ItxnSubmitExpr{}
 
 type ItxnExpr struct {
@@ -2633,13 +3168,11 @@ type ItxnExpr struct {
 }
 
 func (e *ItxnExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushInner(-1, e.Field, nil)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -2657,7 +3190,13 @@ func (e *ItxnFieldExpr) String() string {
 }
 
 func (e *ItxnFieldExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
+	v := b.pop(VmTypeAny)
+
+	err := b.setInner(e.Field, v)
+	if err != nil {
+		return b.fail(err)
+	}
+
 	b.Line++
 	return nil
 }
@@ -2706,12 +3245,12 @@ func (e *GtxnaExpr) String() string {
 }
 
 func (e *GtxnaExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
-	}
+	ai := Uint64Value(uint64(e.Index))
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	err := b.pushTxn(Uint64Value(uint64(e.Group)), e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -2727,13 +3266,14 @@ func (e *GtxnsaExpr) String() string {
 }
 
 func (e *GtxnsaExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
-	}
+	gi := b.pop(VmTypeUint64)
 
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: spec.Type().Vm()})
+	ai := Uint64Value(uint64(e.Index))
+
+	err := b.pushTxn(gi, e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -2749,10 +3289,7 @@ func (e *GloadExpr) String() string {
 }
 
 func (e *GloadExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeAny})
-
-	b.Line++
-	return nil
+	return b.pushGroupScratch("gload", Uint64Value(uint64(e.Group)))
 }
 
 type GloadsExpr struct {
@@ -2764,11 +3301,7 @@ func (e *GloadsExpr) String() string {
 }
 
 func (e *GloadsExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeAny})
-
-	b.Line++
-	return nil
+	return b.pushGroupScratch("gloads", b.pop(VmTypeUint64))
 }
 
 type SqrtExpr struct{}
@@ -2782,11 +3315,9 @@ func (e *SqrtExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *SqrtExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.eval(e, "U", "U", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{Uint64Value(avmSqrt(vs[0].u()))}, nil
+	})
 }
 
 var Sqrt = &SqrtExpr{}
@@ -2798,10 +3329,18 @@ func (e *BalanceExpr) String() string {
 }
 
 func (e *BalanceExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, ".", "U", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeUint64)}, nil
+		}
+
+		addr, err := b.account(vs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return []VmValue{Uint64Value(b.state.Balance(addr))}, nil
+	})
 }
 
 var Balance = &BalanceExpr{}
@@ -2811,15 +3350,13 @@ type TxnasExpr struct {
 }
 
 func (e *TxnasExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	ai := b.pop(VmTypeUint64)
 
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushTxn(Uint64Value(uint64(b.vm.GroupIndex)), e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -2838,10 +3375,23 @@ func (e *ExtractExpr) String() string {
 }
 
 func (e *ExtractExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		bs := vs[0].b()
+
+		var r []byte
+		var err error
+
+		if e.Length == 0 {
+			r, err = avmSubstring(bs, uint64(e.Start), uint64(len(bs)))
+		} else {
+			r, err = avmExtract(bs, uint64(e.Start), uint64(e.Length))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 type ExpExpr struct{}
@@ -2851,11 +3401,13 @@ func (e *ExpExpr) String() string {
 }
 
 func (e *ExpExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmExp(vs[0].u(), vs[1].u())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{Uint64Value(r)}, nil
+	})
 }
 
 var Exp = &ExpExpr{}
@@ -2869,16 +3421,21 @@ func (e *AppParamsGetExpr) String() string {
 }
 
 func (e *AppParamsGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
+	app := b.pop(VmTypeUint64)
 
 	spec, ok := appParamsFieldSpecByField(e.Field)
 	if !ok {
 		panic("unknown field")
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	if s, ok := b.ledger(app); ok {
+		v, ok := s.AppParams(b.app(app), e.Field)
+		b.pushEx(v, ok, spec.Type().Vm())
+	} else {
+		b.push(b.input(spec.Type().Vm()))
+		b.push(b.input(VmTypeUint64))
+	}
 
-	b.push(VmValue{T: VmTypeUint64})
 	b.Line++
 	return nil
 }
@@ -2892,15 +3449,25 @@ func (e *AcctParamsGetExpr) String() string {
 }
 
 func (e *AcctParamsGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
+	acct := b.pop(VmTypeAny)
 
 	spec, ok := acctParamsFieldSpecByField(e.Field)
 	if !ok {
 		panic("unknown field")
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-	b.push(VmValue{T: VmTypeUint64})
+	if s, ok := b.ledger(acct); ok {
+		addr, err := b.account(acct)
+		if err != nil {
+			return b.fail(err)
+		}
+
+		v, ok := s.AcctParams(addr, e.Field)
+		b.pushEx(v, ok, spec.Type().Vm())
+	} else {
+		b.push(b.input(spec.Type().Vm()))
+		b.push(b.input(VmTypeUint64))
+	}
 
 	b.Line++
 	return nil
@@ -2912,8 +3479,24 @@ func (e *LogExpr) String() string {
 	return "log"
 }
 
-func (e *LogExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
+func (e *LogExpr) Execute(b *VmBranch) error {
+	v := b.pop(VmTypeBytes)
+
+	if len(b.Logs) >= maxLogCalls {
+		return b.fail(errors.Errorf("too many log calls (max: %d)", maxLogCalls))
+	}
+
+	b.Logs = append(b.Logs, v)
+
+	size := 0
+	for _, l := range b.Logs {
+		size += len(l.b())
+	}
+
+	if size > maxLogSize {
+		return b.fail(errors.Errorf("logs too large (%d > %d)", size, maxLogSize))
+	}
+
 	b.Line++
 	return nil
 }
@@ -2932,7 +3515,7 @@ func (e *BlockExpr) Execute(b *VmBranch) error {
 		panic("unknown field")
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	b.push(b.input(spec.Type().Vm()))
 
 	b.Line++
 	return nil
@@ -2955,6 +3538,11 @@ func (e *VrfVerifyExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *VrfVerifyExpr) Execute(b *VmBranch) error {
+	err := b.unsupported(e)
+	if err != nil {
+		return err
+	}
+
 	b.pop(VmTypeBytes)
 	b.pop(VmTypeBytes)
 	b.pop(VmTypeBytes)
@@ -2971,12 +3559,13 @@ func (e *Extract3Expr) String() string {
 }
 
 func (e *Extract3Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BUU", "B", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmExtract(vs[0].b(), vs[1].u(), vs[2].u())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 var Extract3 = &Extract3Expr{}
@@ -2984,7 +3573,7 @@ var Extract3 = &Extract3Expr{}
 type ExtractUint64Expr struct{}
 
 func (e *ExtractUint64Expr) String() string {
-	return "extract_unt64"
+	return "extract_uint64"
 }
 
 var ExtractUint64 = &ExtractUint64Expr{}
@@ -2998,17 +3587,27 @@ func (e *AssetHoldingGetExpr) String() string {
 }
 
 func (e *AssetHoldingGetExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeAny)
+	asset := b.pop(VmTypeUint64)
+	acct := b.pop(VmTypeAny)
 
 	spec, ok := assetHoldingFieldSpecByField(e.Field)
 	if !ok {
 		panic("unknown field")
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	if s, ok := b.ledger(asset, acct); ok {
+		addr, err := b.account(acct)
+		if err != nil {
+			return b.fail(err)
+		}
+
+		v, ok := s.AssetHolding(addr, b.asset(asset), e.Field)
+		b.pushEx(v, ok, spec.Type().Vm())
+	} else {
+		b.push(b.input(spec.Type().Vm()))
+		b.push(b.input(VmTypeUint64))
+	}
 
-	b.push(VmValue{T: VmTypeUint64})
 	b.Line++
 	return nil
 }
@@ -3019,6 +3618,16 @@ func (e *ItxnNextExpr) String() string {
 	return "itxn_next"
 }
 
+func (e *ItxnNextExpr) Execute(b *VmBranch) error {
+	err := b.nextInner()
+	if err != nil {
+		return b.fail(err)
+	}
+
+	b.Line++
+	return nil
+}
+
 var ItxnNext = &ItxnNextExpr{}
 
 type MinBalanceExpr struct{}
@@ -3028,10 +3637,18 @@ func (e *MinBalanceExpr) String() string {
 }
 
 func (e *MinBalanceExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeAny)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, ".", "U", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeUint64)}, nil
+		}
+
+		addr, err := b.account(vs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return []VmValue{Uint64Value(b.state.MinBalance(addr))}, nil
+	})
 }
 
 var MinBalanceOp = &MinBalanceExpr{}
@@ -3046,13 +3663,11 @@ func (e *GitxnExpr) String() string {
 }
 
 func (e *GitxnExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushInner(int(e.Index), e.Field, nil)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -3063,6 +3678,10 @@ func (e *BzeroExpr) String() string {
 	return "bzero"
 }
 
+func (e *BzeroExpr) Execute(b *VmBranch) error {
+	return BytesZero.Execute(b)
+}
+
 var Bzero = &BzeroExpr{}
 
 type GetByteExpr struct{}
@@ -3072,11 +3691,17 @@ func (e *GetByteExpr) String() string {
 }
 
 func (e *GetByteExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BU", "U", func(vs []VmValue) ([]VmValue, error) {
+		bs := vs[0].b()
+		if vs[1].u() >= uint64(len(bs)) {
+			return nil, errors.Errorf("index beyond length (%d >= %d)", vs[1].u(), len(bs))
+		}
+		return []VmValue{Uint64Value(uint64(bs[vs[1].u()]))}, nil
+	})
+}
+
+func (e *GetByteExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	return 0, 255
 }
 
 var GetByte = &GetByteExpr{}
@@ -3088,12 +3713,13 @@ func (e *Substring3Expr) String() string {
 }
 
 func (e *Substring3Expr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BUU", "B", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmSubstring(vs[0].b(), vs[1].u(), vs[2].u())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 var Substring3 = &Substring3Expr{}
@@ -3105,11 +3731,12 @@ func (e *ShlExpr) String() string {
 }
 
 func (e *ShlExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[1].u() > 63 {
+			return nil, errors.Errorf("shift too large (%d > 63)", vs[1].u())
+		}
+		return []VmValue{Uint64Value(vs[0].u() << vs[1].u())}, nil
+	})
 }
 
 var ShiftLeft = &ShlExpr{}
@@ -3121,11 +3748,12 @@ func (e *ShrExpr) String() string {
 }
 
 func (e *ShrExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "UU", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[1].u() > 63 {
+			return nil, errors.Errorf("shift too large (%d > 63)", vs[1].u())
+		}
+		return []VmValue{Uint64Value(vs[0].u() >> vs[1].u())}, nil
+	})
 }
 
 var ShiftRight = &ShrExpr{}
@@ -3137,11 +3765,27 @@ func (e *GetBitExpr) String() string {
 }
 
 func (e *GetBitExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, ".U", "U", func(vs []VmValue) ([]VmValue, error) {
+		i := vs[1].u()
+
+		if vs[0].T == VmTypeBytes {
+			bs := vs[0].b()
+			if i/8 >= uint64(len(bs)) {
+				return nil, errors.Errorf("index beyond %d bits (%d)", len(bs)*8, i)
+			}
+
+			return []VmValue{vmBool(bs[i/8]&(0x80>>(i%8)) != 0)}, nil
+		}
+
+		if i > 63 {
+			return nil, errors.Errorf("index beyond 64 bits (%d)", i)
+		}
+		return []VmValue{Uint64Value(vs[0].u() >> i & 1)}, nil
+	})
+}
+
+func (e *GetBitExpr) Bounds(vs []VmValue) (uint64, uint64) {
+	return 0, 1
 }
 
 var GetBit = &GetBitExpr{}
@@ -3153,12 +3797,41 @@ func (e *SetBitExpr) String() string {
 }
 
 func (e *SetBitExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeAny})
-	b.Line++
-	return nil
+	return b.eval(e, ".UU", ".", func(vs []VmValue) ([]VmValue, error) {
+		i := vs[1].u()
+		c := vs[2].u()
+
+		if c > 1 {
+			return nil, errors.Errorf("value too large (%d > 1)", c)
+		}
+
+		if vs[0].T == VmTypeBytes {
+			bs := vs[0].b()
+			if i/8 >= uint64(len(bs)) {
+				return nil, errors.Errorf("index beyond %d bits (%d)", len(bs)*8, i)
+			}
+
+			r := append([]byte{}, bs...)
+			mask := byte(0x80 >> (i % 8))
+			if c == 1 {
+				r[i/8] |= mask
+			} else {
+				r[i/8] &^= mask
+			}
+
+			return []VmValue{BytesValue(r)}, nil
+		}
+
+		if i > 63 {
+			return nil, errors.Errorf("index beyond 64 bits (%d)", i)
+		}
+
+		mask := uint64(1) << i
+		if c == 1 {
+			return []VmValue{Uint64Value(vs[0].u() | mask)}, nil
+		}
+		return []VmValue{Uint64Value(vs[0].u() &^ mask)}, nil
+	})
 }
 
 var SetBit = &SetBitExpr{}
@@ -3168,16 +3841,14 @@ type GtxnsasExpr struct {
 }
 
 func (e *GtxnsasExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
+	ai := b.pop(VmTypeUint64)
+	gi := b.pop(VmTypeUint64)
 
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
+	err := b.pushTxn(gi, e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
 	}
 
-	b.push(VmValue{T: spec.Type().Vm()})
-
 	b.Line++
 	return nil
 }
@@ -3211,6 +3882,19 @@ func (e *BminusExpr) Cost(b *VmBranch) []int {
 	return []int{10}
 }
 
+func (e *BminusExpr) Execute(b *VmBranch) error {
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmBigs(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		if x.Cmp(y) < 0 {
+			return nil, errors.New("byte math would have negative result")
+		}
+		return []VmValue{BytesValue(x.Sub(x, y).Bytes())}, nil
+	})
+}
+
 var BytesMinus = &BminusExpr{}
 
 type BmulExpr struct{}
@@ -3224,11 +3908,13 @@ func (e *BmulExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BmulExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmBigs(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(x.Mul(x, y).Bytes())}, nil
+	})
 }
 
 var BytesMul = &BmulExpr{}
@@ -3244,11 +3930,17 @@ func (e *BdivExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BdivExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmBigs(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if y.Sign() == 0 {
+			return nil, errors.New("divide by zero")
+		}
+		return []VmValue{BytesValue(x.Quo(x, y).Bytes())}, nil
+	})
 }
 
 var BytesDiv = &BdivExpr{}
@@ -3264,11 +3956,13 @@ func (e *BplusExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BplusExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmBigs(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(x.Add(x, y).Bytes())}, nil
+	})
 }
 
 var BytesPlus = &BplusExpr{}
@@ -3282,10 +3976,7 @@ func (e *GaidExpr) String() string {
 }
 
 func (e *GaidExpr) Execute(b *VmBranch) error {
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushGroupAid("gaid", Uint64Value(uint64(e.Group)))
 }
 
 type GaidsExpr struct {
@@ -3296,11 +3987,7 @@ func (e *GaidsExpr) String() string {
 }
 
 func (e *GaidsExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-
-	b.Line++
-	return nil
+	return b.pushGroupAid("gaids", b.pop(VmTypeUint64))
 }
 
 var Gaids = &GaidsExpr{}
@@ -3313,11 +4000,13 @@ func (e *LoadsExpr) String() string {
 }
 
 func (e *LoadsExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeAny})
-
-	b.Line++
-	return nil
+	return b.eval(e, "U", ".", func(vs []VmValue) ([]VmValue, error) {
+		v, err := b.load(vs[0].u())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{v}, nil
+	})
 }
 
 var Loads = &LoadsExpr{}
@@ -3329,7 +4018,12 @@ func (e *StoresExpr) Execute(b *VmBranch) error {
 	v := b.pop(VmTypeAny)
 	index := b.pop(VmTypeUint64)
 
-	b.store(index, v)
+	if i, ok := index.Uint64(); ok {
+		err := b.store(i, v)
+		if err != nil {
+			return b.fail(err)
+		}
+	}
 
 	b.Line++
 	return nil
@@ -3370,10 +4064,13 @@ func (e *SubstringExpr) String() string {
 }
 
 func (e *SubstringExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		r, err := avmSubstring(vs[0].b(), uint64(e.Start), uint64(e.End))
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 type DivwExpr struct{}
@@ -3383,12 +4080,18 @@ func (e *DivwExpr) String() string {
 }
 
 func (e *DivwExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "UUU", "U", func(vs []VmValue) ([]VmValue, error) {
+		if vs[2].u() == 0 {
+			return nil, errors.New("divide by zero")
+		}
+
+		if vs[0].u() >= vs[2].u() {
+			return nil, errors.New("overflow")
+		}
+
+		q, _ := bits.Div64(vs[0].u(), vs[1].u(), vs[2].u())
+		return []VmValue{Uint64Value(q)}, nil
+	})
 }
 
 var Divw = &DivwExpr{}
@@ -3402,11 +4105,20 @@ func (e *SelectExpr) String() string {
 var Select = &SelectExpr{}
 
 func (e *SelectExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeAny)
-	b.pop(VmTypeAny)
+	c := b.pop(VmTypeUint64)
+	v2 := b.pop(VmTypeAny)
+	v1 := b.pop(VmTypeAny)
+
+	if c, ok := c.Uint64(); ok {
+		if c != 0 {
+			b.push(v2)
+		} else {
+			b.push(v1)
+		}
+	} else {
+		b.push(VmValue{T: VmTypeAny})
+	}
 
-	b.push(VmValue{T: VmTypeAny})
 	b.Line++
 	return nil
 }
@@ -3417,6 +4129,10 @@ func (e *BgteqExpr) String() string {
 	return "b>="
 }
 
+func (e *BgteqExpr) Execute(b *VmBranch) error {
+	return BytesGe.Execute(b)
+}
+
 var Bgteq = &BgteqExpr{}
 
 type BGtExpr struct{}
@@ -3426,11 +4142,13 @@ func (e *BGtExpr) String() string {
 }
 
 func (e *BGtExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "U", func(vs []VmValue) ([]VmValue, error) {
+		c, err := avmBigCmp(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(c > 0)}, nil
+	})
 }
 
 var BytesGt = &BGtExpr{}
@@ -3442,11 +4160,13 @@ func (e *BytesLeExpr) String() string {
 }
 
 func (e *BytesLeExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "U", func(vs []VmValue) ([]VmValue, error) {
+		c, err := avmBigCmp(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(c <= 0)}, nil
+	})
 }
 
 var BytesLe = &BytesLeExpr{}
@@ -3458,11 +4178,13 @@ func (e *BytesGeExpr) String() string {
 }
 
 func (e *BytesGeExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "U", func(vs []VmValue) ([]VmValue, error) {
+		c, err := avmBigCmp(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(c >= 0)}, nil
+	})
 }
 
 var BytesGe = &BytesGeExpr{}
@@ -3474,11 +4196,13 @@ func (e *BytesEqExpr) String() string {
 }
 
 func (e *BytesEqExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "U", func(vs []VmValue) ([]VmValue, error) {
+		c, err := avmBigCmp(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(c == 0)}, nil
+	})
 }
 
 var BytesEq = &BytesEqExpr{}
@@ -3490,11 +4214,13 @@ func (e *BytesNeqExpr) String() string {
 }
 
 func (e *BytesNeqExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "U", func(vs []VmValue) ([]VmValue, error) {
+		c, err := avmBigCmp(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(c != 0)}, nil
+	})
 }
 
 var BytesNeq = &BytesNeqExpr{}
@@ -3510,11 +4236,17 @@ func (e *BytesModuloExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BytesModuloExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		x, y, err := avmBigs(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+
+		if y.Sign() == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		return []VmValue{BytesValue(x.Rem(x, y).Bytes())}, nil
+	})
 }
 
 var BytesModulo = &BytesModuloExpr{}
@@ -3529,6 +4261,14 @@ func (e *BytesBitOrExpr) Cost(b *VmBranch) []int {
 	return []int{6}
 }
 
+func (e *BytesBitOrExpr) Execute(b *VmBranch) error {
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmBitwise(vs[0].b(), vs[1].b(), func(x, y byte) byte {
+			return x | y
+		}))}, nil
+	})
+}
+
 var BytesBitOr = &BytesBitOrExpr{}
 
 type BytesBitAndExpr struct{}
@@ -3542,11 +4282,11 @@ func (e *BytesBitAndExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BytesBitAndExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmBitwise(vs[0].b(), vs[1].b(), func(x, y byte) byte {
+			return x & y
+		}))}, nil
+	})
 }
 
 var BytesBitAnd = &BytesBitAndExpr{}
@@ -3562,10 +4302,13 @@ func (e *BsqrtExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BsqrtExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		x, err := avmBig(vs[0].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{BytesValue(x.Sqrt(x).Bytes())}, nil
+	})
 }
 
 var Bsqrt = &BsqrtExpr{}
@@ -3581,12 +4324,12 @@ func (e *GitxnaExpr) String() string {
 }
 
 func (e *GitxnaExpr) Execute(b *VmBranch) error {
-	spec, ok := txnFieldSpecByField(e.Field)
-	if !ok {
-		panic("unknown field")
-	}
+	ai := Uint64Value(uint64(e.Index))
 
-	b.push(VmValue{T: spec.Type().Vm()})
+	err := b.pushInner(int(e.Group), e.Field, &ai)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
 
 	b.Line++
 	return nil
@@ -3599,7 +4342,14 @@ func (e *AppGlobalDelExpr) String() string {
 }
 
 func (e *AppGlobalDelExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
+	key := b.pop(VmTypeBytes)
+
+	if s, ok := b.ledger(key); ok {
+		s.setAppGlobal(b.vm.App, key.b(), VmValue{})
+	} else if b.state != nil {
+		b.state.setUnknownAppGlobal(b.vm.App)
+	}
+
 	b.Line++
 	return nil
 }
@@ -3613,11 +4363,13 @@ func (e *BltExpr) String() string {
 }
 
 func (e *BltExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "U", func(vs []VmValue) ([]VmValue, error) {
+		c, err := avmBigCmp(vs[0].b(), vs[1].b())
+		if err != nil {
+			return nil, err
+		}
+		return []VmValue{vmBool(c < 0)}, nil
+	})
 }
 
 var BytesLt = &BltExpr{}
@@ -3628,6 +4380,14 @@ func (e *BandExpr) String() string {
 	return "b&"
 }
 
+func (e *BandExpr) Cost(b *VmBranch) []int {
+	return BytesBitAnd.Cost(b)
+}
+
+func (e *BandExpr) Execute(b *VmBranch) error {
+	return BytesBitAnd.Execute(b)
+}
+
 var Band = &BandExpr{}
 
 type BytesBitXorExpr struct{}
@@ -3641,11 +4401,11 @@ func (e *BytesBitXorExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BytesBitXorExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "BB", "B", func(vs []VmValue) ([]VmValue, error) {
+		return []VmValue{BytesValue(avmBitwise(vs[0].b(), vs[1].b(), func(x, y byte) byte {
+			return x ^ y
+		}))}, nil
+	})
 }
 
 var BytesBitXor = &BytesBitXorExpr{}
@@ -3661,10 +4421,15 @@ func (e *BytesBitNotExpr) Cost(b *VmBranch) []int {
 }
 
 func (e *BytesBitNotExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeBytes)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "B", "B", func(vs []VmValue) ([]VmValue, error) {
+		bs := vs[0].b()
+
+		r := make([]byte, len(bs))
+		for i, c := range bs {
+			r[i] = ^c
+		}
+		return []VmValue{BytesValue(r)}, nil
+	})
 }
 
 var BytesBitNot = &BytesBitNotExpr{}
@@ -3676,10 +4441,12 @@ func (e *BytesZeroExpr) String() string {
 }
 
 func (e *BytesZeroExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeBytes})
-	b.Line++
-	return nil
+	return b.eval(e, "U", "B", func(vs []VmValue) ([]VmValue, error) {
+		if vs[0].u() > MaxStringSize {
+			return nil, errors.Errorf("size too large (%d > %d)", vs[0].u(), MaxStringSize)
+		}
+		return []VmValue{BytesValue(make([]byte, vs[0].u()))}, nil
+	})
 }
 
 var BytesZero = &BytesZeroExpr{}
@@ -3691,11 +4458,18 @@ func (e *AppOptedInExpr) String() string {
 }
 
 func (e *AppOptedInExpr) Execute(b *VmBranch) error {
-	b.pop(VmTypeUint64)
-	b.pop(VmTypeUint64)
-	b.push(VmValue{T: VmTypeUint64})
-	b.Line++
-	return nil
+	return b.eval(e, ".U", "U", func(vs []VmValue) ([]VmValue, error) {
+		if b.state == nil {
+			return []VmValue{b.input(VmTypeUint64)}, nil
+		}
+
+		addr, err := b.account(vs[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return []VmValue{vmBool(b.state.OptedIn(addr, b.app(vs[1])))}, nil
+	})
 }
 
 var AppOptedIn = &AppOptedInExpr{}
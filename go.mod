@@ -8,13 +8,13 @@ require (
 	github.com/joe-p/tealfmt v0.0.0-20221219211223-cec2ea891d52
 	github.com/pkg/errors v0.9.1
 	github.com/samber/lo v1.37.0
+	golang.org/x/crypto v0.4.0
 	golang.org/x/tools v0.4.0
 )
 
 require (
 	github.com/algorand/go-codec/codec v1.1.9 // indirect
 	github.com/google/go-querystring v1.1.0 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
 	golang.org/x/exp v0.0.0-20221217163422-3c43f8badb15 // indirect
 	golang.org/x/mod v0.7.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
@@ -0,0 +1,523 @@
+package teal
+
+import (
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxInnerGroupSize = 16
+	maxInnerTxns      = 256
+	maxTxnNoteSize    = 1024
+)
+
+var txnTypesByEnum = map[uint64]types.TxType{
+	1: types.PaymentTx,
+	2: types.KeyRegistrationTx,
+	3: types.AssetConfigTx,
+	4: types.AssetTransferTx,
+	5: types.AssetFreezeTx,
+	6: types.ApplicationCallTx,
+}
+
+// VmInnerTxn is an inner transaction built by a branch, Unknown holds the fields set to values the vm does not know
+type VmInnerTxn struct {
+	Txn     types.Transaction
+	Unknown map[TxnField]bool
+}
+
+func (t *VmInnerTxn) clone() *VmInnerTxn {
+	nt := &VmInnerTxn{
+		Txn:     t.Txn,
+		Unknown: map[TxnField]bool{},
+	}
+
+	nt.Txn.ApplicationArgs = append([][]byte{}, t.Txn.ApplicationArgs...)
+	nt.Txn.Accounts = append([]types.Address{}, t.Txn.Accounts...)
+	nt.Txn.ForeignApps = append([]types.AppIndex{}, t.Txn.ForeignApps...)
+	nt.Txn.ForeignAssets = append([]types.AssetIndex{}, t.Txn.ForeignAssets...)
+
+	for f := range t.Unknown {
+		nt.Unknown[f] = true
+	}
+
+	return nt
+}
+
+func innerAddress(f TxnField, v VmValue) (types.Address, error) {
+	var addr types.Address
+
+	bs := v.b()
+	if len(bs) != len(addr) {
+		return addr, errors.Errorf("%s must be 32 bytes", f)
+	}
+
+	copy(addr[:], bs)
+	return addr, nil
+}
+
+func innerBool(f TxnField, v VmValue) (bool, error) {
+	switch v.u() {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, errors.Errorf("%s must be 0 or 1", f)
+	}
+}
+
+func innerBytes(f TxnField, v VmValue, max int) ([]byte, error) {
+	bs := v.b()
+	if len(bs) > max {
+		return nil, errors.Errorf("%s must be at most %d bytes", f, max)
+	}
+
+	return bs, nil
+}
+
+func innerFixed(f TxnField, v VmValue, dst []byte) error {
+	bs := v.b()
+	if len(bs) != len(dst) {
+		return errors.Errorf("%s must be %d bytes", f, len(dst))
+	}
+
+	copy(dst, bs)
+	return nil
+}
+
+// set assigns a known value to the field of the transaction, array fields get the value appended
+func (t *VmInnerTxn) set(f TxnField, v VmValue) error {
+	var err error
+
+	tx := &t.Txn
+
+	switch f {
+	case Sender:
+		tx.Sender, err = innerAddress(f, v)
+	case Fee:
+		tx.Fee = types.MicroAlgos(v.u())
+	case Note:
+		tx.Note, err = innerBytes(f, v, maxTxnNoteSize)
+	case Receiver:
+		tx.Receiver, err = innerAddress(f, v)
+	case Amount:
+		tx.Amount = types.MicroAlgos(v.u())
+	case CloseRemainderTo:
+		tx.CloseRemainderTo, err = innerAddress(f, v)
+	case VotePK:
+		err = innerFixed(f, v, tx.VotePK[:])
+	case SelectionPK:
+		err = innerFixed(f, v, tx.SelectionPK[:])
+	case VoteFirst:
+		tx.VoteFirst = types.Round(v.u())
+	case VoteLast:
+		tx.VoteLast = types.Round(v.u())
+	case VoteKeyDilution:
+		tx.VoteKeyDilution = v.u()
+	case Type:
+		tt := types.TxType(v.b())
+		if _, ok := txnTypeEnums[tt]; !ok {
+			return errors.Errorf("unknown type: %s", v.b())
+		}
+		tx.Type = tt
+	case TypeEnum:
+		tt, ok := txnTypesByEnum[v.u()]
+		if !ok {
+			return errors.Errorf("unknown type enum: %d", v.u())
+		}
+		tx.Type = tt
+	case XferAsset:
+		tx.XferAsset = types.AssetIndex(v.u())
+	case AssetAmount:
+		tx.AssetAmount = v.u()
+	case AssetSender:
+		tx.AssetSender, err = innerAddress(f, v)
+	case AssetReceiver:
+		tx.AssetReceiver, err = innerAddress(f, v)
+	case AssetCloseTo:
+		tx.AssetCloseTo, err = innerAddress(f, v)
+	case ApplicationID:
+		tx.ApplicationID = types.AppIndex(v.u())
+	case OnCompletion:
+		if v.u() > uint64(types.DeleteApplicationOC) {
+			return errors.Errorf("invalid on completion: %d", v.u())
+		}
+		tx.OnCompletion = types.OnCompletion(v.u())
+	case ApplicationArgs:
+		tx.ApplicationArgs = append(tx.ApplicationArgs, v.b())
+	case Accounts:
+		var addr types.Address
+		addr, err = innerAddress(f, v)
+		tx.Accounts = append(tx.Accounts, addr)
+	case ApprovalProgram:
+		tx.ApprovalProgram = v.b()
+	case ClearStateProgram:
+		tx.ClearStateProgram = v.b()
+	case RekeyTo:
+		tx.RekeyTo, err = innerAddress(f, v)
+	case ConfigAsset:
+		tx.ConfigAsset = types.AssetIndex(v.u())
+	case ConfigAssetTotal:
+		tx.AssetParams.Total = v.u()
+	case ConfigAssetDecimals:
+		if v.u() > 19 {
+			return errors.Errorf("%s must be at most 19", f)
+		}
+		tx.AssetParams.Decimals = uint32(v.u())
+	case ConfigAssetDefaultFrozen:
+		tx.AssetParams.DefaultFrozen, err = innerBool(f, v)
+	case ConfigAssetUnitName:
+		var bs []byte
+		bs, err = innerBytes(f, v, 8)
+		tx.AssetParams.UnitName = string(bs)
+	case ConfigAssetName:
+		var bs []byte
+		bs, err = innerBytes(f, v, 32)
+		tx.AssetParams.AssetName = string(bs)
+	case ConfigAssetURL:
+		var bs []byte
+		bs, err = innerBytes(f, v, 96)
+		tx.AssetParams.URL = string(bs)
+	case ConfigAssetMetadataHash:
+		err = innerFixed(f, v, tx.AssetParams.MetadataHash[:])
+	case ConfigAssetManager:
+		tx.AssetParams.Manager, err = innerAddress(f, v)
+	case ConfigAssetReserve:
+		tx.AssetParams.Reserve, err = innerAddress(f, v)
+	case ConfigAssetFreeze:
+		tx.AssetParams.Freeze, err = innerAddress(f, v)
+	case ConfigAssetClawback:
+		tx.AssetParams.Clawback, err = innerAddress(f, v)
+	case FreezeAsset:
+		tx.FreezeAsset = types.AssetIndex(v.u())
+	case FreezeAssetAccount:
+		tx.FreezeAccount, err = innerAddress(f, v)
+	case FreezeAssetFrozen:
+		tx.AssetFrozen, err = innerBool(f, v)
+	case Assets:
+		tx.ForeignAssets = append(tx.ForeignAssets, types.AssetIndex(v.u()))
+	case Applications:
+		tx.ForeignApps = append(tx.ForeignApps, types.AppIndex(v.u()))
+	case GlobalNumUint:
+		tx.GlobalStateSchema.NumUint = v.u()
+	case GlobalNumByteSlice:
+		tx.GlobalStateSchema.NumByteSlice = v.u()
+	case LocalNumUint:
+		tx.LocalStateSchema.NumUint = v.u()
+	case LocalNumByteSlice:
+		tx.LocalStateSchema.NumByteSlice = v.u()
+	case ExtraProgramPages:
+		tx.ExtraProgramPages = uint32(v.u())
+	case Nonparticipation:
+		tx.Nonparticipation, err = innerBool(f, v)
+	case StateProofPK:
+		err = innerFixed(f, v, tx.StateProofPK[:])
+	case ApprovalProgramPages:
+		tx.ApprovalProgram = append(append([]byte{}, tx.ApprovalProgram...), v.b()...)
+	case ClearStateProgramPages:
+		tx.ClearStateProgram = append(append([]byte{}, tx.ClearStateProgram...), v.b()...)
+	default:
+		return errors.Errorf("unsupported field: %s", f)
+	}
+
+	return err
+}
+
+// setUnknown marks the field as set to a value the vm does not know, array fields get a placeholder appended
+func (t *VmInnerTxn) setUnknown(f TxnField) {
+	t.Unknown[f] = true
+
+	switch f {
+	case Type, TypeEnum:
+		t.Unknown[Type] = true
+		t.Unknown[TypeEnum] = true
+	case ApplicationArgs:
+		t.Txn.ApplicationArgs = append(t.Txn.ApplicationArgs, nil)
+	case Accounts:
+		t.Txn.Accounts = append(t.Txn.Accounts, types.Address{})
+	case Assets:
+		t.Txn.ForeignAssets = append(t.Txn.ForeignAssets, 0)
+	case Applications:
+		t.Txn.ForeignApps = append(t.Txn.ForeignApps, 0)
+	case ApprovalProgramPages:
+		t.Unknown[ApprovalProgram] = true
+	case ClearStateProgramPages:
+		t.Unknown[ClearStateProgram] = true
+	}
+}
+
+func (b *VmBranch) newInnerTxn() *VmInnerTxn {
+	fee := uint64(1000)
+	if b.vm.Globals != nil {
+		fee = b.vm.Globals.MinTxnFee
+	}
+
+	t := &VmInnerTxn{
+		Txn: types.Transaction{
+			Header: types.Header{
+				Sender: appAddress(b.vm.App),
+				Fee:    types.MicroAlgos(fee),
+			},
+		},
+		Unknown: map[TxnField]bool{},
+	}
+
+	if txn := b.vm.txn(); txn != nil {
+		t.Txn.FirstValid = txn.FirstValid
+		t.Txn.LastValid = txn.LastValid
+		t.Txn.GenesisHash = txn.GenesisHash
+	}
+
+	return t
+}
+
+func (b *VmBranch) innerCount() int {
+	n := len(b.pending)
+	for _, g := range b.Inner {
+		n += len(g)
+	}
+
+	return n
+}
+
+func (b *VmBranch) beginInner() error {
+	if b.pending != nil {
+		return errors.New("itxn_begin without itxn_submit")
+	}
+
+	if b.innerCount() >= maxInnerTxns {
+		return errors.Errorf("too many inner transactions (max: %d)", maxInnerTxns)
+	}
+
+	b.pending = []*VmInnerTxn{b.newInnerTxn()}
+	return nil
+}
+
+func (b *VmBranch) nextInner() error {
+	if b.pending == nil {
+		return errors.New("itxn_next without itxn_begin")
+	}
+
+	if len(b.pending) >= maxInnerGroupSize {
+		return errors.Errorf("too many inner transactions in a group (max: %d)", maxInnerGroupSize)
+	}
+
+	if b.innerCount() >= maxInnerTxns {
+		return errors.Errorf("too many inner transactions (max: %d)", maxInnerTxns)
+	}
+
+	b.pending = append(b.pending, b.newInnerTxn())
+	return nil
+}
+
+func (b *VmBranch) setInner(f TxnField, v VmValue) error {
+	if b.pending == nil {
+		return errors.New("itxn_field without itxn_begin")
+	}
+
+	spec, ok := txnFieldSpecByField(f)
+	if !ok {
+		panic("unknown field")
+	}
+
+	if spec.itxVersion == 0 || spec.itxVersion > b.vm.Process.Version {
+		return errors.Errorf("%s is not allowed in itxn_field", f)
+	}
+
+	t := spec.Type().Vm()
+	if v.T != VmTypeAny && v.T != t {
+		return errors.Errorf("%s must be %s", f, t)
+	}
+
+	txn := b.pending[len(b.pending)-1]
+
+	if !v.Known() {
+		txn.setUnknown(f)
+		return nil
+	}
+
+	delete(txn.Unknown, f)
+	return txn.set(f, v)
+}
+
+// transfer moves microalgos between accounts of the branch state
+func (s *vmState) transfer(from types.Address, to types.Address, amount uint64) error {
+	bal := s.Balance(from)
+	if bal < amount {
+		return errors.Errorf("overspend by %s (balance: %d, amount: %d)", from, bal, amount)
+	}
+
+	s.setBalance(from, bal-amount)
+	s.setBalance(to, s.Balance(to)+amount)
+
+	return nil
+}
+
+// transferAsset moves asset units between accounts of the branch state
+func (s *vmState) transferAsset(from types.Address, to types.Address, asset uint64, amount uint64) error {
+	v, ok := s.AssetHolding(from, asset, AssetBalance)
+	if !ok {
+		return errors.Errorf("account %s is not opted in to asset %d", from, asset)
+	}
+
+	if v.u() < amount {
+		return errors.Errorf("underflow of asset %d by %s (balance: %d, amount: %d)", asset, from, v.u(), amount)
+	}
+
+	w, ok := s.AssetHolding(to, asset, AssetBalance)
+	if !ok {
+		return errors.Errorf("account %s is not opted in to asset %d", to, asset)
+	}
+
+	s.setHolding(from, asset, v.u()-amount)
+	s.setHolding(to, asset, w.u()+amount)
+
+	return nil
+}
+
+// apply records the balance changes of the transaction, nothing is recorded if any of the involved fields is unknown
+func (s *vmState) apply(t *VmInnerTxn) error {
+	for _, f := range []TxnField{Sender, Fee, Type, Receiver, Amount, CloseRemainderTo, XferAsset, AssetAmount, AssetSender, AssetReceiver, AssetCloseTo} {
+		if t.Unknown[f] {
+			return nil
+		}
+	}
+
+	tx := &t.Txn
+
+	bal := s.Balance(tx.Sender)
+	if bal < uint64(tx.Fee) {
+		return errors.Errorf("overspend by %s (balance: %d, fee: %d)", tx.Sender, bal, tx.Fee)
+	}
+	s.setBalance(tx.Sender, bal-uint64(tx.Fee))
+
+	switch tx.Type {
+	case types.PaymentTx:
+		err := s.transfer(tx.Sender, tx.Receiver, uint64(tx.Amount))
+		if err != nil {
+			return err
+		}
+
+		if !tx.CloseRemainderTo.IsZero() {
+			err := s.transfer(tx.Sender, tx.CloseRemainderTo, s.Balance(tx.Sender))
+			if err != nil {
+				return err
+			}
+		}
+	case types.AssetTransferTx:
+		from := tx.Sender
+		if !tx.AssetSender.IsZero() {
+			from = tx.AssetSender
+		}
+
+		asset := uint64(tx.XferAsset)
+
+		err := s.transferAsset(from, tx.AssetReceiver, asset, tx.AssetAmount)
+		if err != nil {
+			return err
+		}
+
+		if !tx.AssetCloseTo.IsZero() {
+			v, _ := s.AssetHolding(from, asset, AssetBalance)
+
+			err := s.transferAsset(from, tx.AssetCloseTo, asset, v.u())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *VmBranch) submitInner() error {
+	if b.pending == nil {
+		return errors.New("itxn_submit without itxn_begin")
+	}
+
+	app := appAddress(b.vm.App)
+
+	for i, t := range b.pending {
+		if t.Txn.Type == "" && !t.Unknown[Type] {
+			return errors.Errorf("inner transaction %d has no type", i)
+		}
+
+		if t.Unknown[Sender] || t.Txn.Sender == app || b.state == nil {
+			continue
+		}
+
+		auth, ok := b.state.AcctParams(t.Txn.Sender, AcctAuthAddr)
+		if !ok || string(auth.b()) != string(app[:]) {
+			return errors.Errorf("unauthorized inner transaction %d sender: %s", i, t.Txn.Sender)
+		}
+	}
+
+	if b.state != nil {
+		for i, t := range b.pending {
+			err := b.state.apply(t)
+			if err != nil {
+				return errors.Wrapf(err, "failed to apply inner transaction %d", i)
+			}
+		}
+	}
+
+	for _, t := range b.pending {
+		if t.Txn.Type == types.ApplicationCallTx {
+			b.Budget += maxAppProgramCost
+		}
+	}
+
+	b.Inner = append(b.Inner, b.pending)
+	b.pending = nil
+
+	return nil
+}
+
+// pushInner pushes a field of a transaction of the last submitted inner group, ti < 0 is the last transaction
+func (b *VmBranch) pushInner(ti int, f TxnField, ai *VmValue) error {
+	spec, ok := txnFieldSpecByField(f)
+	if !ok {
+		panic("unknown field")
+	}
+
+	t := spec.Type().Vm()
+
+	if len(b.Inner) == 0 {
+		return b.fail(errors.New("no inner transaction has been submitted"))
+	}
+
+	g := b.Inner[len(b.Inner)-1]
+	if ti < 0 {
+		ti = len(g) - 1
+	}
+
+	if ti >= len(g) {
+		return b.fail(errors.Errorf("inner transaction index %d out of range (group size: %d)", ti, len(g)))
+	}
+
+	txn := g[ti]
+
+	if txn.Unknown[f] || (ai != nil && !ai.Known()) {
+		b.push(b.input(t))
+		return nil
+	}
+
+	var v VmValue
+	if ai != nil {
+		var err error
+		v, ok, err = txnArrayValue(&txn.Txn, f, ai.u())
+		if err != nil {
+			return b.fail(err)
+		}
+	} else {
+		v, ok = txnValue(&txn.Txn, uint64(ti), f)
+	}
+
+	if !ok {
+		v = b.input(t)
+	}
+
+	b.push(v)
+	return nil
+}
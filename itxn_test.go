@@ -0,0 +1,120 @@
+package teal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+func TestInnerPayment(t *testing.T) {
+	app := appAddress(5)
+	receiver := types.Address{2}
+
+	l, err := ReadMemoryLedger([]byte(fmt.Sprintf(`{"accounts": {%q: {"balance": 1000000}}}`, app.String())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := NewVm(Process(`#pragma version 8
+itxn_begin
+int pay
+itxn_field TypeEnum
+byte 0x02`+strings.Repeat("00", 31)+`
+itxn_field Receiver
+int 5000
+itxn_field Amount
+itxn_next
+int pay
+itxn_field TypeEnum
+byte 0x02`+strings.Repeat("00", 31)+`
+itxn_field Receiver
+int 7000
+itxn_field Amount
+itxn_submit
+gitxn 0 Amount
+itxn Amount
++`), WithConcrete(), WithLedger(l), WithApp(5))
+	vm.Run()
+
+	if vm.Error != nil {
+		t.Fatal(vm.Error)
+	}
+
+	b := vm.Branches[0]
+
+	items := b.Stack.Items
+	if len(items) != 1 || !items[0].equal(Uint64Value(12000)) {
+		t.Errorf("unexpected stack: %v", items)
+	}
+
+	if len(b.Inner) != 1 || len(b.Inner[0]) != 2 {
+		t.Fatalf("unexpected inner transactions: %v", b.Inner)
+	}
+
+	txn := b.Inner[0][1].Txn
+	if txn.Type != types.PaymentTx || txn.Sender != app || txn.Receiver != receiver || txn.Amount != 7000 {
+		t.Errorf("unexpected inner transaction: %+v", txn)
+	}
+
+	if bal := b.Ledger().Balance(app); bal != 1000000-12000-2000 {
+		t.Errorf("unexpected app balance: %d", bal)
+	}
+
+	if bal := b.Ledger().Balance(receiver); bal != 12000 {
+		t.Errorf("unexpected receiver balance: %d", bal)
+	}
+}
+
+func TestInnerErrors(t *testing.T) {
+	tests := []string{
+		"itxn_submit",
+		"itxn_begin\nitxn_begin",
+		"int 1\nitxn_field Amount",
+		"itxn_begin\nbyte 0x01\nitxn_field Amount",
+		"itxn_begin\nint 1\nitxn_field Receiver",
+		"itxn_begin\nint 9\nitxn_field TypeEnum",
+		"itxn_begin\nitxn_submit",
+		"itxn_begin\nint pay\nitxn_field TypeEnum\nint 1\nitxn_field Amount\nitxn_submit",
+		"itxn Amount",
+	}
+
+	for _, src := range tests {
+		vm := NewVm(Process("#pragma version 8\n"+src), WithConcrete(), WithLedger(NewMemoryLedger()), WithApp(5))
+		vm.Run()
+
+		if vm.Error == nil {
+			t.Errorf("expected error for: %s", src)
+		}
+	}
+}
+
+func TestSymbolicInnerErrors(t *testing.T) {
+	tests := []string{
+		"itxn Amount",
+		"itxna Accounts 0",
+		"gitxn 0 Amount",
+		"gitxna 0 Accounts 0",
+		"itxn_begin\nint pay\nitxn_field TypeEnum\nint 0\nitxn_field Fee\nitxn_submit\ngitxn 1 Amount",
+	}
+
+	for _, src := range tests {
+		vm := NewVm(Process("#pragma version 8\n" + src + "\npop\nint 1\nreturn"))
+		vm.Run()
+
+		if len(vm.Branches) != 1 {
+			t.Errorf("unexpected number of branches for: %s: %d", src, len(vm.Branches))
+			continue
+		}
+
+		b := vm.Branches[0]
+		if b.Outcome != VmErr || b.Err == nil || b.Line != ExitLine {
+			t.Errorf("expected the branch to fail for: %s: %s (%v)", src, b.Outcome, b.Err)
+		}
+
+		if vm.Steps > 20 {
+			t.Errorf("expected the branch to end for: %s: %d steps", src, vm.Steps)
+		}
+	}
+}
@@ -0,0 +1,754 @@
+package teal
+
+import (
+	"crypto/sha512"
+	"encoding/json"
+	"sort"
+
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
+)
+
+// Ledger provides the accounts, applications, assets and boxes read by the programs.
+// Changes made by a program are kept by each branch on top of the ledger - see VmBranch.Ledger.
+type Ledger interface {
+	Balance(addr types.Address) uint64
+	MinBalance(addr types.Address) uint64
+	OptedIn(addr types.Address, app uint64) bool
+
+	AppGlobal(app uint64, key []byte) (VmValue, bool)
+	AppLocal(addr types.Address, app uint64, key []byte) (VmValue, bool)
+	Box(app uint64, name []byte) ([]byte, bool)
+
+	AssetHolding(addr types.Address, asset uint64, f AssetHoldingField) (VmValue, bool)
+	AssetParams(asset uint64, f AssetParamsField) (VmValue, bool)
+	AppParams(app uint64, f AppParamsField) (VmValue, bool)
+	AcctParams(addr types.Address, f AcctParamsField) (VmValue, bool)
+}
+
+const minBalance = 100000
+
+func appAddress(app uint64) types.Address {
+	bs := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		bs[7-i] = byte(app >> (8 * i))
+	}
+
+	return sha512.Sum512_256(append([]byte("appID"), bs...))
+}
+
+func addressValue(s string) VmValue {
+	if s == "" {
+		return BytesValue(make([]byte, 32))
+	}
+
+	addr, err := types.DecodeAddress(s)
+	if err != nil {
+		return BytesValue(make([]byte, 32))
+	}
+
+	return BytesValue(addr[:])
+}
+
+// MemoryState is a key-value store encoded in JSON the same way algod encodes application state
+type MemoryState map[string]VmValue
+
+type memoryTealValue struct {
+	Type  uint64 `json:"type"`
+	Bytes []byte `json:"bytes,omitempty"`
+	Uint  uint64 `json:"uint,omitempty"`
+}
+
+type memoryTealKeyValue struct {
+	Key   []byte          `json:"key"`
+	Value memoryTealValue `json:"value"`
+}
+
+func (s MemoryState) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := []memoryTealKeyValue{}
+	for _, k := range keys {
+		v := s[k]
+
+		kv := memoryTealKeyValue{Key: []byte(k)}
+		switch v.T {
+		case VmTypeBytes:
+			kv.Value = memoryTealValue{Type: 1, Bytes: v.b()}
+		default:
+			kv.Value = memoryTealValue{Type: 2, Uint: v.u()}
+		}
+
+		kvs = append(kvs, kv)
+	}
+
+	return json.Marshal(kvs)
+}
+
+func (s *MemoryState) UnmarshalJSON(bs []byte) error {
+	var kvs []memoryTealKeyValue
+
+	err := json.Unmarshal(bs, &kvs)
+	if err != nil {
+		return err
+	}
+
+	*s = MemoryState{}
+
+	for _, kv := range kvs {
+		switch kv.Value.Type {
+		case 1:
+			(*s)[string(kv.Key)] = BytesValue(kv.Value.Bytes)
+		case 2:
+			(*s)[string(kv.Key)] = Uint64Value(kv.Value.Uint)
+		default:
+			return errors.Errorf("invalid value type of key %s: %d", kv.Key, kv.Value.Type)
+		}
+	}
+
+	return nil
+}
+
+// MemoryBoxes is a box store encoded in JSON as a list of base64 names and values
+type MemoryBoxes map[string][]byte
+
+type memoryBox struct {
+	Name  []byte `json:"name"`
+	Value []byte `json:"value"`
+}
+
+func (b MemoryBoxes) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0, len(b))
+	for n := range b {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	bs := []memoryBox{}
+	for _, n := range names {
+		bs = append(bs, memoryBox{Name: []byte(n), Value: b[n]})
+	}
+
+	return json.Marshal(bs)
+}
+
+func (b *MemoryBoxes) UnmarshalJSON(bs []byte) error {
+	var boxes []memoryBox
+
+	err := json.Unmarshal(bs, &boxes)
+	if err != nil {
+		return err
+	}
+
+	*b = MemoryBoxes{}
+
+	for _, box := range boxes {
+		v := box.Value
+		if v == nil {
+			v = []byte{}
+		}
+		(*b)[string(box.Name)] = v
+	}
+
+	return nil
+}
+
+type MemoryHolding struct {
+	Amount uint64 `json:"amount"`
+	Frozen bool   `json:"frozen,omitempty"`
+}
+
+type MemoryAccount struct {
+	Balance    uint64 `json:"balance"`
+	MinBalance uint64 `json:"min-balance,omitempty"`
+	AuthAddr   string `json:"auth-addr,omitempty"`
+
+	Assets map[uint64]*MemoryHolding `json:"assets,omitempty"`
+	Apps   map[uint64]MemoryState    `json:"apps,omitempty"`
+}
+
+type MemoryApp struct {
+	Creator           string `json:"creator,omitempty"`
+	ApprovalProgram   []byte `json:"approval-program,omitempty"`
+	ClearStateProgram []byte `json:"clear-state-program,omitempty"`
+
+	GlobalNumUint      uint64 `json:"global-num-uint,omitempty"`
+	GlobalNumByteSlice uint64 `json:"global-num-byte-slice,omitempty"`
+	LocalNumUint       uint64 `json:"local-num-uint,omitempty"`
+	LocalNumByteSlice  uint64 `json:"local-num-byte-slice,omitempty"`
+	ExtraProgramPages  uint64 `json:"extra-program-pages,omitempty"`
+
+	Global MemoryState `json:"global,omitempty"`
+	Boxes  MemoryBoxes `json:"boxes,omitempty"`
+}
+
+type MemoryAsset struct {
+	Creator       string `json:"creator,omitempty"`
+	Total         uint64 `json:"total"`
+	Decimals      uint64 `json:"decimals,omitempty"`
+	DefaultFrozen bool   `json:"default-frozen,omitempty"`
+	UnitName      string `json:"unit-name,omitempty"`
+	Name          string `json:"name,omitempty"`
+	URL           string `json:"url,omitempty"`
+	MetadataHash  []byte `json:"metadata-hash,omitempty"`
+	Manager       string `json:"manager,omitempty"`
+	Reserve       string `json:"reserve,omitempty"`
+	Freeze        string `json:"freeze,omitempty"`
+	Clawback      string `json:"clawback,omitempty"`
+}
+
+// MemoryLedger is an in-memory Ledger with accounts keyed by address and apps and assets keyed by id
+type MemoryLedger struct {
+	Accounts map[string]*MemoryAccount `json:"accounts,omitempty"`
+	Apps     map[uint64]*MemoryApp     `json:"apps,omitempty"`
+	Assets   map[uint64]*MemoryAsset   `json:"assets,omitempty"`
+}
+
+func NewMemoryLedger() *MemoryLedger {
+	return &MemoryLedger{
+		Accounts: map[string]*MemoryAccount{},
+		Apps:     map[uint64]*MemoryApp{},
+		Assets:   map[uint64]*MemoryAsset{},
+	}
+}
+
+// ReadMemoryLedger reads a ledger from its JSON representation
+func ReadMemoryLedger(bs []byte) (*MemoryLedger, error) {
+	l := NewMemoryLedger()
+
+	err := json.Unmarshal(bs, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse ledger")
+	}
+
+	for a := range l.Accounts {
+		_, err := types.DecodeAddress(a)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid account address: %s", a)
+		}
+	}
+
+	return l, nil
+}
+
+func (l *MemoryLedger) account(addr types.Address) (*MemoryAccount, bool) {
+	a, ok := l.Accounts[addr.String()]
+	return a, ok
+}
+
+func (l *MemoryLedger) Balance(addr types.Address) uint64 {
+	a, ok := l.account(addr)
+	if !ok {
+		return 0
+	}
+
+	return a.Balance
+}
+
+// MinBalance returns the account min balance or the base min balance increased for each
+// asset and app the account holds if it's not set explicitly
+func (l *MemoryLedger) MinBalance(addr types.Address) uint64 {
+	a, ok := l.account(addr)
+	if !ok {
+		return 0
+	}
+
+	if a.MinBalance > 0 {
+		return a.MinBalance
+	}
+
+	return minBalance * uint64(1+len(a.Assets)+len(a.Apps))
+}
+
+func (l *MemoryLedger) OptedIn(addr types.Address, app uint64) bool {
+	a, ok := l.account(addr)
+	if !ok {
+		return false
+	}
+
+	_, ok = a.Apps[app]
+	return ok
+}
+
+func (l *MemoryLedger) AppGlobal(app uint64, key []byte) (VmValue, bool) {
+	a, ok := l.Apps[app]
+	if !ok {
+		return VmValue{}, false
+	}
+
+	v, ok := a.Global[string(key)]
+	return v, ok
+}
+
+func (l *MemoryLedger) AppLocal(addr types.Address, app uint64, key []byte) (VmValue, bool) {
+	a, ok := l.account(addr)
+	if !ok {
+		return VmValue{}, false
+	}
+
+	v, ok := a.Apps[app][string(key)]
+	return v, ok
+}
+
+func (l *MemoryLedger) Box(app uint64, name []byte) ([]byte, bool) {
+	a, ok := l.Apps[app]
+	if !ok {
+		return nil, false
+	}
+
+	v, ok := a.Boxes[string(name)]
+	return v, ok
+}
+
+func (l *MemoryLedger) AssetHolding(addr types.Address, asset uint64, f AssetHoldingField) (VmValue, bool) {
+	a, ok := l.account(addr)
+	if !ok {
+		return VmValue{}, false
+	}
+
+	h, ok := a.Assets[asset]
+	if !ok {
+		return VmValue{}, false
+	}
+
+	switch f {
+	case AssetBalance:
+		return Uint64Value(h.Amount), true
+	case AssetFrozen:
+		return vmBool(h.Frozen), true
+	default:
+		return VmValue{}, false
+	}
+}
+
+func (l *MemoryLedger) AssetParams(asset uint64, f AssetParamsField) (VmValue, bool) {
+	a, ok := l.Assets[asset]
+	if !ok {
+		return VmValue{}, false
+	}
+
+	switch f {
+	case AssetTotal:
+		return Uint64Value(a.Total), true
+	case AssetDecimals:
+		return Uint64Value(a.Decimals), true
+	case AssetDefaultFrozen:
+		return vmBool(a.DefaultFrozen), true
+	case AssetUnitName:
+		return BytesValue([]byte(a.UnitName)), true
+	case AssetName:
+		return BytesValue([]byte(a.Name)), true
+	case AssetURL:
+		return BytesValue([]byte(a.URL)), true
+	case AssetMetadataHash:
+		return BytesValue(append([]byte{}, a.MetadataHash...)), true
+	case AssetManager:
+		return addressValue(a.Manager), true
+	case AssetReserve:
+		return addressValue(a.Reserve), true
+	case AssetFreeze:
+		return addressValue(a.Freeze), true
+	case AssetClawback:
+		return addressValue(a.Clawback), true
+	case AssetCreator:
+		return addressValue(a.Creator), true
+	default:
+		return VmValue{}, false
+	}
+}
+
+func (l *MemoryLedger) AppParams(app uint64, f AppParamsField) (VmValue, bool) {
+	a, ok := l.Apps[app]
+	if !ok {
+		return VmValue{}, false
+	}
+
+	switch f {
+	case AppApprovalProgram:
+		return BytesValue(append([]byte{}, a.ApprovalProgram...)), true
+	case AppClearStateProgram:
+		return BytesValue(append([]byte{}, a.ClearStateProgram...)), true
+	case AppGlobalNumUint:
+		return Uint64Value(a.GlobalNumUint), true
+	case AppGlobalNumByteSlice:
+		return Uint64Value(a.GlobalNumByteSlice), true
+	case AppLocalNumUint:
+		return Uint64Value(a.LocalNumUint), true
+	case AppLocalNumByteSlice:
+		return Uint64Value(a.LocalNumByteSlice), true
+	case AppExtraProgramPages:
+		return Uint64Value(a.ExtraProgramPages), true
+	case AppCreator:
+		return addressValue(a.Creator), true
+	case AppAddress:
+		addr := appAddress(app)
+		return BytesValue(addr[:]), true
+	default:
+		return VmValue{}, false
+	}
+}
+
+func (l *MemoryLedger) AcctParams(addr types.Address, f AcctParamsField) (VmValue, bool) {
+	a, ok := l.account(addr)
+	if !ok || a.Balance == 0 {
+		return VmValue{}, false
+	}
+
+	creator := addr.String()
+
+	var n uint64
+	switch f {
+	case AcctBalance:
+		n = a.Balance
+	case AcctMinBalance:
+		n = l.MinBalance(addr)
+	case AcctAuthAddr:
+		return addressValue(a.AuthAddr), true
+	case AcctTotalNumUint:
+		for id, app := range l.Apps {
+			if app.Creator == creator {
+				n += app.GlobalNumUint
+			}
+			if _, ok := a.Apps[id]; ok {
+				n += app.LocalNumUint
+			}
+		}
+	case AcctTotalNumByteSlice:
+		for id, app := range l.Apps {
+			if app.Creator == creator {
+				n += app.GlobalNumByteSlice
+			}
+			if _, ok := a.Apps[id]; ok {
+				n += app.LocalNumByteSlice
+			}
+		}
+	case AcctTotalExtraAppPages:
+		for _, app := range l.Apps {
+			if app.Creator == creator {
+				n += app.ExtraProgramPages
+			}
+		}
+	case AcctTotalAppsCreated:
+		for _, app := range l.Apps {
+			if app.Creator == creator {
+				n++
+			}
+		}
+	case AcctTotalAppsOptedIn:
+		n = uint64(len(a.Apps))
+	case AcctTotalAssetsCreated:
+		for _, asset := range l.Assets {
+			if asset.Creator == creator {
+				n++
+			}
+		}
+	case AcctTotalAssets:
+		n = uint64(len(a.Assets))
+	case AcctTotalBoxes, AcctTotalBoxBytes:
+		for id, app := range l.Apps {
+			if appAddress(id) != addr {
+				continue
+			}
+
+			for name, v := range app.Boxes {
+				if f == AcctTotalBoxes {
+					n++
+				} else {
+					n += uint64(len(name) + len(v))
+				}
+			}
+		}
+	default:
+		return VmValue{}, false
+	}
+
+	return Uint64Value(n), true
+}
+
+// Apply stores the state changes made by the branch in the ledger
+func (l *MemoryLedger) Apply(b *VmBranch) error {
+	if b.state == nil {
+		return nil
+	}
+
+	if len(b.state.unknownGlobals) > 0 {
+		return errors.New("unknown global key")
+	}
+
+	if len(b.state.unknownLocals) > 0 {
+		return errors.New("unknown local key or account")
+	}
+
+	for k, v := range b.state.globals {
+		if v.T != VmTypeNone && !v.Known() {
+			return errors.Errorf("unknown value of global %q", k.key)
+		}
+
+		app, ok := l.Apps[k.app]
+		if !ok {
+			return errors.Errorf("app %d does not exist", k.app)
+		}
+
+		if app.Global == nil {
+			app.Global = MemoryState{}
+		}
+
+		if v.T == VmTypeNone {
+			delete(app.Global, k.key)
+		} else {
+			app.Global[k.key] = v
+		}
+	}
+
+	for k, v := range b.state.locals {
+		if v.T != VmTypeNone && !v.Known() {
+			return errors.Errorf("unknown value of local %q", k.key)
+		}
+
+		a, ok := l.account(k.addr)
+		if !ok {
+			return errors.Errorf("account %s does not exist", k.addr)
+		}
+
+		s, ok := a.Apps[k.app]
+		if !ok {
+			return errors.Errorf("account %s is not opted in to app %d", k.addr, k.app)
+		}
+
+		if s == nil {
+			s = MemoryState{}
+			a.Apps[k.app] = s
+		}
+
+		if v.T == VmTypeNone {
+			delete(s, k.key)
+		} else {
+			s[k.key] = v
+		}
+	}
+
+	for k, v := range b.state.boxes {
+		app, ok := l.Apps[k.app]
+		if !ok {
+			return errors.Errorf("app %d does not exist", k.app)
+		}
+
+		if app.Boxes == nil {
+			app.Boxes = MemoryBoxes{}
+		}
+
+		if v.T == VmTypeNone {
+			delete(app.Boxes, k.key)
+		} else {
+			app.Boxes[k.key] = v.b()
+		}
+	}
+
+	for addr, v := range b.state.balances {
+		a, ok := l.account(addr)
+		if !ok {
+			a = &MemoryAccount{}
+			l.Accounts[addr.String()] = a
+		}
+
+		a.Balance = v
+	}
+
+	for k, v := range b.state.holdings {
+		a, ok := l.account(k.addr)
+		if !ok {
+			return errors.Errorf("account %s does not exist", k.addr)
+		}
+
+		h, ok := a.Assets[k.app]
+		if !ok {
+			return errors.Errorf("account %s is not opted in to asset %d", k.addr, k.app)
+		}
+
+		h.Amount = v
+	}
+
+	return nil
+}
+
+type vmStateKey struct {
+	addr types.Address
+	app  uint64
+	key  string
+}
+
+// vmState keeps the changes made by a branch on top of the vm ledger, deleted entries have no type
+type vmState struct {
+	Ledger
+
+	globals  map[vmStateKey]VmValue
+	locals   map[vmStateKey]VmValue
+	boxes    map[vmStateKey]VmValue
+	balances map[types.Address]uint64
+	holdings map[vmStateKey]uint64
+
+	// apps whose global or local state was written under a key or an account that is not known
+	unknownGlobals map[uint64]bool
+	unknownLocals  map[uint64]bool
+}
+
+func newVmState(l Ledger) *vmState {
+	return &vmState{
+		Ledger:   l,
+		globals:  map[vmStateKey]VmValue{},
+		locals:   map[vmStateKey]VmValue{},
+		boxes:    map[vmStateKey]VmValue{},
+		balances: map[types.Address]uint64{},
+		holdings: map[vmStateKey]uint64{},
+
+		unknownGlobals: map[uint64]bool{},
+		unknownLocals:  map[uint64]bool{},
+	}
+}
+
+func (s *vmState) clone() *vmState {
+	if s == nil {
+		return nil
+	}
+
+	ns := newVmState(s.Ledger)
+
+	for k, v := range s.globals {
+		ns.globals[k] = v
+	}
+
+	for k, v := range s.locals {
+		ns.locals[k] = v
+	}
+
+	for k, v := range s.boxes {
+		ns.boxes[k] = v
+	}
+
+	for k, v := range s.balances {
+		ns.balances[k] = v
+	}
+
+	for k, v := range s.holdings {
+		ns.holdings[k] = v
+	}
+
+	for k, v := range s.unknownGlobals {
+		ns.unknownGlobals[k] = v
+	}
+
+	for k, v := range s.unknownLocals {
+		ns.unknownLocals[k] = v
+	}
+
+	return ns
+}
+
+func (s *vmState) AppGlobal(app uint64, key []byte) (VmValue, bool) {
+	v, ok := s.globals[vmStateKey{app: app, key: string(key)}]
+	if ok {
+		return v, v.T != VmTypeNone
+	}
+
+	return s.Ledger.AppGlobal(app, key)
+}
+
+func (s *vmState) AppLocal(addr types.Address, app uint64, key []byte) (VmValue, bool) {
+	v, ok := s.locals[vmStateKey{addr: addr, app: app, key: string(key)}]
+	if ok {
+		return v, v.T != VmTypeNone
+	}
+
+	return s.Ledger.AppLocal(addr, app, key)
+}
+
+func (s *vmState) Box(app uint64, name []byte) ([]byte, bool) {
+	v, ok := s.boxes[vmStateKey{app: app, key: string(name)}]
+	if ok {
+		return v.b(), v.T != VmTypeNone
+	}
+
+	return s.Ledger.Box(app, name)
+}
+
+func (s *vmState) Balance(addr types.Address) uint64 {
+	v, ok := s.balances[addr]
+	if ok {
+		return v
+	}
+
+	return s.Ledger.Balance(addr)
+}
+
+func (s *vmState) AssetHolding(addr types.Address, asset uint64, f AssetHoldingField) (VmValue, bool) {
+	v, ok := s.Ledger.AssetHolding(addr, asset, f)
+	if ok && f == AssetBalance {
+		if n, ok := s.holdings[vmStateKey{addr: addr, app: asset}]; ok {
+			return Uint64Value(n), true
+		}
+	}
+
+	return v, ok
+}
+
+func (s *vmState) AcctParams(addr types.Address, f AcctParamsField) (VmValue, bool) {
+	v, ok := s.Ledger.AcctParams(addr, f)
+	if ok && f == AcctBalance {
+		return Uint64Value(s.Balance(addr)), true
+	}
+
+	return v, ok
+}
+
+func (s *vmState) setBalance(addr types.Address, v uint64) {
+	s.balances[addr] = v
+}
+
+func (s *vmState) setHolding(addr types.Address, asset uint64, v uint64) {
+	s.holdings[vmStateKey{addr: addr, app: asset}] = v
+}
+
+func (s *vmState) setAppGlobal(app uint64, key []byte, v VmValue) {
+	s.globals[vmStateKey{app: app, key: string(key)}] = v
+}
+
+// knowsAppGlobal reports whether the global is not shadowed by an earlier write to an unknown key
+func (s *vmState) knowsAppGlobal(app uint64, key []byte) bool {
+	_, ok := s.globals[vmStateKey{app: app, key: string(key)}]
+	return ok || !s.unknownGlobals[app]
+}
+
+// knowsAppLocal reports whether the local is not shadowed by an earlier write to an unknown key or account
+func (s *vmState) knowsAppLocal(addr types.Address, app uint64, key []byte) bool {
+	_, ok := s.locals[vmStateKey{addr: addr, app: app, key: string(key)}]
+	return ok || !s.unknownLocals[app]
+}
+
+func (s *vmState) setUnknownAppGlobal(app uint64) {
+	s.unknownGlobals[app] = true
+}
+
+func (s *vmState) setUnknownAppLocal(app uint64) {
+	s.unknownLocals[app] = true
+}
+
+func (s *vmState) setAppLocal(addr types.Address, app uint64, key []byte, v VmValue) error {
+	if !s.OptedIn(addr, app) {
+		return errors.Errorf("account %s is not opted in to app %d", addr, app)
+	}
+
+	s.locals[vmStateKey{addr: addr, app: app, key: string(key)}] = v
+	return nil
+}
+
+func (s *vmState) setBox(app uint64, name []byte, v []byte) {
+	s.boxes[vmStateKey{app: app, key: string(name)}] = BytesValue(v)
+}
+
+func (s *vmState) delBox(app uint64, name []byte) {
+	s.boxes[vmStateKey{app: app, key: string(name)}] = VmValue{}
+}
@@ -0,0 +1,193 @@
+package teal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+func readTestLedger(t *testing.T) *MemoryLedger {
+	l, err := ReadMemoryLedger([]byte(fmt.Sprintf(`{
+	"accounts": {
+		%q: {
+			"balance": 1000000,
+			"assets": {"7": {"amount": 25}},
+			"apps": {"5": []}
+		}
+	},
+	"apps": {
+		"5": {
+			"global": [{"key": "Y291bnRlcg==", "value": {"type": 2, "uint": 41}}]
+		}
+	}
+}`, types.ZeroAddress.String())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestLedgerState(t *testing.T) {
+	l := readTestLedger(t)
+
+	vm := NewVm(Process(`#pragma version 8
+byte 0x`+strings.Repeat("00", 32)+`
+store 0
+byte "counter"
+byte "counter"
+app_global_get
+int 1
++
+app_global_put
+load 0
+byte "seen"
+int 1
+app_local_put
+load 0
+int 7
+asset_holding_get AssetBalance
+assert
+int 25
+==
+assert
+load 0
+balance
+int 1000000
+==
+assert
+byte "box"
+int 4
+box_create
+assert
+byte "box"
+int 1
+byte 0xff
+box_replace
+byte "box"
+box_get
+assert`), WithConcrete(), WithLedger(l), WithApp(5))
+	vm.Run()
+
+	// the box value is left on the stack to be checked, which fails the program
+	if vm.Error == nil || vm.Branches[0].Outcome != VmErr {
+		t.Fatalf("expected the byte array result to fail the program: %v", vm.Error)
+	}
+
+	b := vm.Branches[0]
+	if len(b.Stack.Items) != 1 || !b.Stack.Items[0].equal(BytesValue([]byte{0, 0xff, 0, 0})) {
+		t.Errorf("unexpected stack: %v", b.Stack.Items)
+	}
+
+	if v, _ := l.AppGlobal(5, []byte("counter")); !v.equal(Uint64Value(41)) {
+		t.Errorf("ledger modified before apply: %v", v)
+	}
+
+	err := l.Apply(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := l.AppGlobal(5, []byte("counter")); !v.equal(Uint64Value(42)) {
+		t.Errorf("unexpected counter: %v", v)
+	}
+
+	if v, _ := l.AppLocal(types.ZeroAddress, 5, []byte("seen")); !v.equal(Uint64Value(1)) {
+		t.Errorf("unexpected local: %v", v)
+	}
+
+	if v, ok := l.Box(5, []byte("box")); !ok || len(v) != 4 {
+		t.Errorf("unexpected box: %v (%t)", v, ok)
+	}
+}
+
+func TestLedgerStatePerBranch(t *testing.T) {
+	vm := NewVm(Process(`#pragma version 8
+txn NumAppArgs
+bnz other
+byte "k"
+int 1
+app_global_put
+b end
+other:
+byte "k"
+int 2
+app_global_put
+end:
+int 1`), WithLedger(readTestLedger(t)), WithApp(5))
+	vm.Run()
+
+	if vm.Error != nil {
+		t.Fatal(vm.Error)
+	}
+
+	if len(vm.Branches) != 2 {
+		t.Fatalf("unexpected number of branches: %d", len(vm.Branches))
+	}
+
+	for i, expected := range []uint64{1, 2} {
+		v, ok := vm.Branches[i].Ledger().AppGlobal(5, []byte("k"))
+		if !ok || !v.equal(Uint64Value(expected)) {
+			t.Errorf("unexpected global of branch %d: %v (%t)", i, v, ok)
+		}
+	}
+}
+
+func TestLedgerErrors(t *testing.T) {
+	tests := []string{
+		"byte \"box\"\nint 4\nbox_create\nbyte \"box\"\nbyte 0x01\nbox_put",
+		"byte \"box\"\nint 0\nbyte 0x01\nbox_replace",
+		"int 1\nbyte \"k\"\nint 1\napp_local_put",
+	}
+
+	for _, src := range tests {
+		vm := NewVm(Process(src), WithConcrete(), WithLedger(readTestLedger(t)), WithApp(5))
+		vm.Run()
+
+		if vm.Error == nil {
+			t.Errorf("expected error for: %s", src)
+		}
+	}
+}
+
+func TestLedgerPutGet(t *testing.T) {
+	addr := "byte 0x" + strings.Repeat("00", 32)
+
+	tests := []struct {
+		Src   string
+		Value VmValue
+	}{
+		{"byte \"new\"\nint 7\napp_global_put\nbyte \"new\"\napp_global_get", Uint64Value(7)},
+		{addr + "\nbyte \"new\"\nint 7\napp_local_put\n" + addr + "\nbyte \"new\"\napp_local_get", Uint64Value(7)},
+		{"txna ApplicationArgs 0\nint 7\napp_global_put\nbyte \"counter\"\napp_global_get", VmValue{}},
+		{"txna ApplicationArgs 0\napp_global_del\nbyte \"counter\"\napp_global_get", VmValue{}},
+		{"txna ApplicationArgs 0\nint 7\napp_global_put\nbyte \"counter\"\nint 1\napp_global_put\nbyte \"counter\"\napp_global_get", Uint64Value(1)},
+		{addr + "\ntxna ApplicationArgs 0\nint 7\napp_local_put\n" + addr + "\nbyte \"seen\"\napp_local_get", VmValue{}},
+		{"txna Accounts 1\nbyte \"seen\"\nint 7\napp_local_put\n" + addr + "\nbyte \"seen\"\napp_local_get", VmValue{}},
+	}
+
+	for _, test := range tests {
+		vm := NewVm(Process("#pragma version 8\n"+test.Src), WithLedger(readTestLedger(t)), WithApp(5))
+		vm.Run()
+
+		if vm.Error != nil {
+			t.Fatalf("%s: %v", test.Src, vm.Error)
+		}
+
+		b := vm.Branches[0]
+		if len(b.Stack.Items) != 1 {
+			t.Fatalf("%s: unexpected stack: %v", test.Src, b.Stack.Items)
+		}
+
+		v := b.Stack.Items[0]
+		if test.Value.Known() {
+			if !v.equal(test.Value) {
+				t.Errorf("%s: unexpected value: %v", test.Src, v)
+			}
+		} else if v.Known() {
+			t.Errorf("%s: expected an unknown value, got: %v", test.Src, v)
+		}
+	}
+}
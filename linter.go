@@ -2,6 +2,7 @@ package teal
 
 import (
 	"fmt"
+	"sort"
 )
 
 type RedundantLine interface {
@@ -41,7 +42,11 @@ type LineError interface {
 }
 
 type Linter struct {
-	l Listing
+	l    Listing
+	mode ProgramMode
+
+	// app enables the security rules of applications
+	app bool
 
 	errs []LineError
 	reds []RedundantLine
@@ -396,6 +401,148 @@ func (l *Linter) checkPragma() {
 	}
 }
 
+// labelLines returns the line of the first definition of every label
+func (l *Linter) labelLines() map[string]int {
+	res := map[string]int{}
+	for i, o := range l.l {
+		if o, ok := o.(*LabelExpr); ok {
+			if _, ok := res[o.Name]; !ok {
+				res[o.Name] = i
+			}
+		}
+	}
+
+	return res
+}
+
+// next returns the lines the execution may continue at after the line, callsub continues after the call
+func (l *Linter) next(i int) []int {
+	var res []int
+
+	target := func(lbl *LabelExpr) {
+		if ln, ok := l.labelLines()[lbl.Name]; ok {
+			res = append(res, ln)
+		}
+	}
+
+	switch o := l.l[i].(type) {
+	case Terminator:
+		return nil
+	case *BExpr:
+		target(o.Label)
+		return res
+	case *BnzExpr:
+		target(o.Label)
+	case *BzExpr:
+		target(o.Label)
+	case *SwitchExpr:
+		for _, t := range o.Targets {
+			target(t)
+		}
+	case *MatchExpr:
+		for _, t := range o.Targets {
+			target(t)
+		}
+	}
+
+	if i+1 < len(l.l) {
+		res = append(res, i+1)
+	}
+
+	return res
+}
+
+// subroutines returns the lines of the callsub targets
+func (l *Linter) subroutines() map[string]int {
+	lines := l.labelLines()
+	res := map[string]int{}
+
+	for _, o := range l.l {
+		if o, ok := o.(*CallSubExpr); ok {
+			if ln, ok := lines[o.Label.Name]; ok {
+				res[o.Label.Name] = ln
+			}
+		}
+	}
+
+	return res
+}
+
+// proto returns the proto that starts the subroutine at the line
+func (l *Linter) proto(line int) (*ProtoExpr, bool) {
+	for i := line; i < len(l.l); i++ {
+		switch o := l.l[i].(type) {
+		case *ProtoExpr:
+			return o, true
+		case Nop:
+		default:
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// routine are the lines reachable from the entry of the main program or of a subroutine without entering the callees
+type routine struct {
+	entry int
+	lines []int
+	preds map[int][]int
+}
+
+func (l *Linter) routine(entry int) *routine {
+	r := &routine{
+		entry: entry,
+		preds: map[int][]int{},
+	}
+
+	seen := map[int]bool{entry: true}
+	work := []int{entry}
+
+	for len(work) > 0 {
+		i := work[0]
+		work = work[1:]
+
+		r.lines = append(r.lines, i)
+
+		for _, n := range l.next(i) {
+			r.preds[n] = append(r.preds[n], i)
+			if !seen[n] {
+				seen[n] = true
+				work = append(work, n)
+			}
+		}
+	}
+
+	sort.Ints(r.lines)
+
+	return r
+}
+
+// lintErrors are the errors found by a checker, the same error found again on a line is kept once
+type lintErrors map[string]LineError
+
+func (e lintErrors) add(err LineError) {
+	e[fmt.Sprintf("%d %s", err.Line(), err)] = err
+}
+
+// report adds the errors ordered by the line and the message
+func (l *Linter) report(errs lintErrors) {
+	res := make([]LineError, 0, len(errs))
+	for _, err := range errs {
+		res = append(res, err)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Line() != res[j].Line() {
+			return res[i].Line() < res[j].Line()
+		}
+		return res[i].Error() < res[j].Error()
+	})
+
+	l.errs = append(l.errs, res...)
+}
+
 func (l *Linter) Lint() {
 	l.checkDuplicatedLabels()
 	l.checkUnusedLabels()
@@ -403,4 +550,8 @@ func (l *Linter) Lint() {
 	l.checkBranchJustBeforeLabel()
 	l.checkLoops()
 	l.checkPragma()
+	l.checkStack()
+	l.checkSubroutines()
+	l.checkScratch()
+	l.checkSecurity()
 }
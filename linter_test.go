@@ -0,0 +1,312 @@
+package teal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testLint checks that the source has exactly the expected diagnostics, given as "line: message" with 1-based lines
+func testLint(t *testing.T, src string, expected ...string) {
+	checkLint(t, Process(src), src, expected...)
+}
+
+// testAppLint is testLint with the security rules of applications enabled
+func testAppLint(t *testing.T, src string, expected ...string) {
+	checkLint(t, Process(src, WithAppRules()), src, expected...)
+}
+
+func checkLint(t *testing.T, res *ProcessResult, src string, expected ...string) {
+
+	actual := map[string]bool{}
+	for _, d := range res.Diagnostics {
+		actual[fmt.Sprintf("%d: %s", d.Line()+1, d)] = true
+	}
+
+	for _, e := range expected {
+		if !actual[e] {
+			t.Errorf("missing diagnostic %q in:\n%s\ngot: %v", e, src, actual)
+		}
+		delete(actual, e)
+	}
+
+	for a := range actual {
+		t.Errorf("unexpected diagnostic %q in:\n%s", a, src)
+	}
+}
+
+func TestLintStack(t *testing.T) {
+	testLint(t, "#pragma version 8\nint 1\nbtoi\nreturn", "3: btoi arg 0 expects bytes, got uint64")
+	testLint(t, "#pragma version 8\nbyte \"a\"\nint 1\n+\nreturn", "4: + arg 0 expects uint64, got bytes")
+	testLint(t, "#pragma version 8\ntxn Sender\ntxn Fee\n+\nreturn", "4: + arg 0 expects uint64, got bytes")
+	testLint(t, "#pragma version 8\nint 1\n+\nreturn", "3: stack underflow: + needs 2 values, stack has 1")
+	testLint(t, "#pragma version 8\ntxn Fee\nbnz a\nint 1\na:\nint 1\nreturn", "5: inconsistent stack height at join point: 0 and 1")
+	testLint(t, "#pragma version 8\nbyte \"a\"\nint 1\nswap\nbtoi\n+\nreturn")
+	testLint(t, "#pragma version 8\nint 1\nbyte \"a\"\ncallsub f\nreturn\nf:\nbtoi\n+\nretsub")
+	testLint(t, "#pragma version 8\nint 1\ncallsub f\nbtoi\nreturn\nf:\nproto 1 1\nframe_dig -1\nretsub")
+	testLint(t, "#pragma version 8\nbyte \"a\"\ncallsub f\nbtoi\nreturn\nf:\nint 1\n+\nretsub",
+		"4: btoi arg 0 expects bytes, got uint64")
+}
+
+func TestLintTokenRange(t *testing.T) {
+	res := Process("#pragma version 8\nint 1\n  btoi // comment\nreturn")
+
+	if len(res.Diagnostics) != 1 {
+		t.Fatalf("unexpected diagnostics: %v", res.Diagnostics)
+	}
+
+	d := res.Diagnostics[0]
+	if d.Begin() != 2 || d.End() != 6 {
+		t.Errorf("unexpected range: %d-%d", d.Begin(), d.End())
+	}
+}
+
+const testSig = `#pragma version 8
+// #pragma mode logicsig
+txn RekeyTo
+global ZeroAddress
+==
+txn CloseRemainderTo
+global ZeroAddress
+==
+&&
+assert
+txn TypeEnum
+int pay
+==
+assert
+int 1000
+txn Fee
+>=
+global GroupSize
+int 1
+==
+&&
+return
+`
+
+func TestLintSig(t *testing.T) {
+	testLint(t, testSig)
+	testLint(t, "#pragma version 8\n// #pragma mode logicsig\nint 1\nreturn",
+		"4: approving path does not check txn RekeyTo == global ZeroAddress",
+		"4: approving path does not check txn CloseRemainderTo == global ZeroAddress",
+		"4: approving path does not check txn AssetCloseTo == global ZeroAddress",
+		"4: approving path does not check an upper bound of txn Fee",
+		"4: approving path does not check an upper bound of global GroupSize")
+	testLint(t, "#pragma version 8\nint 1\nreturn")
+	testLint(t, "#pragma version 8\n// #pragma mode logicsig\nint 0\nreturn")
+	testLint(t, `#pragma version 8
+// #pragma mode logicsig
+callsub check
+txn TypeEnum
+int axfer
+!=
+bnz fail
+txn AssetCloseTo
+global ZeroAddress
+==
+return
+fail:
+err
+check:
+global ZeroAddress
+txn RekeyTo
+==
+assert
+global GroupSize
+int 2
+<=
+txn Fee
+global MinTxnFee
+>
+!
+&&
+assert
+retsub`)
+	testLint(t, `#pragma version 8
+// #pragma mode logicsig
+txn RekeyTo
+global ZeroAddress
+==
+txn TypeEnum
+int appl
+==
+&&
+global GroupSize
+int 1
+==
+&&
+txn Fee
+bz free
+txn Fee
+int 1000
+<
+return
+free:
+int 1`, "21: approving path does not check txn RekeyTo == global ZeroAddress",
+		"21: approving path does not check txn CloseRemainderTo == global ZeroAddress",
+		"21: approving path does not check txn AssetCloseTo == global ZeroAddress",
+		"21: approving path does not check an upper bound of global GroupSize",
+		"19: approving path does not check txn RekeyTo == global ZeroAddress",
+		"19: approving path does not check txn CloseRemainderTo == global ZeroAddress",
+		"19: approving path does not check txn AssetCloseTo == global ZeroAddress",
+		"19: approving path does not check an upper bound of global GroupSize")
+}
+
+func TestLintSigRules(t *testing.T) {
+	res := Process(strings.Replace(testSig, "txn RekeyTo", "txn Receiver", 1))
+	if len(res.Diagnostics) != 1 {
+		t.Fatalf("unexpected diagnostics: %v", res.Diagnostics)
+	}
+	if r := res.Diagnostics[0].Rule(); r != "LSIG_REKEY" {
+		t.Errorf("unexpected rule: %s", r)
+	}
+}
+
+func TestLintApp(t *testing.T) {
+	testLint(t, "#pragma version 8\nint 1\nreturn")
+	testAppLint(t, "#pragma version 8\nint 1\nreturn",
+		"3: approving path does not check txn Sender before allowing UpdateApplication",
+		"3: approving path does not check txn Sender before allowing DeleteApplication",
+		"3: approving path does not check txn OnCompletion before allowing OptIn",
+		"3: approving path does not check txn OnCompletion before allowing CloseOut")
+	testAppLint(t, "#pragma version 8\n// #pragma mode logicsig\nint 1\nreturn",
+		"4: approving path does not check txn RekeyTo == global ZeroAddress",
+		"4: approving path does not check txn CloseRemainderTo == global ZeroAddress",
+		"4: approving path does not check txn AssetCloseTo == global ZeroAddress",
+		"4: approving path does not check an upper bound of txn Fee",
+		"4: approving path does not check an upper bound of global GroupSize")
+	testAppLint(t, "#pragma version 8\ntxn OnCompletion\nint NoOp\n==\nreturn")
+	testAppLint(t, "#pragma version 8\ntxn OnCompletion\nint DeleteApplication\n!=\nassert\nint 1\nreturn",
+		"7: approving path does not check txn Sender before allowing UpdateApplication",
+		"7: approving path does not check txn OnCompletion before allowing OptIn",
+		"7: approving path does not check txn OnCompletion before allowing CloseOut")
+	testAppLint(t, "#pragma version 8\nbyte \"x\"\napp_global_get\npop\nint 1\nreturn",
+		"6: approving path does not check txn Sender before allowing UpdateApplication",
+		"6: approving path does not check txn Sender before allowing DeleteApplication",
+		"6: approving path does not check txn OnCompletion before allowing OptIn",
+		"6: approving path does not check txn OnCompletion before allowing CloseOut")
+	testAppLint(t, `#pragma version 8
+txn OnCompletion
+int UpdateApplication
+>=
+bz main
+txn Sender
+byte "admin"
+app_global_get
+==
+return
+main:
+int NoOp
+int OptIn
+txn OnCompletion
+match noop optin
+err
+noop:
+int 1
+return
+optin:
+int 1
+return`)
+	testAppLint(t, `#pragma version 8
+txn OnCompletion
+int CloseOut
+<
+assert
+int 1
+return`, "7: approving path does not check txn OnCompletion before allowing OptIn")
+	testAppLint(t, `#pragma version 8
+txn OnCompletion
+int DeleteApplication
+!=
+assert
+txn OnCompletion
+int UpdateApplication
+==
+return`, "9: approving path does not check txn Sender before allowing UpdateApplication")
+	testAppLint(t, `#pragma version 8
+itxn_begin
+int pay
+itxn_field TypeEnum
+int 0
+itxn_field Fee
+itxn_next
+int pay
+itxn_field TypeEnum
+itxn_submit
+txn OnCompletion
+!
+return`, "10: inner transaction does not set Fee to 0")
+
+	tests := []struct {
+		Src  string
+		Rule string
+	}{
+		{Src: "#pragma version 8\nitxn_begin\nitxn_submit\ntxn OnCompletion\n!\nreturn", Rule: "APP_INNER_FEE"},
+		{Src: "#pragma version 8\ntxn OnCompletion\nint NoOp\n!=\nreturn", Rule: "APP_UPDATE"},
+		{Src: "#pragma version 8\ntxn OnCompletion\nint NoOp\n!=\nreturn", Rule: "APP_ON_COMPLETION"},
+	}
+
+	for _, test := range tests {
+		res := Process(test.Src, WithAppRules())
+
+		found := false
+		for _, d := range res.Diagnostics {
+			found = found || d.Rule() == test.Rule
+		}
+
+		if !found {
+			t.Errorf("missing %s diagnostic for: %s", test.Rule, test.Src)
+		}
+	}
+}
+
+func TestLintScratch(t *testing.T) {
+	testLint(t, "#pragma version 8\nint 1\nstore 0\nload 0\nreturn")
+	testLint(t, "#pragma version 8\ntxn Fee\nbz skip\nint 1\nstore 0\nskip:\nload 0\nreturn",
+		"7: slot 0 may be loaded before it is stored")
+	testLint(t, "#pragma version 8\nint 1\nstore 0\nint 2\nstore 0\nload 0\nreturn",
+		"3: slot 0 is stored but never read")
+	testLint(t, "#pragma version 8\nint 1\nstore 1\nerr",
+		"3: slot 1 is stored but never read")
+	testLint(t, "#pragma version 8\nint 1\nstore 0\nload 0\npop\nbyte \"a\"\nstore 0\nload 0\nlen\nreturn",
+		"3: slot 0 is stored with both uint64 and bytes",
+		"7: slot 0 is stored with both uint64 and bytes")
+	testLint(t, "#pragma version 8\ncallsub f\nload 0\nreturn\nf:\nint 2\nstore 0\nretsub")
+	testLint(t, "#pragma version 8\nint 1\nstore 0\ncallsub f\nload 0\nreturn\nf:\ntxn Fee\nbz done\nint 2\nstore 0\ndone:\nretsub",
+		"4: subroutine f overwrites slot 0 that is still used by the caller")
+	testLint(t, "#pragma version 8\nint 1\nstore 0\ncallsub f\nreturn\nf:\nload 0\nretsub")
+	testLint(t, "#pragma version 8\ncallsub f\nreturn\nf:\nload 0\nretsub",
+		"5: slot 0 may be loaded before it is stored")
+	testLint(t, "#pragma version 8\nint 5\nint 1\nstores\nload 5\nreturn")
+	testLint(t, "#pragma version 8\nint 5\nint 1\nstores\nint 5\nbyte \"a\"\nstores\nload 5\nlen\nreturn",
+		"4: slot 5 is stored but never read",
+		"4: slot 5 is stored with both uint64 and bytes",
+		"7: slot 5 is stored with both uint64 and bytes")
+	testLint(t, "#pragma version 8\ntxn Fee\nint 1\nstores\nload 5\nreturn",
+		"5: slot 5 may be loaded before it is stored")
+	testLint(t, "#pragma version 8\nint 1\nstore 3\ncallsub f\nload 3\nreturn\nf:\ntxn Fee\nint 1\nstores\nretsub",
+		"4: subroutine f overwrites slot 3 that is still used by the caller")
+}
+
+func TestLintSubroutines(t *testing.T) {
+	testLint(t, "#pragma version 8\nint 1\nretsub",
+		"3: retsub outside of a subroutine")
+	testLint(t, "#pragma version 8\ncallsub f\nint 1\nf:\nint 1\nretsub",
+		"4: code falls through into subroutine f",
+		"6: retsub outside of a subroutine")
+	testLint(t, "#pragma version 8\ncallsub f\nreturn\nf:\ncallsub f\nretsub",
+		"4: subroutine f recurses without an exit")
+	testLint(t, "#pragma version 8\nint 3\ncallsub f\nreturn\nf:\nproto 1 1\nframe_dig -1\nbz done\nframe_dig -1\nint 1\n-\ncallsub f\nretsub\ndone:\nint 1\nretsub")
+	testLint(t, "#pragma version 8\nint 1\ncallsub f\nreturn\nf:\nproto 1 1\nretsub",
+		"7: proto declares 1 results, the frame has 0 values at retsub")
+	testLint(t, "#pragma version 8\nint 1\ncallsub f\nint 1\nreturn\nf:\nproto 1 0\npop\npop\nretsub",
+		"10: proto declares 1 args, the subroutine pops 2 values of the caller")
+	testLint(t, "#pragma version 8\nint 1\ncallsub f\nreturn\nf:\nproto 1 1\nframe_dig -2\nframe_dig 1\nretsub",
+		"7: frame_dig -2 is below the 1 args of the proto",
+		"8: frame_dig 1 is above the 1 values of the frame")
+	testLint(t, "#pragma version 8\nint 1\ncallsub f\nreturn\nf:\nproto 1 1\nint 1\nframe_bury 0\nframe_dig -1\nretsub",
+		"8: frame_bury 0 is above the 0 values of the frame")
+	testLint(t, "#pragma version 8\nint 1\nframe_dig -1\nreturn",
+		"3: frame_dig outside of a proto subroutine")
+}
@@ -46,6 +46,7 @@ type lintError struct {
 	e int
 
 	s DiagnosticSeverity
+	r string
 }
 
 func (e lintError) Line() int {
@@ -69,6 +70,10 @@ func (e lintError) Severity() DiagnosticSeverity {
 }
 
 func (e lintError) Rule() string {
+	if e.r != "" {
+		return e.r
+	}
+
 	return "LINT"
 }
 
@@ -860,6 +860,8 @@ type parserContext struct {
 	mode    ProgramMode
 	version uint64
 
+	app bool
+
 	ops  []Op
 	args *arguments
 	diag []Diagnostic
@@ -3129,7 +3131,17 @@ func readTokens(source string) ([]Token, []Diagnostic) {
 	return ts, diags
 }
 
-func Process(source string) *ProcessResult {
+type ProcessOption func(c *parserContext)
+
+// WithAppRules enables the security rules of applications, they are opt-in since every program is an application
+// unless it is marked with // #pragma mode logicsig
+func WithAppRules() ProcessOption {
+	return func(c *parserContext) {
+		c.app = true
+	}
+}
+
+func Process(source string, opts ...ProcessOption) *ProcessResult {
 	c := &parserContext{
 		version: 1,
 		ops:     []Op{},
@@ -3138,6 +3150,10 @@ func Process(source string) *ProcessResult {
 		refc:    map[string]int{},
 	}
 
+	for _, opt := range opts {
+		opt(c)
+	}
+
 	var ts []Token
 	ts, c.diag = readTokens(source)
 
@@ -3294,17 +3310,29 @@ func Process(source string) *ProcessResult {
 		lts = append(lts, c.args.ts)
 	}
 
-	l := &Linter{l: c.ops}
+	l := &Linter{l: c.ops, mode: c.mode, app: c.app}
 	l.Lint()
 
 	for _, le := range l.errs {
 		ln := lts[le.Line()]
+		b, e := ln.Begin(), ln.End()
+
+		if te, ok := le.(TokenError); ok && te.Token() < len(ln) {
+			b, e = ln[te.Token()].b, ln[te.Token()].e
+		}
+
+		var r string
+		if re, ok := le.(RuleError); ok {
+			r = re.Rule()
+		}
+
 		c.diag = append(c.diag, lintError{
 			error: le,
 			l:     le.Line(),
-			b:     ln.Begin(),
-			e:     ln.End(),
+			b:     b,
+			e:     e,
 			s:     le.Severity(),
+			r:     r,
 		})
 	}
 
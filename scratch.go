@@ -0,0 +1,552 @@
+package teal
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+type UninitializedSlotError struct {
+	l    int
+	slot uint8
+}
+
+func (e UninitializedSlotError) Line() int {
+	return e.l
+}
+
+func (e UninitializedSlotError) Error() string {
+	return fmt.Sprintf("slot %d may be loaded before it is stored", e.slot)
+}
+
+func (e UninitializedSlotError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type DeadStoreError struct {
+	l    int
+	slot uint8
+}
+
+func (e DeadStoreError) Line() int {
+	return e.l
+}
+
+func (e DeadStoreError) Error() string {
+	return fmt.Sprintf("slot %d is stored but never read", e.slot)
+}
+
+func (e DeadStoreError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type MixedSlotTypesError struct {
+	l    int
+	slot uint8
+}
+
+func (e MixedSlotTypesError) Line() int {
+	return e.l
+}
+
+func (e MixedSlotTypesError) Error() string {
+	return fmt.Sprintf("slot %d is stored with both uint64 and bytes", e.slot)
+}
+
+func (e MixedSlotTypesError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type SlotCollisionError struct {
+	l    int
+	slot uint8
+	name string
+}
+
+func (e SlotCollisionError) Line() int {
+	return e.l
+}
+
+func (e SlotCollisionError) Error() string {
+	return fmt.Sprintf("subroutine %s overwrites slot %d that is still used by the caller", e.name, e.slot)
+}
+
+func (e SlotCollisionError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+// slotSet is a set of scratch slots
+type slotSet [4]uint64
+
+var allSlots = slotSet{^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0)}
+
+func (s slotSet) has(i uint8) bool {
+	return s[i/64]&(1<<(i%64)) != 0
+}
+
+func (s *slotSet) add(i uint8) {
+	s[i/64] |= 1 << (i % 64)
+}
+
+func (s *slotSet) remove(i uint8) {
+	s[i/64] &^= 1 << (i % 64)
+}
+
+func (s slotSet) and(o slotSet) slotSet {
+	for i := range s {
+		s[i] &= o[i]
+	}
+	return s
+}
+
+func (s slotSet) or(o slotSet) slotSet {
+	for i := range s {
+		s[i] |= o[i]
+	}
+	return s
+}
+
+func (s slotSet) without(o slotSet) slotSet {
+	for i := range s {
+		s[i] &^= o[i]
+	}
+	return s
+}
+
+// slots returns the slots of the set in ascending order
+func (s slotSet) slots() []uint8 {
+	var res []uint8
+	for i, w := range s {
+		for w != 0 {
+			b := bits.TrailingZeros64(w)
+			res = append(res, uint8(i*64+b))
+			w &^= 1 << b
+		}
+	}
+	return res
+}
+
+type scratchChecker struct {
+	l *Linter
+
+	main  *routine
+	names []string
+	subs  map[string]*routine
+
+	// loads and stores are the slots a subroutine or its callees may load and store
+	loads  map[string]slotSet
+	stores map[string]slotSet
+
+	// must are the slots a subroutine and its callees store on every path to retsub
+	must map[string]slotSet
+
+	errs lintErrors
+}
+
+// ends returns whether the program ends after the line
+func (c *scratchChecker) ends(i int) bool {
+	switch c.l.l[i].(type) {
+	case *ReturnExpr:
+		return true
+	case Terminator, *BExpr:
+		return false
+	}
+
+	return i == len(c.l.l)-1
+}
+
+// constIndex returns the uint64 constant that is depth values below the top of the stack before the line,
+// it is only known if the constant is pushed in the same block
+func (c *scratchChecker) constIndex(i int, depth int) (uint64, bool) {
+	for j := i - 1; j >= 0; j-- {
+		op := c.l.l[j]
+
+		switch op.(type) {
+		case *LabelExpr:
+			return 0, false
+		case Nop:
+			continue
+		}
+
+		args, rets, ok := stackEffect(op)
+		if !ok {
+			return 0, false
+		}
+
+		if depth < len(rets) {
+			switch op := op.(type) {
+			case *IntExpr:
+				return op.Value, true
+			case *PushIntExpr:
+				return op.Value, true
+			case *PushIntsExpr:
+				return op.Ints[len(op.Ints)-1-depth], true
+			}
+
+			return 0, false
+		}
+
+		depth += len(args) - len(rets)
+	}
+
+	return 0, false
+}
+
+// stored returns the slot stored by the line and whether the line stores a slot at all,
+// known is not set for a stores whose index is not a constant
+func (c *scratchChecker) stored(i int) (slot uint8, known bool, ok bool) {
+	switch op := c.l.l[i].(type) {
+	case *StoreExpr:
+		return op.Index, true, true
+	case *StoresExpr:
+		v, known := c.constIndex(i, 1)
+		if known && v < 256 {
+			return uint8(v), true, true
+		}
+		return 0, false, true
+	}
+
+	return 0, false, false
+}
+
+// forward computes the slots stored before every line of the routine, on every path if must is set or on any path otherwise,
+// callees add the slots they store on every path if own is not set
+func (c *scratchChecker) forward(r *routine, init slotSet, must bool, own bool) (map[int]slotSet, slotSet) {
+	in := map[int]slotSet{}
+	out := map[int]slotSet{}
+
+	for _, i := range r.lines {
+		if must {
+			out[i] = allSlots
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, i := range r.lines {
+			s := slotSet{}
+			if must {
+				s = allSlots
+			}
+
+			if i == r.entry {
+				s = init
+			}
+
+			for _, p := range r.preds[i] {
+				if must {
+					s = s.and(out[p])
+				} else {
+					s = s.or(out[p])
+				}
+			}
+
+			in[i] = s
+
+			if slot, known, ok := c.stored(i); ok {
+				switch {
+				case known:
+					s.add(slot)
+				case !must:
+					s = allSlots
+				}
+			}
+
+			if op, ok := c.l.l[i].(*CallSubExpr); ok && !own {
+				s = s.or(c.must[op.Label.Name])
+			}
+
+			if s != out[i] {
+				out[i] = s
+				changed = true
+			}
+		}
+	}
+
+	exit := allSlots
+	for _, i := range r.lines {
+		if _, ok := c.l.l[i].(*RetSubExpr); ok {
+			exit = exit.and(in[i])
+		}
+	}
+
+	return in, exit
+}
+
+// backward computes the slots that may be read after every line of the routine, ret are the slots live after retsub
+func (c *scratchChecker) backward(r *routine, ret slotSet) map[int]slotSet {
+	in := map[int]slotSet{}
+	out := map[int]slotSet{}
+
+	for changed := true; changed; {
+		changed = false
+
+		for j := len(r.lines) - 1; j >= 0; j-- {
+			i := r.lines[j]
+
+			s := slotSet{}
+			for _, n := range c.l.next(i) {
+				s = s.or(in[n])
+			}
+
+			// the scratch of an approved transaction can be read by the later transactions of the group
+			if c.ends(i) {
+				s = allSlots
+			}
+
+			if _, ok := c.l.l[i].(*RetSubExpr); ok {
+				s = ret
+			}
+
+			out[i] = s
+
+			if slot, known, ok := c.stored(i); ok && known {
+				s.remove(slot)
+			}
+
+			switch op := c.l.l[i].(type) {
+			case *LoadExpr:
+				s.add(op.Index)
+			case *LoadsExpr:
+				s = allSlots
+			case *CallSubExpr:
+				s = s.without(c.must[op.Label.Name]).or(c.loads[op.Label.Name])
+			}
+
+			if s != in[i] {
+				in[i] = s
+				changed = true
+			}
+		}
+	}
+
+	return out
+}
+
+// summarize computes the slots the subroutines may load and store and the slots they always store
+func (c *scratchChecker) summarize() {
+	for changed := true; changed; {
+		changed = false
+
+		for _, name := range c.names {
+			var loads, stores slotSet
+
+			for _, i := range c.subs[name].lines {
+				if slot, known, ok := c.stored(i); ok {
+					if known {
+						stores.add(slot)
+					} else {
+						stores = allSlots
+					}
+				}
+
+				switch op := c.l.l[i].(type) {
+				case *LoadExpr:
+					loads.add(op.Index)
+				case *LoadsExpr:
+					loads = allSlots
+				case *CallSubExpr:
+					loads = loads.or(c.loads[op.Label.Name])
+					stores = stores.or(c.stores[op.Label.Name])
+				}
+			}
+
+			if loads != c.loads[name] || stores != c.stores[name] {
+				c.loads[name], c.stores[name] = loads, stores
+				changed = true
+			}
+		}
+	}
+
+	for _, name := range c.names {
+		c.must[name] = allSlots
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, name := range c.names {
+			_, exit := c.forward(c.subs[name], slotSet{}, true, false)
+			if exit != c.must[name] {
+				c.must[name] = exit
+				changed = true
+			}
+		}
+	}
+}
+
+// routines returns the main program and the subroutines
+func (c *scratchChecker) routines() []*routine {
+	rs := []*routine{c.main}
+	for _, name := range c.names {
+		rs = append(rs, c.subs[name])
+	}
+
+	return rs
+}
+
+// checkLoads reports the loads of slots that are not stored on every path, a subroutine starts with the slots
+// stored at all of its calls
+func (c *scratchChecker) checkLoads() {
+	entries := map[string]slotSet{}
+	for _, name := range c.names {
+		entries[name] = allSlots
+	}
+
+	var ins []map[int]slotSet
+
+	for changed := true; changed; {
+		changed = false
+
+		ins = nil
+		calls := map[string]slotSet{}
+		for _, name := range c.names {
+			calls[name] = allSlots
+		}
+
+		for k, r := range c.routines() {
+			init := slotSet{}
+			if k > 0 {
+				init = entries[c.names[k-1]]
+			}
+
+			in, _ := c.forward(r, init, true, false)
+			ins = append(ins, in)
+
+			for _, i := range r.lines {
+				if op, ok := c.l.l[i].(*CallSubExpr); ok {
+					calls[op.Label.Name] = calls[op.Label.Name].and(in[i])
+				}
+			}
+		}
+
+		for _, name := range c.names {
+			if calls[name] != entries[name] {
+				entries[name] = calls[name]
+				changed = true
+			}
+		}
+	}
+
+	for k, r := range c.routines() {
+		for _, i := range r.lines {
+			if op, ok := c.l.l[i].(*LoadExpr); ok && !ins[k][i].has(op.Index) {
+				c.errs.add(UninitializedSlotError{l: i, slot: op.Index})
+			}
+		}
+	}
+}
+
+// checkStores reports the dead stores and the slots of the caller overwritten by the called subroutines
+func (c *scratchChecker) checkStores() {
+	rets := map[string]slotSet{}
+
+	var outs []map[int]slotSet
+
+	for changed := true; changed; {
+		changed = false
+
+		outs = nil
+		calls := map[string]slotSet{}
+
+		for k, r := range c.routines() {
+			var ret slotSet
+			if k > 0 {
+				ret = rets[c.names[k-1]]
+			}
+
+			out := c.backward(r, ret)
+			outs = append(outs, out)
+
+			for _, i := range r.lines {
+				if op, ok := c.l.l[i].(*CallSubExpr); ok {
+					calls[op.Label.Name] = calls[op.Label.Name].or(out[i])
+				}
+			}
+		}
+
+		for _, name := range c.names {
+			if calls[name] != rets[name] {
+				rets[name] = calls[name]
+				changed = true
+			}
+		}
+	}
+
+	for k, r := range c.routines() {
+		own, _ := c.forward(r, slotSet{}, false, true)
+
+		for _, i := range r.lines {
+			if slot, known, _ := c.stored(i); known && !outs[k][i].has(slot) {
+				c.errs.add(DeadStoreError{l: i, slot: slot})
+			}
+
+			switch op := c.l.l[i].(type) {
+			case *CallSubExpr:
+				used := own[i].and(outs[k][i]).and(c.stores[op.Label.Name])
+				for _, slot := range used.slots() {
+					c.errs.add(SlotCollisionError{l: i, slot: slot, name: op.Label.Name})
+				}
+			}
+		}
+	}
+}
+
+// checkTypes reports the slots stored with both uint64 and bytes values
+func (c *scratchChecker) checkTypes() {
+	stores := map[int]StackType{}
+	slots := map[int]uint8{}
+	for i, s := range c.l.stackStates() {
+		if slot, known, _ := c.stored(i); known && !s.unknown && len(s.types) > 0 {
+			stores[i] = s.top(0)
+			slots[i] = slot
+		}
+	}
+
+	types := map[uint8]map[StackType]bool{}
+	for i, t := range stores {
+		slot := slots[i]
+		if types[slot] == nil {
+			types[slot] = map[StackType]bool{}
+		}
+		types[slot][t] = true
+	}
+
+	for i := range stores {
+		slot := slots[i]
+		if types[slot][StackUint64] && types[slot][StackBytes] {
+			c.errs.add(MixedSlotTypesError{l: i, slot: slot})
+		}
+	}
+}
+
+// checkScratch tracks the scratch slots stored and read along the paths of the program
+func (l *Linter) checkScratch() {
+	if len(l.l) == 0 {
+		return
+	}
+
+	c := &scratchChecker{
+		l:      l,
+		main:   l.routine(0),
+		subs:   map[string]*routine{},
+		loads:  map[string]slotSet{},
+		stores: map[string]slotSet{},
+		must:   map[string]slotSet{},
+		errs:   lintErrors{},
+	}
+
+	for name, line := range l.subroutines() {
+		c.names = append(c.names, name)
+		c.subs[name] = l.routine(line)
+	}
+	sort.Strings(c.names)
+
+	c.summarize()
+	c.checkLoads()
+	c.checkStores()
+	c.checkTypes()
+
+	l.report(c.errs)
+}
@@ -0,0 +1,684 @@
+package teal
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RuleError is a LineError reported under its own rule id rather than the generic lint rule
+type RuleError interface {
+	LineError
+	Rule() string
+}
+
+// MissingCheckError is an approving path that does not perform a required security check
+type MissingCheckError struct {
+	l     int
+	rule  string
+	check string
+}
+
+func (e MissingCheckError) Line() int {
+	return e.l
+}
+
+func (e MissingCheckError) Rule() string {
+	return e.rule
+}
+
+func (e MissingCheckError) Error() string {
+	return fmt.Sprintf("approving path does not check %s", e.check)
+}
+
+func (e MissingCheckError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+// InnerFeeError is an inner transaction submitted without setting its Fee to 0
+type InnerFeeError struct {
+	l int
+}
+
+func (e InnerFeeError) Line() int {
+	return e.l
+}
+
+func (e InnerFeeError) Rule() string {
+	return "APP_INNER_FEE"
+}
+
+func (e InnerFeeError) Error() string {
+	return "inner transaction does not set Fee to 0"
+}
+
+func (e InnerFeeError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+// guardFacts are the checks known to have passed on a path
+type guardFacts uint64
+
+const (
+	guardRekey guardFacts = 1 << iota
+	guardCloseRemainder
+	guardAssetClose
+	guardFee
+	guardGroupSize
+	guardSender
+	guardInnerFee
+
+	// guardOnCompletion is the first of the bits that exclude an OnCompletion value each
+	guardOnCompletion
+
+	guardOnCompletionAll = guardOnCompletion * (1<<(DeleteApplication+1) - 1)
+	guardAll             = ^guardFacts(0)
+)
+
+// guardNotOnCompletion is the fact that txn OnCompletion is not the value
+func guardNotOnCompletion(oc OnCompletionConstType) guardFacts {
+	return guardOnCompletion << oc
+}
+
+// guardRequirement is met by a path with any of the fact bits or with all of the only bits
+type guardRequirement struct {
+	fact  guardFacts
+	only  guardFacts
+	rule  string
+	check string
+}
+
+func (r guardRequirement) met(facts guardFacts) bool {
+	return facts&r.fact != 0 || r.only != 0 && facts&r.only == r.only
+}
+
+var sigRequirements = []guardRequirement{
+	{fact: guardRekey, rule: "LSIG_REKEY", check: "txn RekeyTo == global ZeroAddress"},
+	{fact: guardCloseRemainder, rule: "LSIG_CLOSE", check: "txn CloseRemainderTo == global ZeroAddress"},
+	{fact: guardAssetClose, rule: "LSIG_CLOSE", check: "txn AssetCloseTo == global ZeroAddress"},
+	{fact: guardFee, rule: "LSIG_FEE", check: "an upper bound of txn Fee"},
+	{fact: guardGroupSize, rule: "LSIG_GROUP_SIZE", check: "an upper bound of global GroupSize"},
+}
+
+// OptIn and CloseOut are allowed explicitly if the path leaves them as the only OnCompletion value
+var appRequirements = []guardRequirement{
+	{fact: guardSender | guardNotOnCompletion(UpdateApplication), rule: "APP_UPDATE", check: "txn Sender before allowing UpdateApplication"},
+	{fact: guardSender | guardNotOnCompletion(DeleteApplication), rule: "APP_DELETE", check: "txn Sender before allowing DeleteApplication"},
+	{fact: guardNotOnCompletion(OptIn), only: guardOnCompletionAll &^ guardNotOnCompletion(OptIn), rule: "APP_ON_COMPLETION", check: "txn OnCompletion before allowing OptIn"},
+	{fact: guardNotOnCompletion(CloseOut), only: guardOnCompletionAll &^ guardNotOnCompletion(CloseOut), rule: "APP_ON_COMPLETION", check: "txn OnCompletion before allowing CloseOut"},
+}
+
+// guardValue is a stack value, t and f are the facts that hold if the value is non-zero or zero respectively,
+// admin values come from the application state
+type guardValue struct {
+	op    Op
+	known bool
+	value uint64
+	bytes bool
+	admin bool
+	t     guardFacts
+	f     guardFacts
+}
+
+func (v guardValue) txn(f TxnField) bool {
+	o, ok := v.op.(*TxnExpr)
+	return ok && o.Field == f
+}
+
+func (v guardValue) global(f GlobalField) bool {
+	o, ok := v.op.(*GlobalExpr)
+	return ok && o.Field == f
+}
+
+// subject returns whether the value is a field checked by the comparisons
+func (v guardValue) subject() bool {
+	_, ok := v.op.(*TxnExpr)
+	return ok || v.global(GroupSize)
+}
+
+// rejects returns whether the value fails the program if it is the final value on the stack
+func (v guardValue) rejects() bool {
+	return v.bytes || v.known && v.value == 0
+}
+
+// guardState are the facts of a path and its stack, an open stack has unknown values below its bottom
+type guardState struct {
+	facts guardFacts
+	open  bool
+	stack []guardValue
+}
+
+func (s *guardState) clone() *guardState {
+	ns := *s
+	ns.stack = append([]guardValue{}, s.stack...)
+	return &ns
+}
+
+func (s *guardState) pop() guardValue {
+	if len(s.stack) == 0 {
+		return guardValue{}
+	}
+
+	v := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+
+	return v
+}
+
+func (s *guardState) top(depth int) guardValue {
+	if depth >= len(s.stack) {
+		return guardValue{}
+	}
+
+	return s.stack[len(s.stack)-1-depth]
+}
+
+func (s *guardState) push(vs ...guardValue) {
+	s.stack = append(s.stack, vs...)
+}
+
+// forget makes the whole stack unknown
+func (s *guardState) forget() {
+	s.open = true
+	s.stack = nil
+}
+
+func (s *guardState) equal(o *guardState) bool {
+	if s.facts != o.facts || s.open != o.open || len(s.stack) != len(o.stack) {
+		return false
+	}
+
+	for i := range s.stack {
+		if s.stack[i] != o.stack[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// joinGuards keeps the facts of both paths and the stack values they agree on
+func joinGuards(a *guardState, b *guardState) *guardState {
+	res := &guardState{
+		facts: a.facts & b.facts,
+		open:  a.open || b.open,
+	}
+
+	if len(a.stack) != len(b.stack) {
+		res.forget()
+		return res
+	}
+
+	res.stack = make([]guardValue, len(a.stack))
+	for i := range a.stack {
+		if a.stack[i] == b.stack[i] {
+			res.stack[i] = a.stack[i]
+		}
+	}
+
+	return res
+}
+
+// guardCompare returns the facts that hold if the comparison is true or false
+func guardCompare(op Op, a guardValue, b guardValue) (guardFacts, guardFacts) {
+	if !a.subject() && b.subject() {
+		a, b = b, a
+
+		switch op.(type) {
+		case *LtExpr:
+			op = Gt
+		case *GtExpr:
+			op = Lt
+		case *LtEqExpr:
+			op = Ge
+		case *GtEqExpr:
+			op = Le
+		}
+	}
+
+	if a.txn(OnCompletion) && b.known {
+		return guardOnCompletionFacts(op, b.value)
+	}
+
+	var eq guardFacts
+	var upper guardFacts
+
+	switch {
+	case a.txn(Sender) && (b.global(CreatorAddress) || b.admin):
+		eq = guardSender
+	case a.txn(RekeyTo) && b.global(ZeroAddress):
+		eq = guardRekey
+	case a.txn(CloseRemainderTo) && b.global(ZeroAddress):
+		eq = guardCloseRemainder
+	case a.txn(AssetCloseTo) && b.global(ZeroAddress):
+		eq = guardAssetClose
+	case a.txn(TypeEnum) && b.known:
+		if b.value != txnTypeMap["pay"] {
+			eq |= guardCloseRemainder
+		}
+		if b.value != txnTypeMap["axfer"] {
+			eq |= guardAssetClose
+		}
+	case a.txn(Fee):
+		eq, upper = guardFee, guardFee
+	case a.global(GroupSize):
+		eq, upper = guardGroupSize, guardGroupSize
+	}
+
+	switch op.(type) {
+	case *EqExpr:
+		return eq, 0
+	case *NeqExpr:
+		return 0, eq
+	case *LtExpr, *LtEqExpr:
+		return upper, 0
+	case *GtExpr, *GtEqExpr:
+		return 0, upper
+	}
+
+	return 0, 0
+}
+
+// guardOnCompletionFacts returns the OnCompletion values excluded if the comparison with the constant is true or false
+func guardOnCompletionFacts(op Op, k uint64) (guardFacts, guardFacts) {
+	var t, f guardFacts
+
+	for oc := NoOp; oc <= DeleteApplication; oc++ {
+		var res bool
+
+		switch op.(type) {
+		case *EqExpr:
+			res = uint64(oc) == k
+		case *NeqExpr:
+			res = uint64(oc) != k
+		case *LtExpr:
+			res = uint64(oc) < k
+		case *LtEqExpr:
+			res = uint64(oc) <= k
+		case *GtExpr:
+			res = uint64(oc) > k
+		case *GtEqExpr:
+			res = uint64(oc) >= k
+		default:
+			return 0, 0
+		}
+
+		if res {
+			f |= guardNotOnCompletion(oc)
+		} else {
+			t |= guardNotOnCompletion(oc)
+		}
+	}
+
+	return t, f
+}
+
+type guardChecker struct {
+	l *Linter
+
+	intc   []uint64
+	labels map[string]int
+	end    int
+
+	entries map[string]guardFacts
+	exits   map[string]guardFacts
+	calls   map[string]guardFacts
+
+	required []guardRequirement
+	fees     bool
+	report   bool
+	errs     lintErrors
+}
+
+func (c *guardChecker) constant(v uint64) guardValue {
+	return guardValue{known: true, value: v}
+}
+
+func (c *guardChecker) intcValue(i int) guardValue {
+	if i < len(c.intc) {
+		return c.constant(c.intc[i])
+	}
+
+	return guardValue{}
+}
+
+// approve checks the requirements on a path that ends the program with the value on top of the stack
+func (c *guardChecker) approve(i int, facts guardFacts, v guardValue) {
+	if !c.report || v.rejects() {
+		return
+	}
+
+	facts |= v.t
+
+	for _, r := range c.required {
+		if !r.met(facts) {
+			c.errs.add(MissingCheckError{l: i, rule: r.rule, check: r.check})
+		}
+	}
+}
+
+// result returns the unknown value pushed by the op
+func (c *guardChecker) result(op Op) guardValue {
+	_, rets, _ := stackEffect(op)
+	return guardValue{bytes: len(rets) == 1 && rets[0] == StackBytes}
+}
+
+// submit checks the Fee of the inner transaction ended at the line
+func (c *guardChecker) submit(i int, s *guardState) {
+	if c.fees && c.report && s.facts&guardInnerFee == 0 {
+		c.errs.add(InnerFeeError{l: i})
+	}
+
+	s.facts &^= guardInnerFee
+}
+
+// apply runs the op on the state and returns the facts added to the branch targets and to the fallthrough
+func (c *guardChecker) apply(i int, s *guardState) (map[int]guardFacts, guardFacts) {
+	targets := map[int]guardFacts{}
+	var fall guardFacts
+
+	edge := func(lbl *LabelExpr, t guardFacts) {
+		ln, ok := c.labels[lbl.Name]
+		if !ok {
+			return
+		}
+
+		if prev, ok := targets[ln]; ok {
+			t &= prev
+		}
+		targets[ln] = t
+	}
+
+	switch op := c.l.l[i].(type) {
+	case Nop, *ProtoExpr, *IntcBlockExpr, *BytecBlockExpr:
+	case *IntExpr:
+		s.push(c.constant(op.Value))
+	case *PushIntExpr:
+		s.push(c.constant(op.Value))
+	case *IntcExpr:
+		s.push(c.intcValue(int(op.Index)))
+	case *Intc0Expr:
+		s.push(c.intcValue(0))
+	case *Intc1Expr:
+		s.push(c.intcValue(1))
+	case *Intc2Expr:
+		s.push(c.intcValue(2))
+	case *Intc3Expr:
+		s.push(c.intcValue(3))
+	case *TxnExpr, *GlobalExpr:
+		v := c.result(op)
+		v.op = op
+		v.t, v.f = guardCompare(Neq, v, c.constant(0))
+		s.push(v)
+	case *AppGlobalGetExpr:
+		s.pop()
+		s.push(guardValue{admin: true})
+	case *AppGlobalGetExExpr:
+		s.pop()
+		s.pop()
+		s.push(guardValue{admin: true}, guardValue{})
+	case *ItxnBeginExpr:
+		s.facts &^= guardInnerFee
+	case *ItxnNextExpr, *ItxnSubmitExpr:
+		c.submit(i, s)
+	case *ItxnFieldExpr:
+		v := s.pop()
+		if op.Field == Fee {
+			if v.known && v.value == 0 {
+				s.facts |= guardInnerFee
+			} else {
+				s.facts &^= guardInnerFee
+			}
+		}
+	case *EqExpr, *NeqExpr, *LtExpr, *GtExpr, *LtEqExpr, *GtEqExpr:
+		b := s.pop()
+		a := s.pop()
+		t, f := guardCompare(op, a, b)
+		s.push(guardValue{t: t, f: f})
+	case *NotExpr:
+		v := s.pop()
+		nv := guardValue{t: v.f, f: v.t}
+		if v.known {
+			nv.known = true
+			if v.value == 0 {
+				nv.value = 1
+			}
+		}
+		s.push(nv)
+	case *AndExpr:
+		b := s.pop()
+		a := s.pop()
+		s.push(guardValue{t: a.t | b.t, f: a.f & b.f})
+	case *OrExpr:
+		b := s.pop()
+		a := s.pop()
+		s.push(guardValue{t: a.t & b.t, f: a.f | b.f})
+	case *DupExpr:
+		s.push(s.top(0))
+	case *Dup2Expr:
+		s.push(s.top(1), s.top(0))
+	case *DigExpr:
+		s.push(s.top(int(op.Index)))
+	case *SwapExpr:
+		b := s.pop()
+		a := s.pop()
+		s.push(b, a)
+	case *AssertExpr:
+		s.facts |= s.pop().t
+	case *BExpr:
+		edge(op.Label, 0)
+	case *BnzExpr:
+		v := s.pop()
+		edge(op.Label, v.t)
+		fall = v.f
+	case *BzExpr:
+		v := s.pop()
+		edge(op.Label, v.f)
+		fall = v.t
+	case *SwitchExpr:
+		v := s.pop()
+		fall = guardAll
+		for k, t := range op.Targets {
+			eq, ne := guardCompare(Eq, v, c.constant(uint64(k)))
+			edge(t, eq)
+			fall &= ne
+		}
+	case *MatchExpr:
+		v := s.pop()
+		cs := make([]guardValue, len(op.Targets))
+		for k := len(cs) - 1; k >= 0; k-- {
+			cs[k] = s.pop()
+		}
+		fall = guardAll
+		for k, t := range op.Targets {
+			eq, ne := guardCompare(Eq, v, cs[k])
+			edge(t, eq)
+			fall &= ne
+		}
+	case *ReturnExpr:
+		c.approve(i, s.facts, s.pop())
+	case *CallSubExpr:
+		name := op.Label.Name
+		c.calls[name] &= s.facts
+
+		if ln, ok := c.labels[name]; ok {
+			s.facts |= c.exits[name]
+
+			if p, ok := c.l.proto(ln); ok {
+				for j := 0; j < int(p.Args); j++ {
+					s.pop()
+				}
+				s.push(make([]guardValue, p.Results)...)
+				break
+			}
+		}
+
+		s.forget()
+	default:
+		args, rets, ok := stackEffect(op)
+		if !ok {
+			s.forget()
+			break
+		}
+
+		for range args {
+			s.pop()
+		}
+		for _, t := range rets {
+			s.push(guardValue{bytes: t == StackBytes})
+		}
+	}
+
+	return targets, fall
+}
+
+// run follows the paths from the entry line and returns the facts that hold at every retsub
+func (c *guardChecker) run(entry int, init *guardState) guardFacts {
+	in := make([]*guardState, len(c.l.l))
+	in[entry] = init
+
+	work := []int{entry}
+	queued := map[int]bool{entry: true}
+
+	exit := guardAll
+
+	for len(work) > 0 {
+		i := work[0]
+		work = work[1:]
+		queued[i] = false
+
+		s := in[i].clone()
+		targets, fall := c.apply(i, s)
+
+		switch c.l.l[i].(type) {
+		case *RetSubExpr:
+			exit &= s.facts
+			continue
+		case Terminator, *BExpr:
+		default:
+			if i == len(c.l.l)-1 {
+				c.approve(c.end, s.facts|fall, s.top(0))
+			}
+		}
+
+		for _, n := range c.l.next(i) {
+			ns := s.clone()
+
+			facts, ok := targets[n]
+			if n == i+1 {
+				if ok {
+					facts &= fall
+				} else {
+					facts = fall
+				}
+			}
+			ns.facts |= facts
+
+			if prev := in[n]; prev != nil {
+				ns = joinGuards(prev, ns)
+				if ns.equal(prev) {
+					continue
+				}
+			}
+
+			in[n] = ns
+
+			if !queued[n] {
+				queued[n] = true
+				work = append(work, n)
+			}
+		}
+	}
+
+	return exit
+}
+
+// round runs the main program and the subroutines, it returns whether the facts of any subroutine changed
+func (c *guardChecker) round(names []string) bool {
+	c.calls = map[string]guardFacts{}
+	for _, name := range names {
+		c.calls[name] = guardAll
+	}
+
+	if len(c.l.l) > 0 {
+		c.run(0, &guardState{})
+	}
+
+	changed := false
+
+	for _, name := range names {
+		exit := c.run(c.labels[name], &guardState{facts: c.entries[name], open: true})
+		if exit != c.exits[name] {
+			c.exits[name] = exit
+			changed = true
+		}
+	}
+
+	for _, name := range names {
+		if c.calls[name] != c.entries[name] {
+			c.entries[name] = c.calls[name]
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// checkGuards proves the required checks happen on every path that approves the program,
+// fees enables the checks of the inner transaction fees
+func (l *Linter) checkGuards(required []guardRequirement, fees bool) {
+	c := &guardChecker{
+		l:        l,
+		labels:   l.labelLines(),
+		entries:  map[string]guardFacts{},
+		exits:    map[string]guardFacts{},
+		required: required,
+		fees:     fees,
+		errs:     lintErrors{},
+	}
+
+	for _, o := range l.l {
+		if o, ok := o.(*IntcBlockExpr); ok {
+			c.intc = o.Values
+			break
+		}
+	}
+
+	// the program end is reported at the last line that is not empty or a comment
+	for c.end = len(l.l) - 1; c.end > 0; c.end-- {
+		switch l.l[c.end].(type) {
+		case *EmptyExpr, *CommentExpr:
+			continue
+		}
+		break
+	}
+
+	subs := l.subroutines()
+
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+		c.entries[name] = guardAll
+		c.exits[name] = guardAll
+	}
+	sort.Strings(names)
+
+	for n := 0; n < 64*(len(names)+1) && c.round(names); n++ {
+	}
+
+	c.report = true
+	c.round(names)
+
+	l.report(c.errs)
+}
+
+// checkSecurity runs the security rules of the program mode
+func (l *Linter) checkSecurity() {
+	switch l.mode {
+	case ModeSig:
+		l.checkGuards(sigRequirements, false)
+	case ModeApp:
+		if l.app {
+			l.checkGuards(appRequirements, true)
+		}
+	}
+}
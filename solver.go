@@ -0,0 +1,795 @@
+package teal
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
+)
+
+const maxSolverCandidates = 64
+
+// VmInput is a value that comes from outside of the program - a field of a transaction of the group or a logicsig arg
+type VmInput struct {
+	Arg        bool
+	GroupIndex uint64
+	Field      TxnField
+	Array      bool
+	Index      uint64
+}
+
+func (in VmInput) String() string {
+	switch {
+	case in.Arg:
+		return fmt.Sprintf("arg %d", in.Index)
+	case in.Array:
+		return fmt.Sprintf("gtxna %d %s %d", in.GroupIndex, in.Field, in.Index)
+	default:
+		return fmt.Sprintf("gtxn %d %s", in.GroupIndex, in.Field)
+	}
+}
+
+// VmConstraint is a condition the branch took on its path
+type VmConstraint struct {
+	A  VmValue
+	Op string
+	B  VmValue
+}
+
+func (c VmConstraint) String() string {
+	return fmt.Sprintf("%s %s %s", c.A, c.Op, c.B)
+}
+
+// VmFailure is an assert that fails if the branch inputs meet the constraints
+type VmFailure struct {
+	Branch      int
+	Line        int
+	Constraints []VmConstraint
+}
+
+// Solve returns the inputs that fail the assert
+func (f VmFailure) Solve() (VmInputs, error) {
+	return solveConstraints(f.Constraints)
+}
+
+// Solve returns the inputs that lead the program along the path of the branch,
+// the inputs of an undecided branch also make the program pass if possible
+func (b *VmBranch) Solve() (VmInputs, error) {
+	if b.Outcome == VmUndecided && b.Result.T == VmTypeUint64 {
+		cs := append(append([]VmConstraint{}, b.Constraints...), VmConstraint{A: b.Result, Op: "!=", B: Uint64Value(0)})
+		if ins, err := solveConstraints(cs); err == nil {
+			return ins, nil
+		}
+	}
+
+	return solveConstraints(b.Constraints)
+}
+
+// assume adds the constraint to the branch unless both of the values are known
+func (b *VmBranch) assume(a VmValue, op string, c VmValue) {
+	if b.vm.Concrete || a.Known() && c.Known() {
+		return
+	}
+
+	nc := VmConstraint{A: a, Op: op, B: c}
+	s := nc.String()
+
+	for _, o := range b.Constraints {
+		if o.String() == s {
+			return
+		}
+	}
+
+	b.Constraints = append(b.Constraints, nc)
+}
+
+// txnFieldLengths are the lengths of the fixed size byte array fields
+var txnFieldLengths = map[TxnField]uint64{
+	Sender:                  32,
+	Lease:                   32,
+	Receiver:                32,
+	CloseRemainderTo:        32,
+	VotePK:                  32,
+	SelectionPK:             32,
+	AssetSender:             32,
+	AssetReceiver:           32,
+	AssetCloseTo:            32,
+	TxID:                    32,
+	Accounts:                32,
+	RekeyTo:                 32,
+	ConfigAssetMetadataHash: 32,
+	ConfigAssetManager:      32,
+	ConfigAssetReserve:      32,
+	ConfigAssetFreeze:       32,
+	ConfigAssetClawback:     32,
+	FreezeAssetAccount:      32,
+	StateProofPK:            64,
+}
+
+const (
+	vmTermValue = iota
+	vmTermBtoi
+	vmTermLen
+)
+
+// vmTerm is an input or a uint64 derived from a byte array input
+type vmTerm struct {
+	in   VmInput
+	kind int
+	t    VmDataType
+}
+
+// vmAtom compares a term to a known value
+type vmAtom struct {
+	term vmTerm
+	op   string
+	c    VmValue
+}
+
+var vmMirroredOps = map[string]string{
+	"==": "==",
+	"!=": "!=",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+var vmNegatedOps = map[string]string{
+	"==": "!=",
+	"!=": "==",
+	"<":  ">=",
+	"<=": ">",
+	">":  "<=",
+	">=": "<",
+}
+
+// comparisonOp returns the comparison of the op if it is one
+func comparisonOp(e Op) (string, bool) {
+	switch e.(type) {
+	case *EqExpr:
+		return "==", true
+	case *NeqExpr:
+		return "!=", true
+	case *LtExpr:
+		return "<", true
+	case *LtEqExpr:
+		return "<=", true
+	case *GtExpr:
+		return ">", true
+	case *GtEqExpr:
+		return ">=", true
+	default:
+		return "", false
+	}
+}
+
+func compareUint64(a uint64, op string, b uint64) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	default:
+		return false
+	}
+}
+
+// decided tells whether all or none of the values within the bounds meet the comparison
+func decided(lo uint64, hi uint64, op string, c uint64) (bool, bool) {
+	switch op {
+	case "==":
+		return lo == c && hi == c, c < lo || c > hi
+	case "!=":
+		return c < lo || c > hi, lo == c && hi == c
+	case "<":
+		return hi < c, lo >= c
+	case "<=":
+		return hi <= c, lo > c
+	case ">":
+		return lo > c, hi <= c
+	case ">=":
+		return lo >= c, hi < c
+	default:
+		return false, false
+	}
+}
+
+func conjunction(as [][]vmAtom, bs [][]vmAtom) [][]vmAtom {
+	var res [][]vmAtom
+	for _, a := range as {
+		for _, b := range bs {
+			res = append(res, append(append([]vmAtom{}, a...), b...))
+		}
+	}
+
+	return res
+}
+
+// normalize rewrites the comparison to alternatives of atom conjunctions
+func normalize(a VmValue, op string, b VmValue) ([][]vmAtom, error) {
+	if a.Known() && !b.Known() {
+		a, b = b, a
+		op = vmMirroredOps[op]
+	}
+
+	if !b.Known() {
+		return nil, errors.Errorf("unsupported constraint: %s %s %s", a, op, b)
+	}
+
+	if a.Known() {
+		if a.T == b.T && compareKnown(a, op, b) {
+			return [][]vmAtom{{}}, nil
+		}
+		return nil, nil
+	}
+
+	if b.T == VmTypeUint64 {
+		lo, hi := a.bounds()
+		all, none := decided(lo, hi, op, b.u())
+		switch {
+		case all:
+			return [][]vmAtom{{}}, nil
+		case none:
+			return nil, nil
+		}
+	}
+
+	switch src := a.src.(type) {
+	case VmInput:
+		return [][]vmAtom{{{term: vmTerm{in: src, t: a.T}, op: op, c: b}}}, nil
+	case vmRangeSource:
+		v := VmValue{T: a.T, src: src.src}
+
+		res, err := normalize(v, op, b)
+		if err != nil {
+			return nil, err
+		}
+
+		lo, err := normalize(v, ">=", Uint64Value(src.min))
+		if err != nil {
+			return nil, err
+		}
+
+		hi, err := normalize(v, "<=", Uint64Value(src.max))
+		if err != nil {
+			return nil, err
+		}
+
+		return conjunction(conjunction(res, lo), hi), nil
+	case vmOpSource:
+		return normalizeOp(a, src, op, b)
+	default:
+		return nil, errors.Errorf("unsupported constraint: %s %s %s", a, op, b)
+	}
+}
+
+func compareKnown(a VmValue, op string, b VmValue) bool {
+	if a.T == VmTypeBytes {
+		switch op {
+		case "==":
+			return bytes.Equal(a.b(), b.b())
+		case "!=":
+			return !bytes.Equal(a.b(), b.b())
+		default:
+			return false
+		}
+	}
+
+	return compareUint64(a.u(), op, b.u())
+}
+
+// normalizeOp rewrites the comparison of an op result - a boolean op or a uint64 derived from a byte array input
+func normalizeOp(a VmValue, src vmOpSource, op string, b VmValue) ([][]vmAtom, error) {
+	args := make([]VmValue, len(src.args))
+	for i, arg := range src.args {
+		v, ok := arg.(VmValue)
+		if !ok {
+			return nil, errors.Errorf("unsupported constraint: %s %s %s", a, op, b)
+		}
+		args[i] = v
+	}
+
+	if b.T != VmTypeUint64 {
+		return nil, errors.Errorf("unsupported constraint: %s %s %s", a, op, b)
+	}
+
+	switch src.e.(type) {
+	case *BtoiExpr, *LenExpr:
+		in, ok := args[0].src.(VmInput)
+		if !ok {
+			return nil, errors.Errorf("unsupported constraint: %s %s %s", a, op, b)
+		}
+
+		kind := vmTermBtoi
+		if _, ok := src.e.(*LenExpr); ok {
+			kind = vmTermLen
+		}
+
+		return [][]vmAtom{{{term: vmTerm{in: in, kind: kind, t: VmTypeBytes}, op: op, c: b}}}, nil
+	case *EqExpr, *NeqExpr, *LtExpr, *LtEqExpr, *GtExpr, *GtEqExpr, *NotExpr, *AndExpr, *OrExpr:
+	default:
+		return nil, errors.Errorf("unsupported constraint: %s %s %s", a, op, b)
+	}
+
+	t := compareUint64(1, op, b.u())
+	f := compareUint64(0, op, b.u())
+
+	switch {
+	case t && f:
+		return [][]vmAtom{{}}, nil
+	case !t && !f:
+		return nil, nil
+	}
+
+	if cop, ok := comparisonOp(src.e); ok {
+		if !t {
+			cop = vmNegatedOps[cop]
+		}
+		return normalize(args[0], cop, args[1])
+	}
+
+	zero := Uint64Value(0)
+
+	if _, ok := src.e.(*NotExpr); ok {
+		if t {
+			return normalize(args[0], "==", zero)
+		}
+		return normalize(args[0], "!=", zero)
+	}
+
+	cop := "!="
+	if !t {
+		cop = "=="
+	}
+
+	x, err := normalize(args[0], cop, zero)
+	if err != nil {
+		return nil, err
+	}
+
+	y, err := normalize(args[1], cop, zero)
+	if err != nil {
+		return nil, err
+	}
+
+	// a true && or a false || needs both of the args, the others need either of them
+	if _, and := src.e.(*AndExpr); and == t {
+		return conjunction(x, y), nil
+	}
+
+	return append(x, y...), nil
+}
+
+// solveConstraints picks the minimal input values that meet all of the constraints
+func solveConstraints(cs []VmConstraint) (VmInputs, error) {
+	var alts [][][]vmAtom
+
+	for _, c := range cs {
+		a, err := normalize(c.A, c.Op, c.B)
+		if err != nil {
+			return nil, err
+		}
+		alts = append(alts, a)
+	}
+
+	var atoms []vmAtom
+
+	var search func(i int) (VmInputs, bool)
+	search = func(i int) (VmInputs, bool) {
+		if i == len(alts) {
+			return pick(atoms)
+		}
+
+		for _, conj := range alts[i] {
+			n := len(atoms)
+			atoms = append(atoms, conj...)
+
+			if _, ok := pick(atoms); ok {
+				if res, ok := search(i + 1); ok {
+					return res, true
+				}
+			}
+
+			atoms = atoms[:n]
+		}
+
+		return nil, false
+	}
+
+	res, ok := search(0)
+	if !ok {
+		return nil, errors.New("unsatisfiable constraints")
+	}
+
+	return res, nil
+}
+
+// pick picks the minimal value of every input of the atoms
+func pick(atoms []vmAtom) (VmInputs, bool) {
+	byInput := map[VmInput][]vmAtom{}
+	for _, a := range atoms {
+		byInput[a.term.in] = append(byInput[a.term.in], a)
+	}
+
+	res := VmInputs{}
+
+	for in, as := range byInput {
+		var v VmValue
+		var ok bool
+
+		if as[0].term.t == VmTypeBytes || as[0].term.kind != vmTermValue {
+			v, ok = pickBytes(in, as)
+		} else {
+			v, ok = pickUint64(as)
+		}
+
+		if !ok {
+			return nil, false
+		}
+
+		res[in] = v
+	}
+
+	return res, true
+}
+
+// narrow returns the bounds of the values meeting the atoms and the values excluded within them
+func narrow(as []vmAtom, lo uint64, hi uint64) (uint64, uint64, []uint64) {
+	var ne []uint64
+
+	for _, a := range as {
+		c := a.c.u()
+
+		switch a.op {
+		case "==":
+			if c > lo {
+				lo = c
+			}
+			if c < hi {
+				hi = c
+			}
+		case "!=":
+			ne = append(ne, c)
+		case "<":
+			if c == 0 {
+				return 1, 0, nil
+			}
+			if c-1 < hi {
+				hi = c - 1
+			}
+		case "<=":
+			if c < hi {
+				hi = c
+			}
+		case ">":
+			if c == math.MaxUint64 {
+				return 1, 0, nil
+			}
+			if c+1 > lo {
+				lo = c + 1
+			}
+		case ">=":
+			if c > lo {
+				lo = c
+			}
+		}
+	}
+
+	return lo, hi, ne
+}
+
+// candidates returns up to n of the smallest values within the bounds that are not excluded
+func candidates(lo uint64, hi uint64, ne []uint64, n int) []uint64 {
+	var res []uint64
+
+	for c := lo; c <= hi && len(res) < n; c++ {
+		excluded := false
+		for _, x := range ne {
+			if x == c {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			res = append(res, c)
+		}
+
+		if c == math.MaxUint64 {
+			break
+		}
+	}
+
+	return res
+}
+
+func pickUint64(as []vmAtom) (VmValue, bool) {
+	for _, a := range as {
+		if a.c.T != VmTypeUint64 {
+			return VmValue{}, false
+		}
+	}
+
+	lo, hi, ne := narrow(as, 0, math.MaxUint64)
+
+	cs := candidates(lo, hi, ne, 1)
+	if len(cs) == 0 {
+		return VmValue{}, false
+	}
+
+	return Uint64Value(cs[0]), true
+}
+
+func btoiValue(bs []byte) (uint64, bool) {
+	if len(bs) > 8 {
+		return 0, false
+	}
+
+	var r uint64
+	for _, c := range bs {
+		r = r<<8 | uint64(c)
+	}
+
+	return r, true
+}
+
+// meets tells whether the byte array meets all of the atoms
+func meets(bs []byte, as []vmAtom) bool {
+	for _, a := range as {
+		switch a.term.kind {
+		case vmTermValue:
+			if a.c.T != VmTypeBytes || !compareKnown(BytesValue(bs), a.op, a.c) {
+				return false
+			}
+		case vmTermBtoi:
+			n, ok := btoiValue(bs)
+			if !ok || !compareUint64(n, a.op, a.c.u()) {
+				return false
+			}
+		case vmTermLen:
+			if !compareUint64(uint64(len(bs)), a.op, a.c.u()) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func pickBytes(in VmInput, as []vmAtom) (VmValue, bool) {
+	var nums, lens []vmAtom
+	var cands [][]byte
+	var nes int
+
+	for _, a := range as {
+		switch a.term.kind {
+		case vmTermBtoi:
+			nums = append(nums, a)
+		case vmTermLen:
+			lens = append(lens, a)
+		default:
+			if a.op == "==" {
+				cands = append(cands, a.c.b())
+			} else {
+				nes++
+			}
+		}
+	}
+
+	if len(cands) == 0 {
+		var lenLo, lenHi uint64 = 0, MaxStringSize
+		if !in.Arg {
+			if l, ok := txnFieldLengths[in.Field]; ok {
+				lenLo, lenHi = l, l
+			}
+		}
+
+		lenLo, lenHi, lenNe := narrow(lens, lenLo, lenHi)
+		ls := candidates(lenLo, lenHi, lenNe, maxSolverCandidates)
+
+		if len(nums) > 0 {
+			lo, hi, ne := narrow(nums, 0, math.MaxUint64)
+			for _, n := range candidates(lo, hi, ne, maxSolverCandidates) {
+				for _, l := range ls {
+					if l > 8 || l < 8 && n>>(8*l) != 0 {
+						continue
+					}
+
+					bs := make([]byte, l)
+					for i := range bs {
+						bs[i] = byte(n >> (8 * (l - 1 - uint64(i))))
+					}
+					cands = append(cands, bs)
+				}
+			}
+		} else {
+			for _, l := range ls {
+				for i := 0; i <= nes && (l > 0 || i == 0); i++ {
+					bs := make([]byte, l)
+					if l > 0 {
+						bs[l-1] = byte(i)
+					}
+					cands = append(cands, bs)
+				}
+			}
+		}
+	}
+
+	for _, bs := range cands {
+		if meets(bs, as) {
+			return BytesValue(bs), true
+		}
+	}
+
+	return VmValue{}, false
+}
+
+// VmInputs are the values of the inputs that lead the program along a path
+type VmInputs map[VmInput]VmValue
+
+// Sorted returns the inputs ordered by the args first, then by the group index, the field and the array index
+func (ins VmInputs) Sorted() []VmInput {
+	res := make([]VmInput, 0, len(ins))
+	for in := range ins {
+		res = append(res, in)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		switch {
+		case a.Arg != b.Arg:
+			return a.Arg
+		case a.GroupIndex != b.GroupIndex:
+			return a.GroupIndex < b.GroupIndex
+		case a.Array != b.Array:
+			return !a.Array
+		case a.Field != b.Field:
+			return a.Field < b.Field
+		default:
+			return a.Index < b.Index
+		}
+	})
+
+	return res
+}
+
+// Apply returns copies of the group and the args with the inputs set, the group is extended with application calls
+func (ins VmInputs) Apply(group []types.SignedTxn, args [][]byte) ([]types.SignedTxn, [][]byte, error) {
+	g := append([]types.SignedTxn{}, group...)
+	args = append([][]byte{}, args...)
+
+	for _, in := range ins.Sorted() {
+		v := ins[in]
+
+		if in.Arg {
+			for uint64(len(args)) <= in.Index {
+				args = append(args, nil)
+			}
+			args[in.Index] = v.b()
+			continue
+		}
+
+		for uint64(len(g)) <= in.GroupIndex {
+			g = append(g, types.SignedTxn{Txn: types.Transaction{Type: types.ApplicationCallTx}})
+		}
+
+		t := &g[in.GroupIndex].Txn
+
+		var err error
+		if in.Array {
+			err = setTxnArray(t, in.Field, in.Index, v)
+		} else {
+			err = setTxnField(t, in.GroupIndex, in.Field, v)
+		}
+
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to set %s", in)
+		}
+	}
+
+	return g, args, nil
+}
+
+func resize[T any](s []T, n uint64) []T {
+	res := make([]T, n)
+	copy(res, s)
+	return res
+}
+
+// setTxnField sets a scalar field of the transaction, the count fields resize the arrays
+func setTxnField(t *types.Transaction, gi uint64, f TxnField, v VmValue) error {
+	switch f {
+	case FirstValid:
+		t.FirstValid = types.Round(v.u())
+	case LastValid:
+		t.LastValid = types.Round(v.u())
+	case Lease:
+		copy(t.Lease[:], v.b())
+	case GroupIndex:
+		if v.u() != gi {
+			return errors.Errorf("group index %d of transaction %d", v.u(), gi)
+		}
+	case NumAppArgs:
+		t.ApplicationArgs = resize(t.ApplicationArgs, v.u())
+	case NumAccounts:
+		t.Accounts = resize(t.Accounts, v.u())
+	case NumAssets:
+		t.ForeignAssets = resize(t.ForeignAssets, v.u())
+	case NumApplications:
+		t.ForeignApps = resize(t.ForeignApps, v.u())
+	default:
+		it := VmInnerTxn{Txn: *t}
+		err := it.set(f, v)
+		if err != nil {
+			return err
+		}
+		*t = it.Txn
+	}
+
+	return nil
+}
+
+// setTxnArray sets an element of an array field of the transaction, the array is extended up to the index
+func setTxnArray(t *types.Transaction, f TxnField, i uint64, v VmValue) error {
+	switch f {
+	case ApplicationArgs:
+		if i >= uint64(len(t.ApplicationArgs)) {
+			t.ApplicationArgs = resize(t.ApplicationArgs, i+1)
+		} else {
+			t.ApplicationArgs = append([][]byte{}, t.ApplicationArgs...)
+		}
+		t.ApplicationArgs[i] = v.b()
+	case Accounts:
+		addr, err := innerAddress(f, v)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			t.Sender = addr
+			return nil
+		}
+
+		if i > uint64(len(t.Accounts)) {
+			t.Accounts = resize(t.Accounts, i)
+		} else {
+			t.Accounts = append([]types.Address{}, t.Accounts...)
+		}
+		t.Accounts[i-1] = addr
+	case Assets:
+		if i >= uint64(len(t.ForeignAssets)) {
+			t.ForeignAssets = resize(t.ForeignAssets, i+1)
+		} else {
+			t.ForeignAssets = append([]types.AssetIndex{}, t.ForeignAssets...)
+		}
+		t.ForeignAssets[i] = types.AssetIndex(v.u())
+	case Applications:
+		if i == 0 {
+			t.ApplicationID = types.AppIndex(v.u())
+			return nil
+		}
+
+		if i > uint64(len(t.ForeignApps)) {
+			t.ForeignApps = resize(t.ForeignApps, i)
+		} else {
+			t.ForeignApps = append([]types.AppIndex{}, t.ForeignApps...)
+		}
+		t.ForeignApps[i-1] = types.AppIndex(v.u())
+	default:
+		return errors.Errorf("unsupported field: %s", f)
+	}
+
+	return nil
+}
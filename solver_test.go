@@ -0,0 +1,179 @@
+package teal
+
+import (
+	"testing"
+)
+
+const testRouter = `#pragma version 8
+txn NumAppArgs
+bz create
+method "add(uint64,uint64)uint64"
+method "noop()void"
+txna ApplicationArgs 0
+match add noop
+err
+add:
+txna ApplicationArgs 1
+btoi
+int 10
+>
+assert
+txn OnCompletion
+int NoOp
+==
+txna ApplicationArgs 2
+len
+int 8
+==
+&&
+return
+noop:
+txn OnCompletion
+int OptIn
+==
+txn OnCompletion
+int CloseOut
+==
+||
+return
+create:
+txn ApplicationID
+!
+return
+`
+
+func runInputs(res *ProcessResult, ins VmInputs) (*VmBranch, error) {
+	g, args, err := ins.Apply(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := NewVm(res, WithConcrete(), WithGroup(g, 0), WithArgs(args...))
+	vm.Run()
+
+	return vm.Branches[0], nil
+}
+
+func sameTrace(a []Op, b []Op) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestSolveBranches(t *testing.T) {
+	res := Process(testRouter)
+
+	vm := NewVm(res)
+	vm.Run()
+
+	if vm.Error != nil {
+		t.Fatalf("unexpected error: %v", vm.Error)
+	}
+
+	if len(vm.Branches) != 4 {
+		t.Fatalf("unexpected number of branches: %d", len(vm.Branches))
+	}
+
+	for _, b := range vm.Branches {
+		ins, err := b.Solve()
+		if err != nil {
+			t.Errorf("failed to solve branch %d: %s", b.Id, err)
+			continue
+		}
+
+		cb, err := runInputs(res, ins)
+		if err != nil {
+			t.Errorf("failed to apply inputs of branch %d: %s", b.Id, err)
+			continue
+		}
+
+		if !sameTrace(b.Trace, cb.Trace) {
+			t.Errorf("inputs %v of branch %d took another path: %v", ins, b.Id, cb.Trace)
+		}
+
+		if b.Outcome == VmErr && cb.Outcome != VmErr {
+			t.Errorf("inputs %v of branch %d did not fail", ins, b.Id)
+		}
+
+		if b.Outcome == VmUndecided && cb.Outcome != VmPass {
+			t.Errorf("inputs %v of branch %d did not pass: %s %v", ins, b.Id, cb.Outcome, cb.Err)
+		}
+	}
+}
+
+func TestSolveFailures(t *testing.T) {
+	res := Process(testRouter)
+
+	vm := NewVm(res)
+	vm.Run()
+
+	if len(vm.Failures) != 1 {
+		t.Fatalf("unexpected number of failures: %d", len(vm.Failures))
+	}
+
+	ins, err := vm.Failures[0].Solve()
+	if err != nil {
+		t.Fatalf("failed to solve: %s", err)
+	}
+
+	cb, err := runInputs(res, ins)
+	if err != nil {
+		t.Fatalf("failed to apply inputs: %s", err)
+	}
+
+	if cb.Outcome != VmErr || cb.Err == nil || cb.Err.Error() != "assert failed" {
+		t.Errorf("inputs %v did not fail the assert: %s %v", ins, cb.Outcome, cb.Err)
+	}
+}
+
+func TestSolveConstraints(t *testing.T) {
+	tests := []struct {
+		Src   string
+		Unsat bool
+	}{
+		{Src: "txn Fee\nint 5\n>\nassert\ntxn Fee\nint 8\n<\nassert\ntxn Fee\nint 6\n!=\nassert\nint 1"},
+		{Src: "txn Note\nlen\nint 3\n==\nassert\ntxn Note\nbyte 0x000000\n!=\nassert\nint 1"},
+		{Src: "txn Sender\nlen\nint 3\n==\nassert\nint 1", Unsat: true},
+		{Src: "txn Fee\nint 5\n>\nassert\ntxn Fee\nint 3\n<\nassert\nint 1", Unsat: true},
+	}
+
+	for _, test := range tests {
+		res := Process("#pragma version 8\n" + test.Src)
+
+		vm := NewVm(res)
+		vm.Run()
+
+		b := vm.Branches[0]
+
+		ins, err := b.Solve()
+		if test.Unsat {
+			if err == nil {
+				t.Errorf("expected unsatisfiable constraints for: %s, got: %v", test.Src, ins)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("failed to solve: %s: %s", test.Src, err)
+			continue
+		}
+
+		cb, err := runInputs(res, ins)
+		if err != nil {
+			t.Errorf("failed to apply inputs for: %s: %s", test.Src, err)
+			continue
+		}
+
+		if cb.Outcome != VmPass {
+			t.Errorf("inputs %v did not pass: %s: %s %v", ins, test.Src, cb.Outcome, cb.Err)
+		}
+	}
+}
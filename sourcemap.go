@@ -0,0 +1,180 @@
+package teal
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const sourceMapVersion = 3
+
+const vlqChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// SourceMap maps program counters to source lines the same way go-algorand does:
+// each ';' separated segment of Mappings corresponds to a single byte of the program.
+type SourceMap struct {
+	Version  int      `json:"version"`
+	Sources  []string `json:"sources"`
+	Names    []string `json:"names"`
+	Mappings string   `json:"mappings"`
+}
+
+type SourceLocation struct {
+	Line   int
+	Column int
+}
+
+func encodeVlq(b *strings.Builder, v int) {
+	var u int
+	if v < 0 {
+		u = (-v << 1) | 1
+	} else {
+		u = v << 1
+	}
+
+	for {
+		d := u & 31
+		u >>= 5
+		if u > 0 {
+			d |= 32
+		}
+
+		b.WriteByte(vlqChars[d])
+
+		if u == 0 {
+			break
+		}
+	}
+}
+
+func decodeVlqs(s string) ([]int, error) {
+	var res []int
+
+	v := 0
+	shift := 0
+
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(vlqChars, s[i])
+		if d < 0 {
+			return nil, errors.Errorf("invalid vlq character: %c", s[i])
+		}
+
+		v |= (d & 31) << shift
+		shift += 5
+
+		if d&32 == 0 {
+			if v&1 == 1 {
+				res = append(res, -(v >> 1))
+			} else {
+				res = append(res, v>>1)
+			}
+
+			v = 0
+			shift = 0
+		}
+	}
+
+	if shift > 0 {
+		return nil, errors.New("unterminated vlq value")
+	}
+
+	return res, nil
+}
+
+func makeSourceMap(source string, size int, locs map[int]SourceLocation) SourceMap {
+	var b strings.Builder
+
+	prev := SourceLocation{}
+
+	for pc := 0; pc < size; pc++ {
+		if pc > 0 {
+			b.WriteByte(';')
+		}
+
+		loc, ok := locs[pc]
+		if !ok {
+			continue
+		}
+
+		encodeVlq(&b, 0)
+		encodeVlq(&b, 0)
+		encodeVlq(&b, loc.Line-prev.Line)
+		encodeVlq(&b, loc.Column-prev.Column)
+
+		prev = loc
+	}
+
+	return SourceMap{
+		Version:  sourceMapVersion,
+		Sources:  []string{source},
+		Names:    []string{},
+		Mappings: b.String(),
+	}
+}
+
+// Locations returns the source location of every byte of the program,
+// bytes inside of an instruction share the location of its opcode.
+func (m SourceMap) Locations() ([]*SourceLocation, error) {
+	segs := strings.Split(m.Mappings, ";")
+	res := make([]*SourceLocation, len(segs))
+
+	var curr *SourceLocation
+	loc := SourceLocation{}
+
+	for pc, seg := range segs {
+		if len(seg) > 0 {
+			vs, err := decodeVlqs(seg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to decode mapping of pc %d", pc)
+			}
+
+			if len(vs) < 3 {
+				return nil, errors.Errorf("invalid mapping of pc %d", pc)
+			}
+
+			loc.Line += vs[2]
+			if len(vs) > 3 {
+				loc.Column += vs[3]
+			}
+
+			l := loc
+			curr = &l
+		}
+
+		res[pc] = curr
+	}
+
+	return res, nil
+}
+
+// Locate returns the source location of the instruction at the given pc.
+func (m SourceMap) Locate(pc int) (SourceLocation, bool) {
+	locs, err := m.Locations()
+	if err != nil || pc < 0 || pc >= len(locs) || locs[pc] == nil {
+		return SourceLocation{}, false
+	}
+
+	return *locs[pc], true
+}
+
+// AssembleWithSourceMap encodes the processed listing and maps every instruction to the
+// first token of its source line.
+func (r *ProcessResult) AssembleWithSourceMap(source string) ([]byte, SourceMap, error) {
+	a, prog, err := r.Listing.assemble(r.Version)
+	if err != nil {
+		return nil, SourceMap{}, err
+	}
+
+	locs := map[int]SourceLocation{}
+
+	for i, in := range a.instrs {
+		loc := SourceLocation{Line: in.line}
+		if in.line < len(r.Lines) {
+			loc.Column = r.Lines[in.line].Begin()
+		}
+
+		locs[a.pcs[i]] = loc
+	}
+
+	return prog, makeSourceMap(source, len(prog), locs), nil
+}
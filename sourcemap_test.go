@@ -0,0 +1,69 @@
+package teal
+
+import (
+	"testing"
+)
+
+func TestSourceMap(t *testing.T) {
+	res := Process("#pragma version 8\nint 1\n  return")
+
+	prog, sm, err := res.AssembleWithSourceMap("simple.teal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prog) != 4 {
+		t.Fatalf("unexpected program length: %d", len(prog))
+	}
+
+	if sm.Version != 3 {
+		t.Errorf("unexpected version: %d", sm.Version)
+	}
+
+	if len(sm.Sources) != 1 || sm.Sources[0] != "simple.teal" {
+		t.Errorf("unexpected sources: %v", sm.Sources)
+	}
+
+	if sm.Mappings != ";AACA;;AACE" {
+		t.Errorf("unexpected mappings: %s", sm.Mappings)
+	}
+
+	tests := []struct {
+		Pc   int
+		Loc  SourceLocation
+		Info bool
+	}{
+		{Pc: 0},
+		{Pc: 1, Loc: SourceLocation{Line: 1}, Info: true},
+		{Pc: 2, Loc: SourceLocation{Line: 1}, Info: true},
+		{Pc: 3, Loc: SourceLocation{Line: 2, Column: 2}, Info: true},
+		{Pc: 4},
+	}
+
+	for _, test := range tests {
+		loc, ok := sm.Locate(test.Pc)
+		if ok != test.Info || loc != test.Loc {
+			t.Errorf("unexpected location of pc %d: %v (%t)", test.Pc, loc, ok)
+		}
+	}
+}
+
+func TestSourceMapConstants(t *testing.T) {
+	res := Process("int 1\nint 1\nerr")
+
+	_, sm, err := res.AssembleWithSourceMap("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// version and intcblock prefix are not mapped
+	loc, ok := sm.Locate(4)
+	if !ok || loc.Line != 0 {
+		t.Errorf("unexpected location of the first instruction: %v (%t)", loc, ok)
+	}
+
+	loc, ok = sm.Locate(6)
+	if !ok || loc.Line != 2 {
+		t.Errorf("unexpected location of err: %v (%t)", loc, ok)
+	}
+}
@@ -0,0 +1,581 @@
+package teal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TokenError is a LineError that points at a token of the line rather than at the whole line
+type TokenError interface {
+	LineError
+	Token() int
+}
+
+func stackTypeName(t StackType) string {
+	switch t {
+	case StackUint64:
+		return "uint64"
+	case StackBytes:
+		return "bytes"
+	case StackNone:
+		return "none"
+	default:
+		return "any"
+	}
+}
+
+type StackUnderflowError struct {
+	l    int
+	op   string
+	need int
+	have int
+}
+
+func (e StackUnderflowError) Line() int {
+	return e.l
+}
+
+func (e StackUnderflowError) Token() int {
+	return 0
+}
+
+func (e StackUnderflowError) Error() string {
+	return fmt.Sprintf("stack underflow: %s needs %d values, stack has %d", e.op, e.need, e.have)
+}
+
+func (e StackUnderflowError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type StackTypeError struct {
+	l        int
+	op       string
+	arg      int
+	expected StackType
+	actual   StackType
+}
+
+func (e StackTypeError) Line() int {
+	return e.l
+}
+
+func (e StackTypeError) Token() int {
+	return 0
+}
+
+func (e StackTypeError) Error() string {
+	return fmt.Sprintf("%s arg %d expects %s, got %s", e.op, e.arg, stackTypeName(e.expected), stackTypeName(e.actual))
+}
+
+func (e StackTypeError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type StackHeightError struct {
+	l int
+	a int
+	b int
+}
+
+func (e StackHeightError) Line() int {
+	return e.l
+}
+
+func (e StackHeightError) Token() int {
+	return 0
+}
+
+func (e StackHeightError) Error() string {
+	return fmt.Sprintf("inconsistent stack height at join point: %d and %d", e.a, e.b)
+}
+
+func (e StackHeightError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+// lintStack are the types of the stack at an instruction, an open stack is the stack of a subroutine
+// that may consume values of the caller - under counts the values consumed below the entry
+type lintStack struct {
+	unknown bool
+	open    bool
+	under   int
+	types   []StackType
+}
+
+func (s *lintStack) clone() *lintStack {
+	ns := *s
+	ns.types = append([]StackType{}, s.types...)
+	return &ns
+}
+
+func (s *lintStack) height() int {
+	return len(s.types) - s.under
+}
+
+func (s *lintStack) equal(o *lintStack) bool {
+	if s.unknown || o.unknown {
+		return s.unknown == o.unknown
+	}
+
+	if s.open != o.open || s.under != o.under || len(s.types) != len(o.types) {
+		return false
+	}
+
+	for i := range s.types {
+		if s.types[i] != o.types[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// joinStacks merges the stacks of two paths, the result is unknown if the heights differ
+func joinStacks(a *lintStack, b *lintStack) (*lintStack, bool) {
+	if a.unknown || b.unknown {
+		return &lintStack{unknown: true}, true
+	}
+
+	if a.height() != b.height() {
+		return &lintStack{unknown: true}, false
+	}
+
+	if a.under < b.under {
+		a, b = b, a
+	}
+
+	bt := append(make([]StackType, a.under-b.under), b.types...)
+	for i := 0; i < a.under-b.under; i++ {
+		bt[i] = StackAny
+	}
+
+	res := &lintStack{
+		open:  a.open || b.open,
+		under: a.under,
+		types: make([]StackType, len(a.types)),
+	}
+
+	for i, t := range a.types {
+		if t == bt[i] {
+			res.types[i] = t
+		} else {
+			res.types[i] = StackAny
+		}
+	}
+
+	return res, true
+}
+
+func stackTypesOf(s string) []StackType {
+	res := make([]StackType, len(s))
+	for i, c := range s {
+		switch c {
+		case 'U':
+			res[i] = StackUint64
+		case 'B':
+			res[i] = StackBytes
+		default:
+			res[i] = StackAny
+		}
+	}
+
+	return res
+}
+
+// stackEffect returns the types of the args and the results of the op, field dependent types are resolved from the immediates
+func stackEffect(op Op) ([]StackType, []StackType, bool) {
+	switch op := op.(type) {
+	case *IntExpr, *PushIntExpr:
+		return nil, []StackType{StackUint64}, true
+	case *ByteExpr, *PushBytesExpr, *AddrExpr, *MethodExpr:
+		return nil, []StackType{StackBytes}, true
+	case *PushIntsExpr:
+		return nil, stackTypesOf(strings.Repeat("U", len(op.Ints))), true
+	case *PushBytessExpr:
+		return nil, stackTypesOf(strings.Repeat("B", len(op.Bytess))), true
+	case *JsonRefExpr:
+		t := StackBytes
+		if JSONRefType(op.Index) == JSONUint64 {
+			t = StackUint64
+		}
+		return []StackType{StackBytes, StackBytes}, []StackType{t}, true
+	}
+
+	lo, ok := langOpsByName[opName(op)]
+	if !ok {
+		return nil, nil, false
+	}
+
+	args := []byte(lo.Args)
+	rets := []byte(lo.Returns)
+
+	if len(lo.ArgEnum) > 0 && len(lo.ArgEnumTypes) == len(lo.ArgEnum) {
+		for _, f := range strings.Fields(op.String())[1:] {
+			for i, name := range lo.ArgEnum {
+				if name != f {
+					continue
+				}
+
+				ts := rets
+				if strings.IndexByte(lo.Returns, '.') == -1 {
+					ts = args
+				}
+
+				if j := strings.IndexByte(string(ts), '.'); j != -1 {
+					ts[j] = lo.ArgEnumTypes[i]
+				}
+			}
+		}
+	}
+
+	return stackTypesOf(string(args)), stackTypesOf(string(rets)), true
+}
+
+// stackSummary is the stack effect of a subroutine
+type stackSummary struct {
+	pops   int
+	pushes []StackType
+}
+
+func (s *stackSummary) equal(o *stackSummary) bool {
+	if s == nil || o == nil {
+		return s == o
+	}
+
+	if s.pops != o.pops || len(s.pushes) != len(o.pushes) {
+		return false
+	}
+
+	for i := range s.pushes {
+		if s.pushes[i] != o.pushes[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+type stackChecker struct {
+	l *Linter
+
+	subs   map[string]*stackSummary
+	report bool
+	errs   lintErrors
+
+	// states are the stacks before the lines, recorded if not nil
+	states map[int]*lintStack
+}
+
+func (c *stackChecker) fail(err LineError) {
+	if c.report {
+		c.errs.add(err)
+	}
+}
+
+// need makes sure the stack has at least n values, an open stack takes the missing values from the caller
+func (c *stackChecker) need(i int, s *lintStack, n int) bool {
+	if len(s.types) >= n {
+		return true
+	}
+
+	if !s.open {
+		c.fail(StackUnderflowError{l: i, op: opName(c.l.l[i]), need: n, have: len(s.types)})
+		return false
+	}
+
+	missing := n - len(s.types)
+	s.types = append(make([]StackType, missing), s.types...)
+	for j := 0; j < missing; j++ {
+		s.types[j] = StackAny
+	}
+	s.under += missing
+
+	return true
+}
+
+func (s *lintStack) pop(n int) []StackType {
+	res := append([]StackType{}, s.types[len(s.types)-n:]...)
+	s.types = s.types[:len(s.types)-n]
+	return res
+}
+
+func (s *lintStack) push(ts ...StackType) {
+	s.types = append(s.types, ts...)
+}
+
+func (s *lintStack) top(depth int) StackType {
+	return s.types[len(s.types)-1-depth]
+}
+
+// apply runs the op on the stack, it returns false if the stack is not known after the op
+func (c *stackChecker) apply(i int, s *lintStack) bool {
+	op := c.l.l[i]
+
+	switch op := op.(type) {
+	case Nop, *ProtoExpr, *IntcBlockExpr, *BytecBlockExpr:
+		return true
+	case *DupExpr:
+		if !c.need(i, s, 1) {
+			return false
+		}
+		s.push(s.top(0))
+	case *Dup2Expr:
+		if !c.need(i, s, 2) {
+			return false
+		}
+		s.push(s.top(1), s.top(0))
+	case *DigExpr:
+		if !c.need(i, s, int(op.Index)+1) {
+			return false
+		}
+		s.push(s.top(int(op.Index)))
+	case *SwapExpr:
+		if !c.need(i, s, 2) {
+			return false
+		}
+		ts := s.pop(2)
+		s.push(ts[1], ts[0])
+	case *SelectExpr:
+		if !c.need(i, s, 3) {
+			return false
+		}
+		c.check(i, s, []StackType{StackAny, StackAny, StackUint64})
+		ts := s.pop(3)
+		if ts[0] == ts[1] {
+			s.push(ts[0])
+		} else {
+			s.push(StackAny)
+		}
+	case *CoverExpr:
+		if !c.need(i, s, int(op.Depth)+1) {
+			return false
+		}
+		ts := s.pop(int(op.Depth) + 1)
+		s.push(ts[len(ts)-1])
+		s.push(ts[:len(ts)-1]...)
+	case *UncoverExpr:
+		if !c.need(i, s, int(op.Depth)+1) {
+			return false
+		}
+		ts := s.pop(int(op.Depth) + 1)
+		s.push(ts[1:]...)
+		s.push(ts[0])
+	case *BuryExpr:
+		if !c.need(i, s, int(op.Depth)+1) {
+			return false
+		}
+		s.types[len(s.types)-1-int(op.Depth)] = s.top(0)
+		s.pop(1)
+	case *PopNExpr:
+		if !c.need(i, s, int(op.Depth)) {
+			return false
+		}
+		s.pop(int(op.Depth))
+	case *DupNExpr:
+		if !c.need(i, s, 1) {
+			return false
+		}
+		for j := 0; j < int(op.Count); j++ {
+			s.push(s.top(0))
+		}
+	case *FrameDigExpr:
+		s.push(StackAny)
+	case *FrameBuryExpr:
+		if !c.need(i, s, 1) {
+			return false
+		}
+		s.pop(1)
+	case *SetBitExpr:
+		if !c.need(i, s, 3) {
+			return false
+		}
+		c.check(i, s, []StackType{StackAny, StackUint64, StackUint64})
+		ts := s.pop(3)
+		s.push(ts[0])
+	case *MatchExpr:
+		if !c.need(i, s, len(op.Targets)+1) {
+			return false
+		}
+		s.pop(len(op.Targets) + 1)
+	case *CallSubExpr:
+		sum := c.subs[op.Label.Name]
+		if sum == nil || !c.need(i, s, sum.pops) {
+			return false
+		}
+		s.pop(sum.pops)
+		s.push(sum.pushes...)
+	default:
+		args, rets, ok := stackEffect(op)
+		if !ok {
+			return false
+		}
+
+		if !c.need(i, s, len(args)) {
+			return false
+		}
+
+		c.check(i, s, args)
+		s.pop(len(args))
+		s.push(rets...)
+	}
+
+	return true
+}
+
+// check reports the values on top of the stack that do not match the arg types
+func (c *stackChecker) check(i int, s *lintStack, args []StackType) {
+	for j, t := range args {
+		a := s.types[len(s.types)-len(args)+j]
+		if t == StackAny || a == StackAny || t == a {
+			continue
+		}
+
+		c.fail(StackTypeError{l: i, op: opName(c.l.l[i]), arg: j, expected: t, actual: a})
+	}
+}
+
+// run checks the code reachable from the entry line and returns the merged stack at the retsubs
+func (c *stackChecker) run(entry int, init *lintStack) *lintStack {
+	in := make([]*lintStack, len(c.l.l))
+	in[entry] = init
+
+	work := []int{entry}
+	queued := map[int]bool{entry: true}
+
+	var ret *lintStack
+
+	for len(work) > 0 {
+		i := work[0]
+		work = work[1:]
+		queued[i] = false
+
+		s := in[i].clone()
+
+		if c.report && c.states != nil {
+			c.states[i] = in[i]
+		}
+
+		if !s.unknown && !c.apply(i, s) {
+			s = &lintStack{unknown: true}
+		}
+
+		if _, ok := c.l.l[i].(*RetSubExpr); ok {
+			if ret == nil {
+				ret = s
+			} else {
+				ret, _ = joinStacks(ret, s)
+			}
+			continue
+		}
+
+		for _, n := range c.l.next(i) {
+			prev := in[n]
+
+			var ns *lintStack
+			if prev == nil {
+				ns = s
+			} else {
+				var ok bool
+				ns, ok = joinStacks(prev, s)
+				if !ok {
+					c.fail(StackHeightError{l: n, a: prev.height(), b: s.height()})
+				}
+
+				if ns.equal(prev) {
+					continue
+				}
+			}
+
+			in[n] = ns
+
+			if !queued[n] {
+				queued[n] = true
+				work = append(work, n)
+			}
+		}
+	}
+
+	return ret
+}
+
+// summarize computes the stack effect of the subroutine at the label, nil if it is not known
+func (c *stackChecker) summarize(name string, line int) *stackSummary {
+	if p, ok := c.l.proto(line); ok {
+		pushes := make([]StackType, p.Results)
+		for i := range pushes {
+			pushes[i] = StackAny
+		}
+		return &stackSummary{pops: int(p.Args), pushes: pushes}
+	}
+
+	ret := c.run(line, &lintStack{open: true})
+	if ret == nil || ret.unknown {
+		return nil
+	}
+
+	return &stackSummary{pops: ret.under, pushes: ret.types}
+}
+
+// analyze computes the subroutine summaries and checks the program and the subroutines
+func (c *stackChecker) analyze() {
+	l := c.l
+	subs := l.subroutines()
+
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for round := 0; round <= len(names); round++ {
+		changed := false
+
+		for _, name := range names {
+			sum := c.summarize(name, subs[name])
+			if !sum.equal(c.subs[name]) {
+				c.subs[name] = sum
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+
+	c.report = true
+
+	if len(l.l) > 0 {
+		c.run(0, &lintStack{})
+	}
+
+	for _, name := range names {
+		c.run(subs[name], &lintStack{open: true})
+	}
+}
+
+func newStackChecker(l *Linter) *stackChecker {
+	return &stackChecker{
+		l:    l,
+		subs: map[string]*stackSummary{},
+		errs: lintErrors{},
+	}
+}
+
+// stackStates returns the stacks before the reachable lines
+func (l *Linter) stackStates() map[int]*lintStack {
+	c := newStackChecker(l)
+	c.states = map[int]*lintStack{}
+	c.analyze()
+
+	return c.states
+}
+
+// checkStack tracks the stack height and types at every instruction
+func (l *Linter) checkStack() {
+	c := newStackChecker(l)
+	c.analyze()
+
+	l.report(c.errs)
+}
@@ -0,0 +1,356 @@
+package teal
+
+import (
+	"fmt"
+	"sort"
+)
+
+type RetSubOutsideError struct {
+	l int
+}
+
+func (e RetSubOutsideError) Line() int {
+	return e.l
+}
+
+func (e RetSubOutsideError) Error() string {
+	return "retsub outside of a subroutine"
+}
+
+func (e RetSubOutsideError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type SubroutineFallThroughError struct {
+	l    int
+	name string
+}
+
+func (e SubroutineFallThroughError) Line() int {
+	return e.l
+}
+
+func (e SubroutineFallThroughError) Error() string {
+	return fmt.Sprintf("code falls through into subroutine %s", e.name)
+}
+
+func (e SubroutineFallThroughError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type RecursionError struct {
+	l    int
+	name string
+}
+
+func (e RecursionError) Line() int {
+	return e.l
+}
+
+func (e RecursionError) Error() string {
+	return fmt.Sprintf("subroutine %s recurses without an exit", e.name)
+}
+
+func (e RecursionError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type ProtoArgsError struct {
+	l    int
+	args int
+	used int
+}
+
+func (e ProtoArgsError) Line() int {
+	return e.l
+}
+
+func (e ProtoArgsError) Error() string {
+	return fmt.Sprintf("proto declares %d args, the subroutine pops %d values of the caller", e.args, e.used)
+}
+
+func (e ProtoArgsError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type ProtoResultsError struct {
+	l       int
+	results int
+	height  int
+}
+
+func (e ProtoResultsError) Line() int {
+	return e.l
+}
+
+func (e ProtoResultsError) Error() string {
+	return fmt.Sprintf("proto declares %d results, the frame has %d values at retsub", e.results, e.height)
+}
+
+func (e ProtoResultsError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type FrameIndexError struct {
+	l      int
+	op     string
+	index  int8
+	proto  bool
+	args   int
+	height int
+}
+
+func (e FrameIndexError) Line() int {
+	return e.l
+}
+
+func (e FrameIndexError) Error() string {
+	switch {
+	case !e.proto:
+		return fmt.Sprintf("%s outside of a proto subroutine", e.op)
+	case e.index < 0:
+		return fmt.Sprintf("%s %d is below the %d args of the proto", e.op, e.index, e.args)
+	default:
+		return fmt.Sprintf("%s %d is above the %d values of the frame", e.op, e.index, e.height)
+	}
+}
+
+func (e FrameIndexError) Severity() DiagnosticSeverity {
+	return DiagWarn
+}
+
+type subChecker struct {
+	l *Linter
+
+	main  *routine
+	names []string
+	subs  map[string]*routine
+
+	errs lintErrors
+}
+
+// checkRetSubs reports the retsubs reachable from the main program without a callsub
+func (c *subChecker) checkRetSubs() {
+	for _, i := range c.main.lines {
+		if _, ok := c.l.l[i].(*RetSubExpr); ok {
+			c.errs.add(RetSubOutsideError{l: i})
+		}
+	}
+}
+
+// checkFallThrough reports the subroutines entered by falling through from the code above them
+func (c *subChecker) checkFallThrough() {
+	rs := []*routine{c.main}
+	for _, name := range c.names {
+		rs = append(rs, c.subs[name])
+	}
+
+	for _, name := range c.names {
+		entry := c.subs[name].entry
+
+		for _, r := range rs {
+			if r.entry == entry {
+				continue
+			}
+
+			for _, p := range r.preds[entry] {
+				if _, ok := c.l.l[p].(*BExpr); !ok && p == entry-1 {
+					c.errs.add(SubroutineFallThroughError{l: entry, name: name})
+				}
+			}
+		}
+	}
+}
+
+// exits returns whether the subroutine can return or end the program, calls only continue if the callee exits
+func (c *subChecker) exits(name string, ok map[string]bool) bool {
+	r := c.subs[name]
+
+	seen := map[int]bool{r.entry: true}
+	work := []int{r.entry}
+
+	for len(work) > 0 {
+		i := work[0]
+		work = work[1:]
+
+		switch op := c.l.l[i].(type) {
+		case Terminator:
+			return true
+		case *CallSubExpr:
+			if !ok[op.Label.Name] {
+				continue
+			}
+		case *BExpr:
+		default:
+			if i == len(c.l.l)-1 {
+				return true
+			}
+		}
+
+		for _, n := range c.l.next(i) {
+			if !seen[n] {
+				seen[n] = true
+				work = append(work, n)
+			}
+		}
+	}
+
+	return false
+}
+
+// checkRecursion reports the recursive subroutines without a path that returns
+func (c *subChecker) checkRecursion() {
+	callees := map[string][]string{}
+	for _, name := range c.names {
+		for _, i := range c.subs[name].lines {
+			if op, ok := c.l.l[i].(*CallSubExpr); ok {
+				if _, ok := c.subs[op.Label.Name]; ok {
+					callees[name] = append(callees[name], op.Label.Name)
+				}
+			}
+		}
+	}
+
+	ok := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+
+		for _, name := range c.names {
+			if !ok[name] && c.exits(name, ok) {
+				ok[name] = true
+				changed = true
+			}
+		}
+	}
+
+	for _, name := range c.names {
+		if ok[name] {
+			continue
+		}
+
+		seen := map[string]bool{}
+		work := append([]string{}, callees[name]...)
+
+		for len(work) > 0 {
+			n := work[0]
+			work = work[1:]
+
+			if n == name {
+				c.errs.add(RecursionError{l: c.subs[name].entry, name: name})
+				break
+			}
+
+			if !seen[n] {
+				seen[n] = true
+				work = append(work, callees[n]...)
+			}
+		}
+	}
+}
+
+// checkFrames checks the frame accesses and the stack at the retsubs against the proto of the subroutine
+func (c *subChecker) checkFrames() {
+	states := c.l.stackStates()
+
+	reached := map[int]bool{}
+	for _, i := range c.main.lines {
+		reached[i] = true
+	}
+
+	frames := map[int]*ProtoExpr{}
+	for _, name := range c.names {
+		r := c.subs[name]
+		p, ok := c.l.proto(r.entry)
+
+		for _, i := range r.lines {
+			reached[i] = true
+			if ok {
+				frames[i] = p
+			}
+		}
+	}
+
+	for i, o := range c.l.l {
+		var index int8
+		bury := false
+
+		switch o := o.(type) {
+		case *FrameDigExpr:
+			index = o.Index
+		case *FrameBuryExpr:
+			index, bury = o.Index, true
+		case *RetSubExpr:
+			p, s := frames[i], states[i]
+			if p == nil || s == nil || s.unknown {
+				continue
+			}
+
+			if s.under > int(p.Args) {
+				c.errs.add(ProtoArgsError{l: i, args: int(p.Args), used: s.under})
+			} else if s.height() < int(p.Results) {
+				c.errs.add(ProtoResultsError{l: i, results: int(p.Results), height: s.height()})
+			}
+			continue
+		default:
+			continue
+		}
+
+		p := frames[i]
+		if p == nil {
+			if !reached[i] {
+				continue
+			}
+			c.errs.add(FrameIndexError{l: i, op: opName(o), index: index})
+			continue
+		}
+
+		if index < 0 {
+			if int(-index) > int(p.Args) {
+				c.errs.add(FrameIndexError{l: i, op: opName(o), index: index, proto: true, args: int(p.Args)})
+			}
+			continue
+		}
+
+		s := states[i]
+		if s == nil || s.unknown {
+			continue
+		}
+
+		// frame_bury pops its value before writing it to the frame
+		h := s.height()
+		if bury && h > 0 {
+			h--
+		}
+
+		if int(index) >= h {
+			c.errs.add(FrameIndexError{l: i, op: opName(o), index: index, proto: true, height: h})
+		}
+	}
+}
+
+// checkSubroutines checks the structure of the subroutines and their frames
+func (l *Linter) checkSubroutines() {
+	if len(l.l) == 0 {
+		return
+	}
+
+	c := &subChecker{
+		l:    l,
+		main: l.routine(0),
+		subs: map[string]*routine{},
+		errs: lintErrors{},
+	}
+
+	for name, line := range l.subroutines() {
+		c.names = append(c.names, name)
+		c.subs[name] = l.routine(line)
+	}
+	sort.Strings(c.names)
+
+	c.checkRetSubs()
+	c.checkFallThrough()
+	c.checkRecursion()
+	c.checkFrames()
+
+	l.report(c.errs)
+}
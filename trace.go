@@ -0,0 +1,127 @@
+package teal
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// VmTraceVersion is the version of the trace json format
+const VmTraceVersion = 1
+
+// VmTraceValue is a stack or scratch value encoded the way algod encodes avm values - type 1 for byte arrays
+// and 2 for uint64s, values not known to the vm have type 0 if their type is not known either and a symbol
+// describing where the value comes from
+type VmTraceValue struct {
+	Type   uint64 `json:"type"`
+	Bytes  []byte `json:"bytes,omitempty"`
+	Uint   uint64 `json:"uint,omitempty"`
+	Symbol string `json:"symbol,omitempty"`
+}
+
+// VmTraceScratchChange is a scratch slot written by a step
+type VmTraceScratchChange struct {
+	Slot     int          `json:"slot"`
+	NewValue VmTraceValue `json:"new-value"`
+}
+
+// VmTraceStep is an op executed by a branch, the line is 1-based and the budget is what remains after the op
+type VmTraceStep struct {
+	Branch         int                    `json:"branch"`
+	Line           int                    `json:"line"`
+	Op             string                 `json:"op"`
+	StackBefore    []VmTraceValue         `json:"stack-before"`
+	StackAfter     []VmTraceValue         `json:"stack-after"`
+	ScratchChanges []VmTraceScratchChange `json:"scratch-changes,omitempty"`
+	Cost           int                    `json:"cost"`
+	Budget         int                    `json:"budget"`
+}
+
+// VmTrace is the trace json document
+type VmTrace struct {
+	Version int           `json:"version"`
+	Steps   []VmTraceStep `json:"steps"`
+}
+
+// WithTrace records a VmTraceStep for every op executed by any of the branches
+func WithTrace() VmOption {
+	return func(v *Vm) {
+		v.tracing = true
+	}
+}
+
+func traceValue(v VmValue) VmTraceValue {
+	var tv VmTraceValue
+
+	switch v.T {
+	case VmTypeBytes:
+		tv.Type = 1
+	case VmTypeUint64:
+		tv.Type = 2
+	}
+
+	if bs, ok := v.Bytes(); ok {
+		tv.Bytes = bs
+	} else if u, ok := v.Uint64(); ok {
+		tv.Uint = u
+	} else {
+		tv.Symbol = v.String()
+	}
+
+	return tv
+}
+
+func traceStack(items []VmValue) []VmTraceValue {
+	res := make([]VmTraceValue, len(items))
+	for i, v := range items {
+		res[i] = traceValue(v)
+	}
+
+	return res
+}
+
+// traceScratch returns the slots that differ between the scratch spaces
+func traceScratch(before *[256]VmValue, after *[256]VmValue) []VmTraceScratchChange {
+	var res []VmTraceScratchChange
+
+	for i := range after {
+		a, b := before[i], after[i]
+		if a.T == b.T && a.String() == b.String() {
+			continue
+		}
+
+		res = append(res, VmTraceScratchChange{Slot: i, NewValue: traceValue(b)})
+	}
+
+	return res
+}
+
+// WriteTrace writes the recorded steps as a trace json document
+func (v *Vm) WriteTrace(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	err := e.Encode(VmTrace{Version: VmTraceVersion, Steps: v.Trace})
+	if err != nil {
+		return errors.Wrap(err, "failed to write trace")
+	}
+
+	return nil
+}
+
+// ReadTrace reads a trace json document
+func ReadTrace(bs []byte) (*VmTrace, error) {
+	var t VmTrace
+
+	err := json.Unmarshal(bs, &t)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse trace")
+	}
+
+	if t.Version != VmTraceVersion {
+		return nil, errors.Errorf("unsupported trace version: %d", t.Version)
+	}
+
+	return &t, nil
+}
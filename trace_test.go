@@ -0,0 +1,48 @@
+package teal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	vm := NewVm(Process("#pragma version 8\nint 5\nstore 1\ntxn Fee\npop\nint 1"), WithTrace())
+	vm.Run()
+
+	if vm.Error != nil {
+		t.Fatalf("unexpected error: %v", vm.Error)
+	}
+
+	if len(vm.Trace) != 5 {
+		t.Fatalf("unexpected number of steps: %d", len(vm.Trace))
+	}
+
+	s := vm.Trace[1]
+	if s.Line != 3 || s.Op != "store 1" || len(s.StackBefore) != 1 || len(s.StackAfter) != 0 || s.Cost != 1 || s.Budget != 698 {
+		t.Errorf("unexpected step: %+v", s)
+	}
+
+	if len(s.ScratchChanges) != 1 || s.ScratchChanges[0].Slot != 1 || s.ScratchChanges[0].NewValue.Uint != 5 {
+		t.Errorf("unexpected scratch changes: %+v", s.ScratchChanges)
+	}
+
+	if v := vm.Trace[2].StackAfter[0]; v.Type != 2 || v.Symbol == "" {
+		t.Errorf("unexpected unknown value: %+v", v)
+	}
+
+	var b bytes.Buffer
+
+	err := vm.WriteTrace(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ReadTrace(b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Steps) != len(vm.Trace) || r.Steps[1].ScratchChanges[0].NewValue.Uint != 5 {
+		t.Errorf("unexpected steps read: %+v", r.Steps)
+	}
+}
@@ -0,0 +1,495 @@
+package teal
+
+import (
+	"github.com/algorand/go-algorand-sdk/crypto"
+	"github.com/algorand/go-algorand-sdk/types"
+	"github.com/pkg/errors"
+)
+
+const maxProgramPageSize = 4096
+
+// VmGlobals are the values of the global fields that do not come from the group, the ledger or the vm itself
+type VmGlobals struct {
+	MinTxnFee           uint64
+	MinBalance          uint64
+	MaxTxnLife          uint64
+	LogicSigVersion     uint64
+	Round               uint64
+	LatestTimestamp     uint64
+	CreatorAddress      types.Address
+	CallerApplicationID uint64
+}
+
+// DefaultGlobals returns the mainnet consensus values
+func DefaultGlobals() VmGlobals {
+	return VmGlobals{
+		MinTxnFee:       1000,
+		MinBalance:      100000,
+		MaxTxnLife:      1000,
+		LogicSigVersion: 8,
+	}
+}
+
+var txnTypeEnums = map[types.TxType]uint64{
+	types.PaymentTx:         1,
+	types.KeyRegistrationTx: 2,
+	types.AssetConfigTx:     3,
+	types.AssetTransferTx:   4,
+	types.AssetFreezeTx:     5,
+	types.ApplicationCallTx: 6,
+}
+
+// txnFieldBounds are the bounds of the uint64 fields enforced by the protocol
+var txnFieldBounds = map[TxnField][2]uint64{
+	GroupIndex:                {0, maxTxGroupSize - 1},
+	TypeEnum:                  {0, 6},
+	OnCompletion:              {0, 5},
+	NumAppArgs:                {0, 16},
+	NumAccounts:               {0, 4},
+	NumAssets:                 {0, 8},
+	NumApplications:           {0, 8},
+	ConfigAssetDecimals:       {0, 19},
+	ConfigAssetDefaultFrozen:  {0, 1},
+	FreezeAssetFrozen:         {0, 1},
+	Nonparticipation:          {0, 1},
+	GlobalNumUint:             {0, 64},
+	GlobalNumByteSlice:        {0, 64},
+	LocalNumUint:              {0, 16},
+	LocalNumByteSlice:         {0, 16},
+	ExtraProgramPages:         {0, 3},
+	NumApprovalProgramPages:   {0, 2},
+	NumClearStateProgramPages: {0, 2},
+	NumLogs:                   {0, maxLogCalls},
+}
+
+// txnArrayCounts are the count fields of the array fields, offset is the number of the implicit elements
+var txnArrayCounts = map[TxnField]struct {
+	count  TxnField
+	offset uint64
+}{
+	ApplicationArgs:        {count: NumAppArgs},
+	Accounts:               {count: NumAccounts, offset: 1},
+	Assets:                 {count: NumAssets},
+	Applications:           {count: NumApplications, offset: 1},
+	ApprovalProgramPages:   {count: NumApprovalProgramPages},
+	ClearStateProgramPages: {count: NumClearStateProgramPages},
+}
+
+// txnInput returns an unknown value of the field narrowed to the bounds of the field
+func (b *VmBranch) txnInput(gi VmValue, f TxnField, ai *VmValue, t VmDataType) VmValue {
+	v := b.input(t)
+	if v.Known() {
+		if l, ok := txnFieldLengths[f]; ok {
+			return BytesValue(make([]byte, l))
+		}
+		return v
+	}
+
+	if gi.Known() && (ai == nil || ai.Known()) {
+		in := VmInput{GroupIndex: gi.u(), Field: f}
+		if ai != nil {
+			in.Array = true
+			in.Index = ai.u()
+
+			if c, ok := txnArrayCounts[f]; ok && in.Index >= c.offset {
+				b.assume(b.txnInput(gi, c.count, nil, VmTypeUint64), ">", Uint64Value(in.Index-c.offset))
+			}
+		}
+		v.src = in
+	}
+
+	if r, ok := txnFieldBounds[f]; ok {
+		v = vmRanged(v, r[0], r[1])
+	}
+
+	return v
+}
+
+func addressBytes(addr types.Address) VmValue {
+	return BytesValue(addr[:])
+}
+
+func programPage(p []byte, i uint64) ([]byte, bool) {
+	start := i * maxProgramPageSize
+	if start >= uint64(len(p)) {
+		return nil, false
+	}
+
+	end := start + maxProgramPageSize
+	if end > uint64(len(p)) {
+		end = uint64(len(p))
+	}
+
+	return p[start:end], true
+}
+
+func programPages(p []byte) uint64 {
+	return (uint64(len(p)) + maxProgramPageSize - 1) / maxProgramPageSize
+}
+
+// txnArrayValue resolves an element of an array field of the transaction
+func txnArrayValue(t *types.Transaction, f TxnField, i uint64) (VmValue, bool, error) {
+	var n uint64
+
+	switch f {
+	case ApplicationArgs:
+		n = uint64(len(t.ApplicationArgs))
+		if i < n {
+			return BytesValue(t.ApplicationArgs[i]), true, nil
+		}
+	case Accounts:
+		if i == 0 {
+			return addressBytes(t.Sender), true, nil
+		}
+		n = uint64(len(t.Accounts)) + 1
+		if i < n {
+			return addressBytes(t.Accounts[i-1]), true, nil
+		}
+	case Assets:
+		n = uint64(len(t.ForeignAssets))
+		if i < n {
+			return Uint64Value(uint64(t.ForeignAssets[i])), true, nil
+		}
+	case Applications:
+		if i == 0 {
+			return Uint64Value(uint64(t.ApplicationID)), true, nil
+		}
+		n = uint64(len(t.ForeignApps)) + 1
+		if i < n {
+			return Uint64Value(uint64(t.ForeignApps[i-1])), true, nil
+		}
+	case ApprovalProgramPages:
+		if p, ok := programPage(t.ApprovalProgram, i); ok {
+			return BytesValue(p), true, nil
+		}
+		n = programPages(t.ApprovalProgram)
+	case ClearStateProgramPages:
+		if p, ok := programPage(t.ClearStateProgram, i); ok {
+			return BytesValue(p), true, nil
+		}
+		n = programPages(t.ClearStateProgram)
+	default:
+		return VmValue{}, false, nil
+	}
+
+	return VmValue{}, false, errors.Errorf("invalid %s index %d (count: %d)", f, i, n)
+}
+
+// txnValue resolves a scalar field of the transaction at the group index gi
+func txnValue(t *types.Transaction, gi uint64, f TxnField) (VmValue, bool) {
+	switch f {
+	case Sender:
+		return addressBytes(t.Sender), true
+	case Fee:
+		return Uint64Value(uint64(t.Fee)), true
+	case FirstValid:
+		return Uint64Value(uint64(t.FirstValid)), true
+	case LastValid:
+		return Uint64Value(uint64(t.LastValid)), true
+	case Note:
+		return BytesValue(t.Note), true
+	case Lease:
+		return BytesValue(t.Lease[:]), true
+	case Receiver:
+		return addressBytes(t.Receiver), true
+	case Amount:
+		return Uint64Value(uint64(t.Amount)), true
+	case CloseRemainderTo:
+		return addressBytes(t.CloseRemainderTo), true
+	case VotePK:
+		return BytesValue(t.VotePK[:]), true
+	case SelectionPK:
+		return BytesValue(t.SelectionPK[:]), true
+	case VoteFirst:
+		return Uint64Value(uint64(t.VoteFirst)), true
+	case VoteLast:
+		return Uint64Value(uint64(t.VoteLast)), true
+	case VoteKeyDilution:
+		return Uint64Value(t.VoteKeyDilution), true
+	case Type:
+		return BytesValue([]byte(t.Type)), true
+	case TypeEnum:
+		return Uint64Value(txnTypeEnums[t.Type]), true
+	case XferAsset:
+		return Uint64Value(uint64(t.XferAsset)), true
+	case AssetAmount:
+		return Uint64Value(t.AssetAmount), true
+	case AssetSender:
+		return addressBytes(t.AssetSender), true
+	case AssetReceiver:
+		return addressBytes(t.AssetReceiver), true
+	case AssetCloseTo:
+		return addressBytes(t.AssetCloseTo), true
+	case GroupIndex:
+		return Uint64Value(gi), true
+	case TxID:
+		return BytesValue(crypto.TransactionID(*t)), true
+	case ApplicationID:
+		return Uint64Value(uint64(t.ApplicationID)), true
+	case OnCompletion:
+		return Uint64Value(uint64(t.OnCompletion)), true
+	case NumAppArgs:
+		return Uint64Value(uint64(len(t.ApplicationArgs))), true
+	case NumAccounts:
+		return Uint64Value(uint64(len(t.Accounts))), true
+	case ApprovalProgram:
+		return BytesValue(t.ApprovalProgram), true
+	case ClearStateProgram:
+		return BytesValue(t.ClearStateProgram), true
+	case RekeyTo:
+		return addressBytes(t.RekeyTo), true
+	case ConfigAsset:
+		return Uint64Value(uint64(t.ConfigAsset)), true
+	case ConfigAssetTotal:
+		return Uint64Value(t.AssetParams.Total), true
+	case ConfigAssetDecimals:
+		return Uint64Value(uint64(t.AssetParams.Decimals)), true
+	case ConfigAssetDefaultFrozen:
+		return vmBool(t.AssetParams.DefaultFrozen), true
+	case ConfigAssetUnitName:
+		return BytesValue([]byte(t.AssetParams.UnitName)), true
+	case ConfigAssetName:
+		return BytesValue([]byte(t.AssetParams.AssetName)), true
+	case ConfigAssetURL:
+		return BytesValue([]byte(t.AssetParams.URL)), true
+	case ConfigAssetMetadataHash:
+		return BytesValue(t.AssetParams.MetadataHash[:]), true
+	case ConfigAssetManager:
+		return addressBytes(t.AssetParams.Manager), true
+	case ConfigAssetReserve:
+		return addressBytes(t.AssetParams.Reserve), true
+	case ConfigAssetFreeze:
+		return addressBytes(t.AssetParams.Freeze), true
+	case ConfigAssetClawback:
+		return addressBytes(t.AssetParams.Clawback), true
+	case FreezeAsset:
+		return Uint64Value(uint64(t.FreezeAsset)), true
+	case FreezeAssetAccount:
+		return addressBytes(t.FreezeAccount), true
+	case FreezeAssetFrozen:
+		return vmBool(t.AssetFrozen), true
+	case NumAssets:
+		return Uint64Value(uint64(len(t.ForeignAssets))), true
+	case NumApplications:
+		return Uint64Value(uint64(len(t.ForeignApps))), true
+	case GlobalNumUint:
+		return Uint64Value(t.GlobalStateSchema.NumUint), true
+	case GlobalNumByteSlice:
+		return Uint64Value(t.GlobalStateSchema.NumByteSlice), true
+	case LocalNumUint:
+		return Uint64Value(t.LocalStateSchema.NumUint), true
+	case LocalNumByteSlice:
+		return Uint64Value(t.LocalStateSchema.NumByteSlice), true
+	case ExtraProgramPages:
+		return Uint64Value(uint64(t.ExtraProgramPages)), true
+	case Nonparticipation:
+		return vmBool(t.Nonparticipation), true
+	case StateProofPK:
+		return BytesValue(t.StateProofPK[:]), true
+	case NumApprovalProgramPages:
+		return Uint64Value(programPages(t.ApprovalProgram)), true
+	case NumClearStateProgramPages:
+		return Uint64Value(programPages(t.ClearStateProgram)), true
+	default:
+		// FirstValidTime and the apply data fields are not known before the group is evaluated
+		return VmValue{}, false
+	}
+}
+
+// txn returns the current transaction of the group
+func (v *Vm) txn() *types.Transaction {
+	if v.GroupIndex >= len(v.Group) {
+		return nil
+	}
+
+	return &v.Group[v.GroupIndex].Txn
+}
+
+// pushTxn pushes a field of a transaction of the group, or an input if the field or the group are not known
+func (b *VmBranch) pushTxn(gi VmValue, f TxnField, ai *VmValue) error {
+	spec, ok := txnFieldSpecByField(f)
+	if !ok {
+		panic("unknown field")
+	}
+
+	t := spec.Type().Vm()
+
+	if b.vm.Group == nil || !gi.Known() || (ai != nil && !ai.Known()) {
+		b.push(b.txnInput(gi, f, ai, t))
+		return nil
+	}
+
+	if gi.u() >= uint64(len(b.vm.Group)) {
+		return b.fail(errors.Errorf("txn index %d out of range (group size: %d)", gi.u(), len(b.vm.Group)))
+	}
+
+	txn := &b.vm.Group[gi.u()].Txn
+
+	var v VmValue
+	if ai != nil {
+		var err error
+		v, ok, err = txnArrayValue(txn, f, ai.u())
+		if err != nil {
+			return b.fail(err)
+		}
+	} else {
+		v, ok = txnValue(txn, gi.u(), f)
+	}
+
+	if !ok {
+		v = b.txnInput(gi, f, ai, t)
+	}
+
+	b.push(v)
+	return nil
+}
+
+// knowsApp returns whether the id of the current app is known - set with WithApp or defaulting to 0 when running concrete
+func (b *VmBranch) knowsApp() bool {
+	return b.vm.App != 0 || b.vm.Globals != nil || b.vm.Concrete
+}
+
+// pushGlobal pushes a global field, or an input if the globals are not known
+func (b *VmBranch) pushGlobal(f GlobalField) {
+	spec, ok := globalFieldSpecByField(f)
+	if !ok {
+		panic("unknown field")
+	}
+
+	// the fields that come from the protocol, the group or the vm options are known without the globals
+	switch {
+	case f == OpcodeBudget:
+		b.push(Uint64Value(uint64(b.Budget)))
+		return
+	case f == ZeroAddress:
+		b.push(addressBytes(types.ZeroAddress))
+		return
+	case f == GroupSize && b.vm.Group == nil:
+		b.push(vmRanged(b.input(VmTypeUint64), 1, maxTxGroupSize))
+		return
+	case f == GroupSize:
+		b.push(Uint64Value(uint64(len(b.vm.Group))))
+		return
+	case f == CurrentApplicationID && b.knowsApp():
+		b.push(Uint64Value(b.vm.App))
+		return
+	case f == CurrentApplicationAddress && b.knowsApp():
+		b.push(addressBytes(appAddress(b.vm.App)))
+		return
+	case f == GroupID && b.vm.Group != nil:
+		var id types.Digest
+		if len(b.vm.Group) > 0 {
+			id = b.vm.Group[0].Txn.Group
+		}
+		b.push(BytesValue(id[:]))
+		return
+	}
+
+	g := b.vm.Globals
+	if g == nil {
+		// all of the byte array globals are addresses or digests
+		v := b.input(spec.Type().Vm())
+		if v.Known() && v.T == VmTypeBytes {
+			v = BytesValue(make([]byte, 32))
+		}
+		b.push(v)
+		return
+	}
+
+	var v VmValue
+
+	switch f {
+	case MinTxnFee:
+		v = Uint64Value(g.MinTxnFee)
+	case MinBalance:
+		v = Uint64Value(g.MinBalance)
+	case MaxTxnLife:
+		v = Uint64Value(g.MaxTxnLife)
+	case LogicSigVersion:
+		v = Uint64Value(g.LogicSigVersion)
+	case Round:
+		v = Uint64Value(g.Round)
+	case LatestTimestamp:
+		v = Uint64Value(g.LatestTimestamp)
+	case CreatorAddress:
+		v = addressBytes(g.CreatorAddress)
+	case GroupID:
+		v = BytesValue(make([]byte, 32))
+	case CallerApplicationID:
+		v = Uint64Value(g.CallerApplicationID)
+	case CallerApplicationAddress:
+		addr := types.ZeroAddress
+		if g.CallerApplicationID != 0 {
+			addr = appAddress(g.CallerApplicationID)
+		}
+		v = addressBytes(addr)
+	default:
+		v = b.input(spec.Type().Vm())
+	}
+
+	b.push(v)
+}
+
+// pushArg pushes a logicsig argument, or an input if the arguments are not known
+func (b *VmBranch) pushArg(i VmValue) error {
+	if b.vm.Args == nil || !i.Known() {
+		v := b.input(VmTypeBytes)
+		if !v.Known() && i.Known() {
+			v.src = VmInput{Arg: true, Index: i.u()}
+		}
+
+		b.push(v)
+		return nil
+	}
+
+	if i.u() >= uint64(len(b.vm.Args)) {
+		return b.fail(errors.Errorf("invalid arg index %d (count: %d)", i.u(), len(b.vm.Args)))
+	}
+
+	b.push(BytesValue(b.vm.Args[i.u()]))
+	return nil
+}
+
+// prior returns the transaction of the group at the index if it is evaluated before the current one
+func (b *VmBranch) prior(op string, gi VmValue) (*types.Transaction, bool, error) {
+	if b.vm.Group == nil || !gi.Known() {
+		return nil, false, nil
+	}
+
+	if gi.u() >= uint64(b.vm.GroupIndex) {
+		return nil, false, b.fail(errors.Errorf("%s can't get a value of txn %d at or after the current one (%d)", op, gi.u(), b.vm.GroupIndex))
+	}
+
+	return &b.vm.Group[gi.u()].Txn, true, nil
+}
+
+// pushGroupScratch pushes a scratch value of an earlier transaction of the group - always an input as the other programs are not executed
+func (b *VmBranch) pushGroupScratch(op string, gi VmValue) error {
+	_, _, err := b.prior(op, gi)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
+
+	b.push(b.input(VmTypeAny))
+	b.Line++
+	return nil
+}
+
+// pushGroupAid pushes the id of the asset or app created by an earlier transaction of the group
+func (b *VmBranch) pushGroupAid(op string, gi VmValue) error {
+	t, ok, err := b.prior(op, gi)
+	if err != nil || b.Line == ExitLine {
+		return err
+	}
+
+	if ok {
+		creates := t.Type == types.AssetConfigTx && t.ConfigAsset == 0 || t.Type == types.ApplicationCallTx && t.ApplicationID == 0
+		if !creates {
+			return b.fail(errors.Errorf("%s can't get creatable id of txn %d that is not an asset or app creation", op, gi.u()))
+		}
+	}
+
+	b.push(b.input(VmTypeUint64))
+	b.Line++
+	return nil
+}
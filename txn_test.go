@@ -0,0 +1,164 @@
+package teal
+
+import (
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/types"
+)
+
+func TestGroupFields(t *testing.T) {
+	sender := types.Address{1}
+	receiver := types.Address{2}
+	app := appAddress(9)
+
+	group := []types.SignedTxn{
+		{Txn: types.Transaction{
+			Type:             types.PaymentTx,
+			Header:           types.Header{Sender: sender, Fee: 1000},
+			PaymentTxnFields: types.PaymentTxnFields{Receiver: receiver, Amount: 5000},
+		}},
+		{
+			Txn: types.Transaction{
+				Type:   types.ApplicationCallTx,
+				Header: types.Header{Sender: sender},
+				ApplicationFields: types.ApplicationFields{ApplicationCallTxnFields: types.ApplicationCallTxnFields{
+					ApplicationID:   9,
+					ApplicationArgs: [][]byte{[]byte("buy")},
+					Accounts:        []types.Address{receiver},
+					ForeignApps:     []types.AppIndex{11},
+				}},
+			},
+			Lsig: types.LogicSig{Args: [][]byte{[]byte("secret")}},
+		},
+	}
+
+	tests := []struct {
+		Src   string
+		Value VmValue
+	}{
+		{Src: "txn GroupIndex", Value: Uint64Value(1)},
+		{Src: "txn ApplicationID", Value: Uint64Value(9)},
+		{Src: "txna ApplicationArgs 0", Value: BytesValue([]byte("buy"))},
+		{Src: "txna Accounts 1", Value: BytesValue(receiver[:])},
+		{Src: "int 1\ntxnas Applications", Value: Uint64Value(11)},
+		{Src: "gtxn 0 Amount", Value: Uint64Value(5000)},
+		{Src: "gtxn 0 TypeEnum", Value: Uint64Value(1)},
+		{Src: "int 0\ngtxns Receiver", Value: BytesValue(receiver[:])},
+		{Src: "int 0\nint 0\ngtxnsas Accounts", Value: BytesValue(sender[:])},
+		{Src: "arg 0", Value: BytesValue([]byte("secret"))},
+		{Src: "global GroupSize", Value: Uint64Value(2)},
+		{Src: "global MinTxnFee", Value: Uint64Value(1000)},
+		{Src: "global CurrentApplicationID", Value: Uint64Value(9)},
+		{Src: "global CurrentApplicationAddress", Value: BytesValue(app[:])},
+	}
+
+	for _, test := range tests {
+		vm := NewVm(Process("#pragma version 8\n"+test.Src), WithConcrete(), WithGroup(group, 1), WithGlobals(DefaultGlobals()))
+		vm.Run()
+
+		// a byte array result fails the program
+		if (vm.Error != nil) != (test.Value.T == VmTypeBytes) {
+			t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			continue
+		}
+
+		items := vm.Branches[0].Stack.Items
+		if len(items) != 1 || !items[0].equal(test.Value) {
+			t.Errorf("unexpected stack for: %s: %v", test.Src, items)
+		}
+	}
+}
+
+func TestGroupErrors(t *testing.T) {
+	group := []types.SignedTxn{{Txn: types.Transaction{Type: types.ApplicationCallTx}}}
+
+	tests := []string{
+		"gtxn 1 Fee",
+		"txna ApplicationArgs 0",
+		"arg 1",
+		"gload 0 1",
+		"int 0\ngaids",
+	}
+
+	for _, src := range tests {
+		vm := NewVm(Process("#pragma version 8\n"+src), WithConcrete(), WithGroup(group, 0), WithArgs([]byte("a")))
+		vm.Run()
+
+		if vm.Error == nil {
+			t.Errorf("expected error for: %s", src)
+		}
+	}
+}
+
+func TestSymbolicGroupErrors(t *testing.T) {
+	group := []types.SignedTxn{{Txn: types.Transaction{Type: types.ApplicationCallTx}}}
+
+	tests := []string{
+		"gtxn 3 Fee",
+		"txna ApplicationArgs 2",
+		"gtxna 0 Accounts 2",
+		"int 3\ngtxns Fee",
+		"arg 2",
+		"arg_2",
+		"int 2\nargs",
+	}
+
+	for _, src := range tests {
+		vm := NewVm(Process("#pragma version 8\n"+src+"\nint 1\nreturn"), WithGroup(group, 0), WithArgs([]byte("a")))
+		vm.Run()
+
+		if len(vm.Branches) != 1 {
+			t.Errorf("unexpected number of branches for: %s: %d", src, len(vm.Branches))
+			continue
+		}
+
+		b := vm.Branches[0]
+		if b.Outcome != VmErr || b.Err == nil || b.Line != ExitLine {
+			t.Errorf("expected the branch to fail for: %s: %s (%v)", src, b.Outcome, b.Err)
+		}
+
+		if vm.Steps > 3 {
+			t.Errorf("expected the branch to end for: %s: %d steps", src, vm.Steps)
+		}
+	}
+}
+
+func TestGlobalDefaults(t *testing.T) {
+	app := appAddress(5)
+
+	tests := []struct {
+		Src      string
+		Concrete bool
+		Value    VmValue
+	}{
+		{Src: "global ZeroAddress", Concrete: true, Value: BytesValue(make([]byte, 32))},
+		{Src: "global ZeroAddress", Value: BytesValue(make([]byte, 32))},
+		{Src: "global CurrentApplicationID", Concrete: true, Value: Uint64Value(5)},
+		{Src: "global CurrentApplicationID", Value: Uint64Value(5)},
+		{Src: "global CurrentApplicationAddress", Concrete: true, Value: BytesValue(app[:])},
+		{Src: "global CreatorAddress\nlen", Concrete: true, Value: Uint64Value(32)},
+		{Src: "txn Sender\nlen", Concrete: true, Value: Uint64Value(32)},
+		{Src: "txn Receiver\nlen", Concrete: true, Value: Uint64Value(32)},
+	}
+
+	for _, test := range tests {
+		opts := []VmOption{WithApp(5)}
+		if test.Concrete {
+			opts = append(opts, WithConcrete())
+		}
+
+		vm := NewVm(Process("#pragma version 8\n"+test.Src), opts...)
+		vm.Run()
+
+		// a byte array result fails a concrete program
+		if (vm.Error != nil) != (test.Concrete && test.Value.T == VmTypeBytes) {
+			t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			continue
+		}
+
+		items := vm.Branches[0].Stack.Items
+		if len(items) != 1 || !items[0].equal(test.Value) {
+			t.Errorf("unexpected stack for: %s: %v", test.Src, items)
+		}
+	}
+}
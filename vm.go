@@ -1,10 +1,14 @@
 package teal
 
 import (
+	"bytes"
+	"crypto/sha512"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
+	"github.com/algorand/go-algorand-sdk/types"
 	"github.com/pkg/errors"
 )
 
@@ -14,11 +18,14 @@ const (
 	ExitName = "(exited)"
 )
 
+const MaxStringSize = 4096
+
 type VmFrame struct {
 	Return     int
 	NumArgs    uint8
 	NumReturns uint8
-	p          uint8
+	p          int
+	proto      bool
 	Name       string
 }
 
@@ -82,13 +89,16 @@ type NamedExpr interface {
 }
 
 type vmOpSource struct {
-	e    NamedExpr
+	e    Op
 	args []vmSource
 	res  string
 }
 
 func (s vmOpSource) String() string {
-	res := s.e.Name()
+	res := s.e.String()
+	if ne, ok := s.e.(NamedExpr); ok {
+		res = ne.Name()
+	}
 	if len(s.args) > 0 {
 		var argss []string
 		for _, arg := range s.args {
@@ -104,6 +114,176 @@ func (c vmByteConst) String() string {
 	return Bytes{Value: c.v}.String()
 }
 
+// vmRangeSource is an unknown uint64 known to be within the inclusive bounds
+type vmRangeSource struct {
+	src vmSource
+	min uint64
+	max uint64
+}
+
+func (s vmRangeSource) String() string {
+	if s.src != nil {
+		return s.src.String()
+	}
+
+	return fmt.Sprintf("%d..%d", s.min, s.max)
+}
+
+// vmRanged narrows the uint64 value to the bounds, the value becomes known if the bounds are equal
+func vmRanged(v VmValue, min uint64, max uint64) VmValue {
+	if v.Known() {
+		return v
+	}
+
+	lo, hi := v.bounds()
+	if min < lo {
+		min = lo
+	}
+	if max > hi {
+		max = hi
+	}
+
+	if min > max {
+		return v
+	}
+
+	if min == max {
+		return Uint64Value(min)
+	}
+
+	if min == 0 && max == math.MaxUint64 {
+		return v
+	}
+
+	src := v.src
+	if r, ok := src.(vmRangeSource); ok {
+		src = r.src
+	}
+
+	return VmValue{T: VmTypeUint64, src: vmRangeSource{src: src, min: min, max: max}}
+}
+
+// bounds returns the inclusive bounds of a uint64 value
+func (v VmValue) bounds() (uint64, uint64) {
+	switch src := v.src.(type) {
+	case vmUint64Const:
+		return src.v, src.v
+	case vmRangeSource:
+		return src.min, src.max
+	default:
+		return 0, math.MaxUint64
+	}
+}
+
+// truth returns whether the uint64 value is non-zero if it is known from its bounds
+func (v VmValue) truth() (bool, bool) {
+	lo, hi := v.bounds()
+
+	switch {
+	case lo > 0:
+		return true, true
+	case hi == 0:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// Uint64Value returns a known uint64 value
+func Uint64Value(v uint64) VmValue {
+	return VmValue{T: VmTypeUint64, src: vmUint64Const{v: v}}
+}
+
+// BytesValue returns a known byte array value
+func BytesValue(v []byte) VmValue {
+	return VmValue{T: VmTypeBytes, src: vmByteConst{v: v}}
+}
+
+func vmBool(v bool) VmValue {
+	if v {
+		return Uint64Value(1)
+	}
+	return Uint64Value(0)
+}
+
+func vmZero(t VmDataType) VmValue {
+	switch t {
+	case VmTypeBytes:
+		return BytesValue([]byte{})
+	default:
+		return Uint64Value(0)
+	}
+}
+
+func vmTypeOf(c byte) VmDataType {
+	switch c {
+	case 'U':
+		return VmTypeUint64
+	case 'B':
+		return VmTypeBytes
+	default:
+		return VmTypeAny
+	}
+}
+
+// Uint64 returns the value if it is a known uint64
+func (v VmValue) Uint64() (uint64, bool) {
+	switch src := v.src.(type) {
+	case vmUint64Const:
+		return src.v, true
+	default:
+		return 0, false
+	}
+}
+
+// Bytes returns the value if it is a known byte array
+func (v VmValue) Bytes() ([]byte, bool) {
+	switch src := v.src.(type) {
+	case vmByteConst:
+		return src.v, true
+	case vmSignatureValue:
+		sel, err := methodSelector(src.v)
+		if err != nil {
+			return nil, false
+		}
+		return sel, true
+	default:
+		return nil, false
+	}
+}
+
+func (v VmValue) Known() bool {
+	if _, ok := v.Uint64(); ok {
+		return true
+	}
+
+	_, ok := v.Bytes()
+	return ok
+}
+
+func (v VmValue) equal(o VmValue) bool {
+	if v.T != o.T {
+		return false
+	}
+
+	switch v.T {
+	case VmTypeBytes:
+		return bytes.Equal(v.b(), o.b())
+	default:
+		return v.u() == o.u()
+	}
+}
+
+func (v VmValue) u() uint64 {
+	r, _ := v.Uint64()
+	return r
+}
+
+func (v VmValue) b() []byte {
+	r, _ := v.Bytes()
+	return r
+}
+
 func (v VmValue) String() string {
 	res := v.T.String()
 	if v.src != nil {
@@ -144,6 +324,11 @@ type costlyOp interface {
 	Cost(b *VmBranch) []int
 }
 
+// rangeOp narrows the uint64 result of an op whose args are not all known
+type rangeOp interface {
+	Bounds(vs []VmValue) (uint64, uint64)
+}
+
 func (t StackType) Vm() VmDataType {
 	switch t {
 	case StackAny:
@@ -192,22 +377,223 @@ func (b *VmBranch) push(t VmValue) {
 	b.Stack.Items = append(b.Stack.Items, t)
 }
 
-func (b *VmBranch) prepare(a uint8, r uint8) {
+func (b *VmBranch) prepare(a uint8, r uint8) error {
+	if len(b.Frames) == 0 {
+		return b.fail(errors.New("proto was executed without a callsub"))
+	}
+
 	f := b.Frames[len(b.Frames)-1]
+	if f.proto {
+		return b.fail(errors.New("proto was executed twice"))
+	}
+
+	if f.p < int(a) {
+		return b.fail(errors.Errorf("callsub to proto that requires %d args with stack height %d", a, f.p))
+	}
+
 	f.NumArgs = a
 	f.NumReturns = r
+	f.proto = true
 	b.Frames[len(b.Frames)-1] = f
+
+	return nil
+}
+
+// frame returns the index of the stack item at the offset from the frame of the current proto subroutine
+func (b *VmBranch) frame(op string, offset int8) (int, error) {
+	if len(b.Frames) == 0 || !b.Frames[len(b.Frames)-1].proto {
+		return 0, b.fail(errors.Errorf("%s with empty callstack", op))
+	}
+
+	f := b.Frames[len(b.Frames)-1]
+
+	if int(offset) < -int(f.NumArgs) {
+		return 0, b.fail(errors.Errorf("%s %d in sub with %d args", op, offset, f.NumArgs))
+	}
+
+	i := f.p + int(offset)
+	if i >= len(b.Stack.Items) {
+		return 0, b.fail(errors.Errorf("%s above stack", op))
+	}
+
+	return i, nil
+}
+
+// eval pops the args of the given types (the last one from the top of the stack) and pushes
+// the results computed by f if all of the args are known or values of the return types otherwise
+func (b *VmBranch) eval(e Op, args string, rets string, f func(vs []VmValue) ([]VmValue, error)) error {
+	vs := make([]VmValue, len(args))
+	for i := len(args) - 1; i >= 0; i-- {
+		vs[i] = b.pop(vmTypeOf(args[i]))
+	}
+
+	known := true
+	for _, v := range vs {
+		if !v.Known() {
+			known = false
+			break
+		}
+	}
+
+	if !known {
+		for i := 0; i < len(rets); i++ {
+			v := VmValue{T: vmTypeOf(rets[i])}
+			if len(rets) == 1 {
+				var srcs []vmSource
+				for _, a := range vs {
+					srcs = append(srcs, a)
+				}
+				v.src = vmOpSource{e: e, args: srcs}
+			}
+			if ro, ok := e.(rangeOp); ok && len(rets) == 1 && v.T == VmTypeUint64 {
+				min, max := ro.Bounds(vs)
+				v = vmRanged(v, min, max)
+			}
+			b.push(v)
+		}
+
+		b.Line++
+		return nil
+	}
+
+	res, err := f(vs)
+	if err != nil {
+		return b.fail(errors.Wrap(err, e.String()))
+	}
+
+	for _, r := range res {
+		if bs, ok := r.Bytes(); ok && len(bs) > MaxStringSize {
+			return b.fail(errors.Errorf("%s produced a too big (%d) byte-array", e.String(), len(bs)))
+		}
+	}
+
+	for _, r := range res {
+		b.push(r)
+	}
+
+	b.Line++
+	return nil
+}
+
+// input returns a value that comes from outside of the program - zero value of the type when running concrete
+func (b *VmBranch) input(t VmDataType) VmValue {
+	if b.vm.Concrete {
+		return vmZero(t)
+	}
+
+	return VmValue{T: t}
+}
+
+// fail stops the program with an error when running concrete or ends the branch otherwise
+func (b *VmBranch) fail(err error) error {
+	if b.vm.Concrete {
+		b.Outcome = VmErr
+		b.Err = err
+		return err
+	}
+
+	b.abort(err)
+	return nil
+}
+
+// checkLimits ensures the stack is within the avm limits after executing an op
+func (b *VmBranch) checkLimits() error {
+	if len(b.Stack.Items) > maxStackDepth {
+		return b.fail(errors.Errorf("stack overflow: %d items exceed the limit of %d", len(b.Stack.Items), maxStackDepth))
+	}
+
+	for _, v := range b.Stack.Items {
+		if bs, ok := v.Bytes(); ok && len(bs) > MaxStringSize {
+			return b.fail(errors.Errorf("byte array of %d bytes exceeds the limit of %d", len(bs), MaxStringSize))
+		}
+	}
+
+	return nil
 }
 
-func (b *VmBranch) replace(n uint8, v VmValue) {
+func (b *VmBranch) replace(n int, v VmValue) {
 	b.Stack.Items[n] = v
 }
 
-func (b *VmBranch) store(index VmValue, v VmValue) {
-	switch src := index.src.(type) {
-	case vmUint64Const:
-		b.vm.Scratch.Items[src.v] = v
+func (b *VmBranch) store(i uint64, v VmValue) error {
+	if i >= uint64(len(b.Scratch.Items)) {
+		return errors.Errorf("invalid scratch index: %d", i)
+	}
+
+	b.Scratch.Items[i] = v
+	return nil
+}
+
+func (b *VmBranch) load(i uint64) (VmValue, error) {
+	if i >= uint64(len(b.Scratch.Items)) {
+		return VmValue{}, errors.Errorf("invalid scratch index: %d", i)
 	}
+
+	// unset slots are uint64 zero
+	v := b.Scratch.Items[i]
+	if v.T == VmTypeNone {
+		return Uint64Value(0), nil
+	}
+
+	return v, nil
+}
+
+func (b *VmBranch) pushIntc(i uint8) error {
+	if int(i) >= len(b.intc) {
+		return b.fail(errors.Errorf("intc %d beyond %d constants", i, len(b.intc)))
+	}
+
+	b.push(Uint64Value(b.intc[i]))
+	b.Line++
+	return nil
+}
+
+func (b *VmBranch) pushBytec(i uint8) error {
+	if int(i) >= len(b.bytec) {
+		return b.fail(errors.Errorf("bytec %d beyond %d constants", i, len(b.bytec)))
+	}
+
+	b.push(BytesValue(b.bytec[i]))
+	b.Line++
+	return nil
+}
+
+// follow continues the branch at the feasible targets and forks it for the others,
+// cond adds the constraints of taking the target at the index or of falling through for -1
+func (b *VmBranch) follow(targets []*LabelExpr, feasible []bool, next bool, cond func(b *VmBranch, i int)) {
+	first := -1
+
+	for i, t := range targets {
+		if !feasible[i] {
+			continue
+		}
+
+		if !next && first == -1 {
+			first = i
+			continue
+		}
+
+		if nb := b.fork(t.Name); nb != nil {
+			cond(nb, i)
+		}
+	}
+
+	if first != -1 {
+		cond(b, first)
+		b.jump(targets[first].Name)
+	} else {
+		cond(b, -1)
+		b.Line++
+	}
+}
+
+// unsupported fails the program in concrete mode for ops that can't compute their results
+func (b *VmBranch) unsupported(e Op) error {
+	if b.vm.Concrete {
+		return errors.Errorf("%s is not supported in concrete mode", e)
+	}
+
+	return nil
 }
 
 func (b *VmBranch) peek(index int) VmValue {
@@ -250,38 +636,204 @@ type VmBranch struct {
 
 	Stack *vmStack
 
+	Scratch *VmScratch
+
 	Frames []VmFrame
 
+	state *vmState
+
+	Inner   [][]*VmInnerTxn
+	pending []*VmInnerTxn
+
+	Logs    []VmValue
+	Result  VmValue
+	Outcome VmOutcome
+	Err     error
+
 	Budget int
+	Cost   int
+
+	MergedWith int
+	visits     map[int]int
+
+	Constraints []VmConstraint
+
+	intc  []uint64
+	bytec [][]byte
 
 	Name  string
 	Trace []Op
+
+	// TraceLines are the lines of the executed ops, the first traced of them were executed before the branch was forked
+	TraceLines []int
+	traced     int
 }
 
-func (b *VmBranch) fork(target string) {
+func (b *VmBranch) clone() *VmBranch {
 	nb := &VmBranch{
-		Id:     b.vm.Id,
-		vm:     b.vm,
-		Line:   b.vm.find(target),
-		Stack:  b.Stack.clone(),
-		Budget: b.Budget,
-		Name:   target,
-		Trace:  append([]Op{}, b.Trace...),
+		Id:          b.vm.Id,
+		vm:          b.vm,
+		Line:        b.Line,
+		Stack:       b.Stack.clone(),
+		Scratch:     b.Scratch.clone(),
+		Frames:      append([]VmFrame{}, b.Frames...),
+		state:       b.state.clone(),
+		Inner:       append([][]*VmInnerTxn{}, b.Inner...),
+		Logs:        append([]VmValue{}, b.Logs...),
+		Budget:      b.Budget,
+		Cost:        b.Cost,
+		visits:      map[int]int{},
+		Name:        b.Name,
+		Constraints: append([]VmConstraint{}, b.Constraints...),
+		Trace:       append([]Op{}, b.Trace...),
+		TraceLines:  append([]int{}, b.TraceLines...),
+		traced:      len(b.TraceLines),
+		intc:        b.intc,
+		bytec:       b.bytec,
+	}
+
+	if b.pending != nil {
+		nb.pending = make([]*VmInnerTxn, len(b.pending))
+		for i, t := range b.pending {
+			nb.pending[i] = t.clone()
+		}
+	}
+
+	for ln, n := range b.visits {
+		nb.visits[ln] = n
 	}
 
 	b.vm.Id++
 
+	return nb
+}
+
+// Ledger returns the ledger as seen by the branch including its own changes
+func (b *VmBranch) Ledger() Ledger {
+	if b.state == nil {
+		return nil
+	}
+
+	return b.state
+}
+
+// account resolves an account reference, uints are indices of the accounts of the current transaction
+func (b *VmBranch) account(v VmValue) (types.Address, error) {
+	var addr types.Address
+
+	switch v.T {
+	case VmTypeBytes:
+		bs := v.b()
+		if len(bs) != len(addr) {
+			return addr, errors.Errorf("invalid account: %s", v)
+		}
+		copy(addr[:], bs)
+		return addr, nil
+	default:
+		t := b.vm.txn()
+		if t == nil {
+			return addr, errors.Errorf("unavailable account: %s", v)
+		}
+
+		i := v.u()
+		switch {
+		case i == 0:
+			return t.Sender, nil
+		case i <= uint64(len(t.Accounts)):
+			return t.Accounts[i-1], nil
+		default:
+			return addr, errors.Errorf("invalid account index %d (count: %d)", i, len(t.Accounts))
+		}
+	}
+}
+
+// app resolves an application reference, 0 is the current application and ids up to the number of
+// the foreign apps of the current transaction are their indices
+func (b *VmBranch) app(v VmValue) uint64 {
+	id := v.u()
+	if id == 0 {
+		return b.vm.App
+	}
+
+	if t := b.vm.txn(); t != nil && id <= uint64(len(t.ForeignApps)) {
+		return uint64(t.ForeignApps[id-1])
+	}
+
+	return id
+}
+
+// asset resolves an asset reference, ids lower than the number of the foreign assets of the current
+// transaction are their indices
+func (b *VmBranch) asset(v VmValue) uint64 {
+	id := v.u()
+
+	if t := b.vm.txn(); t != nil && id < uint64(len(t.ForeignAssets)) {
+		return uint64(t.ForeignAssets[id])
+	}
+
+	return id
+}
+
+// ledger returns the branch state if the ledger is available and all of the values are known
+func (b *VmBranch) ledger(vs ...VmValue) (*vmState, bool) {
+	if b.state == nil {
+		return nil, false
+	}
+
+	for _, v := range vs {
+		if !v.Known() {
+			return nil, false
+		}
+	}
+
+	return b.state, true
+}
+
+// pushEx pushes the value and 1 if it exists or the zero value of the type and 0 otherwise
+func (b *VmBranch) pushEx(v VmValue, ok bool, t VmDataType) {
+	if ok {
+		b.push(v)
+	} else {
+		b.push(vmZero(t))
+	}
+
+	b.push(vmBool(ok))
+}
+
+func (b *VmBranch) fork(target string) *VmBranch {
+	if !b.vm.canBranch(1) {
+		b.vm.cut(b, target, fmt.Sprintf("branch limit of %d reached", b.vm.MaxBranches))
+		return nil
+	}
+
+	nb := b.clone()
+	nb.Line = b.vm.find(target)
+	nb.Name = target
+	nb.arrive(b.Line)
+
 	nb.skipNops()
 	b.vm.Branches = append(b.vm.Branches, nb)
+
+	nb.merge()
+
+	return nb
 }
 
 func (b *VmBranch) jump(target string) {
+	from := b.Line
 	b.Line = b.vm.find(target)
+	b.arrive(from)
 }
 
-func (b *VmBranch) call(target string) {
-	b.Frames = append(b.Frames, VmFrame{Return: b.Line, NumArgs: 0, NumReturns: 0, p: uint8(len(b.Stack.Items)), Name: b.Name})
+func (b *VmBranch) call(target string) error {
+	if len(b.Frames) >= maxCallDepth {
+		return b.fail(errors.Errorf("callsub depth limit of %d exceeded", maxCallDepth))
+	}
+
+	b.Frames = append(b.Frames, VmFrame{Return: b.Line, NumArgs: 0, NumReturns: 0, p: len(b.Stack.Items), Name: b.Name})
 	b.Line = b.vm.find(target)
+
+	return nil
 }
 
 func (b *VmBranch) exit() {
@@ -289,10 +841,127 @@ func (b *VmBranch) exit() {
 	b.Name = ExitName
 }
 
+// finish ends the branch with the outcome decided by the value left on top of the stack
+func (b *VmBranch) finish(v VmValue) {
+	b.Result = v
+
+	switch v.T {
+	case VmTypeBytes:
+		b.Outcome = VmErr
+		b.Err = errors.New("program ended with a byte array on the stack")
+		b.stop(b.Err)
+	case VmTypeUint64:
+		if r, ok := v.Uint64(); ok {
+			if r != 0 {
+				b.Outcome = VmPass
+			} else {
+				b.Outcome = VmReject
+			}
+		} else {
+			b.Outcome = VmUndecided
+		}
+	default:
+		b.Outcome = VmUndecided
+	}
+
+	b.exit()
+}
+
+// finishEnd ends the branch that reached the end of the program
+func (b *VmBranch) finishEnd() {
+	if len(b.Stack.Items) != 1 {
+		err := errors.Errorf("stack length is %d instead of 1 at the end of the program", len(b.Stack.Items))
+		b.stop(err)
+		b.abort(err)
+		return
+	}
+
+	b.finish(b.Stack.Items[0])
+}
+
+// stop records the error that ends a concrete run at the current line of the branch
+func (b *VmBranch) stop(err error) {
+	if !b.vm.Concrete {
+		return
+	}
+
+	line := b.Line
+	if n := len(b.vm.Process.Listing); line >= n {
+		line = n - 1
+	}
+
+	b.vm.Error = err
+	b.vm.ErrorLine = line
+}
+
+// abort ends the branch with an error outcome
+func (b *VmBranch) abort(err error) {
+	b.Outcome = VmErr
+	b.Err = err
+	b.exit()
+}
+
+// ReturnValue returns the ARC-4 return value - the last log prefixed with 151f7c75
+func (b *VmBranch) ReturnValue() (VmValue, bool) {
+	if len(b.Logs) == 0 {
+		return VmValue{}, false
+	}
+
+	v := b.Logs[len(b.Logs)-1]
+
+	bs, ok := v.Bytes()
+	if !ok || len(bs) < len(arc4ReturnPrefix) || !bytes.Equal(bs[:len(arc4ReturnPrefix)], arc4ReturnPrefix) {
+		return VmValue{}, false
+	}
+
+	return BytesValue(bs[len(arc4ReturnPrefix):]), true
+}
+
+type VmOutcome int
+
+const (
+	VmRunning VmOutcome = iota
+	VmPass
+	VmReject
+	VmErr
+	VmUndecided
+	VmMerged
+	VmCut
+)
+
+func (o VmOutcome) String() string {
+	switch o {
+	case VmRunning:
+		return "running"
+	case VmPass:
+		return "pass"
+	case VmReject:
+		return "reject"
+	case VmErr:
+		return "err"
+	case VmUndecided:
+		return "undecided"
+	case VmMerged:
+		return "merged"
+	case VmCut:
+		return "cut"
+	default:
+		return "(unknown)"
+	}
+}
+
+var arc4ReturnPrefix = []byte{0x15, 0x1f, 0x7c, 0x75}
+
 type VmScratch struct {
 	Items [256]VmValue
 }
 
+func (s *VmScratch) clone() *VmScratch {
+	return &VmScratch{
+		Items: s.Items,
+	}
+}
+
 type VmBreakpoint struct {
 	Line int
 }
@@ -303,8 +972,6 @@ type Vm struct {
 	Process *ProcessResult
 	syms    map[string]int
 
-	Scratch VmScratch
-
 	Branches []*VmBranch
 	Branch   *VmBranch
 	Current  int
@@ -312,9 +979,138 @@ type Vm struct {
 	Breakpoints []VmBreakpoint
 	Triggered   map[int][]int
 
-	Trace string
+	// Trace are the steps recorded with WithTrace
+	Trace   []VmTraceStep
+	tracing bool
+
+	Concrete bool
+	Mode     ProgramMode
+
+	Ledger Ledger
+	App    uint64
+
+	Group      []types.SignedTxn
+	GroupIndex int
+	Args       [][]byte
+	Globals    *VmGlobals
+
+	MaxBranches int
+	MaxSteps    int
+	MaxUnroll   int
+
+	Steps    int
+	Cuts     []VmCutPath
+	Failures []VmFailure
+
+	states map[[32]byte]int
+
+	// Error is the failure that stopped the run, ErrorLine is the line it happened at
+	Error     any
+	ErrorLine int
+
+	program []byte
+}
+
+type VmOption func(v *Vm)
+
+// WithConcrete makes the vm compute actual values, take the branches the values lead to and stop on
+// any failure the same way the AVM does. Values coming from outside of the program default to zero values.
+func WithConcrete() VmOption {
+	return func(v *Vm) {
+		v.Concrete = true
+	}
+}
+
+// WithMode sets the mode the program is executed in, it defaults to the mode of the processed program
+func WithMode(m ProgramMode) VmOption {
+	return func(v *Vm) {
+		v.Mode = m
+	}
+}
+
+// WithLedger makes the state access ops read the ledger, changes are kept by each branch separately
+func WithLedger(l Ledger) VmOption {
+	return func(v *Vm) {
+		v.Ledger = l
+	}
+}
+
+// WithApp sets the id of the application the program is executed for
+func WithApp(id uint64) VmOption {
+	return func(v *Vm) {
+		v.App = id
+	}
+}
+
+// WithGroup sets the transaction group and the index of the transaction the program is executed for,
+// the logicsig args and the app id default to the ones of that transaction
+func WithGroup(txns []types.SignedTxn, index int) VmOption {
+	return func(v *Vm) {
+		v.Group = txns
+		v.GroupIndex = index
+	}
+}
+
+// WithArgs sets the logicsig args
+func WithArgs(args ...[]byte) VmOption {
+	return func(v *Vm) {
+		v.Args = args
+	}
+}
+
+// WithGlobals sets the values of the global fields
+func WithGlobals(g VmGlobals) VmOption {
+	return func(v *Vm) {
+		v.Globals = &g
+	}
+}
+
+// budget returns the initial opcode budget - the consensus pools it across the app calls of the group since v4
+// and across the logicsigs of the group since v9, regardless of the versions of the programs
+func (v *Vm) budget() int {
+	consensus := DefaultGlobals().LogicSigVersion
+	if v.Globals != nil && v.Globals.LogicSigVersion != 0 {
+		consensus = v.Globals.LogicSigVersion
+	}
+
+	n := 1
+
+	switch v.Mode {
+	case ModeSig:
+		if consensus >= logicSigCostPoolingVersion && len(v.Group) > 1 {
+			n = len(v.Group)
+		}
+
+		return n * maxLogicSigCost
+	default:
+		if consensus >= appCostPoolingVersion {
+			calls := 0
+			for _, stx := range v.Group {
+				if stx.Txn.Type == types.ApplicationCallTx {
+					calls++
+				}
+			}
+
+			if calls > n {
+				n = calls
+			}
+		}
+
+		return n * maxAppProgramCost
+	}
+}
+
+func (v *Vm) programHash() ([]byte, error) {
+	if v.program == nil {
+		prog, err := v.Process.Listing.Assemble(v.Process.Version)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to assemble program")
+		}
+		v.program = prog
+	}
 
-	Error any
+	h := sha512.Sum512_256(append([]byte("Program"), v.program...))
+	return h[:], nil
 }
 
 func (v *Vm) find(target string) int {
@@ -334,7 +1130,7 @@ func (v *Vm) updateBreakpoints(br *VmBranch) {
 	}
 }
 
-func NewVm(res *ProcessResult) *Vm {
+func NewVm(res *ProcessResult, opts ...VmOption) *Vm {
 	syms := map[string]int{}
 
 	for i, op := range res.Listing {
@@ -345,17 +1141,42 @@ func NewVm(res *ProcessResult) *Vm {
 	}
 
 	v := &Vm{
-		Process:   res,
-		Triggered: map[int][]int{},
-		syms:      syms,
+		Process:     res,
+		Mode:        res.Mode,
+		Triggered:   map[int][]int{},
+		MaxBranches: DefaultMaxBranches,
+		MaxSteps:    DefaultMaxSteps,
+		MaxUnroll:   DefaultMaxUnroll,
+		syms:        syms,
+		states:      map[[32]byte]int{},
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	if t := v.txn(); t != nil {
+		if v.Args == nil {
+			v.Args = v.Group[v.GroupIndex].Lsig.Args
+		}
+
+		if v.App == 0 {
+			v.App = uint64(t.ApplicationID)
+		}
 	}
 
 	b := &VmBranch{
-		Id:     v.Id,
-		vm:     v,
-		Stack:  &vmStack{},
-		Budget: 700,
-		Name:   MainName,
+		Id:      v.Id,
+		vm:      v,
+		Stack:   &vmStack{},
+		Scratch: &VmScratch{},
+		Budget:  v.budget(),
+		visits:  map[int]int{},
+		Name:    MainName,
+	}
+
+	if v.Ledger != nil {
+		b.state = newVmState(v.Ledger)
 	}
 
 	v.Id++
@@ -389,7 +1210,7 @@ func (v *Vm) skipNops() {
 			return
 		}
 
-		b.exit()
+		b.finishEnd()
 	}
 
 	v.Branch = nil
@@ -433,6 +1254,20 @@ func (v *Vm) Step() {
 		case nil:
 		default:
 			v.Error = e
+
+			if b := v.Branch; b != nil {
+				v.ErrorLine = b.Line
+
+				if b.Outcome == VmRunning {
+					err, ok := e.(error)
+					if !ok {
+						err = errors.Errorf("%v", e)
+					}
+
+					b.Outcome = VmErr
+					b.Err = err
+				}
+			}
 		}
 	}()
 
@@ -445,6 +1280,13 @@ func (v *Vm) Step() {
 		return
 	}
 
+	if v.MaxSteps > 0 && v.Steps >= v.MaxSteps {
+		v.cutAll(fmt.Sprintf("step limit of %d reached", v.MaxSteps))
+		return
+	}
+
+	v.Steps++
+
 	if b := v.Branch; b != nil {
 		op := v.Process.Listing[b.Line]
 
@@ -459,40 +1301,88 @@ func (v *Vm) Step() {
 			costs = []int{1}
 		}
 
-		cb := b
-
-		for _, cost := range costs {
-			if cb == nil {
-				cb := &VmBranch{
-					Id:     b.vm.Id,
-					vm:     b.vm,
-					Line:   b.Line,
-					Stack:  b.Stack.clone(),
-					Budget: b.Budget,
-					Name:   b.Name,
-					Trace:  append([]Op{}, b.Trace...),
+		if len(costs) > 1 && !v.canBranch(len(costs)-1) {
+			max := costs[0]
+			for _, c := range costs[1:] {
+				if c > max {
+					max = c
 				}
+			}
+			costs = []int{max}
+
+			v.cut(b, "", fmt.Sprintf("branch limit of %d reached, assuming the max cost of %s", v.MaxBranches, op))
+		}
+
+		var cbs []*VmBranch
+		for range costs[1:] {
+			cb := b.clone()
+			b.vm.Branches = append(b.vm.Branches, cb)
+			cbs = append(cbs, cb)
+		}
 
-				b.vm.Id++
-				b.vm.Branches = append(b.vm.Branches, cb)
+		for i, cost := range costs {
+			cb := b
+			if i > 0 {
+				cb = cbs[i-1]
 			}
 
+			cb.Cost += cost
+
 			if cb.Budget >= cost {
 				cb.Budget -= cost
 				cb.Trace = append(cb.Trace, op)
+				cb.TraceLines = append(cb.TraceLines, cb.Line)
+
+				var step *VmTraceStep
+				var scratch [256]VmValue
+				if v.tracing {
+					step = &VmTraceStep{
+						Branch:      cb.Id,
+						Line:        cb.Line + 1,
+						Op:          op.String(),
+						StackBefore: traceStack(cb.Stack.Items),
+						Cost:        cost,
+						Budget:      cb.Budget,
+					}
+					scratch = cb.Scratch.Items
+				}
 
 				switch op := op.(type) {
 				case vmOp:
-					op.Execute(cb)
+					err := op.Execute(cb)
+					if err != nil {
+						panic(err)
+					}
 				default:
 					cb.Line++
 				}
 
+				if cb.Line != ExitLine {
+					err := cb.checkLimits()
+					if err != nil {
+						panic(err)
+					}
+				}
+
+				if step != nil {
+					step.StackAfter = traceStack(cb.Stack.Items)
+					step.ScratchChanges = traceScratch(&scratch, &cb.Scratch.Items)
+					v.Trace = append(v.Trace, *step)
+				}
+
 				cb.skipNops()
+
+				if _, ok := op.(Branch); ok {
+					cb.merge()
+				}
+
 				v.skipNops()
 				v.updateBreakpoints(cb)
 			} else {
-				cb.exit()
+				err := cb.fail(errors.Errorf("dynamic cost budget exceeded, executing %s: local program cost was %d", op, cb.Cost))
+				if err != nil {
+					panic(err)
+				}
 			}
 		}
 	}
@@ -1,6 +1,12 @@
 package teal
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/algorand/go-algorand-sdk/types"
+)
 
 func TestCostly(t *testing.T) {
 	res := Process(`#pragma version 7
@@ -46,3 +52,552 @@ func BenchmarkInfiniteLoop(b *testing.B) {
 		vm.Run()
 	}
 }
+
+func runConcrete(src string) *Vm {
+	vm := NewVm(Process(src), WithConcrete())
+	vm.Run()
+	return vm
+}
+
+func TestConcreteValues(t *testing.T) {
+	tests := []struct {
+		Src   string
+		Stack []VmValue
+	}{
+		{Src: "int 2\nint 3\n+\nint 4\n*", Stack: []VmValue{Uint64Value(20)}},
+		{Src: "int 7\nint 2\n/\nint 7\nint 2\n%", Stack: []VmValue{Uint64Value(3), Uint64Value(1)}},
+		{Src: "int 0xffffffffffffffff\nint 2\nmulw", Stack: []VmValue{Uint64Value(1), Uint64Value(0xfffffffffffffffe)}},
+		{Src: "int 0xffffffffffffffff\nint 1\naddw", Stack: []VmValue{Uint64Value(1), Uint64Value(0)}},
+		{Src: "byte \"ab\"\nbyte \"cd\"\nconcat\nextract 1 2", Stack: []VmValue{BytesValue([]byte("bc"))}},
+		{Src: "int 258\nitob\nbtoi", Stack: []VmValue{Uint64Value(258)}},
+		{Src: "byte 0x0f\nbyte 0xf000\nb^", Stack: []VmValue{BytesValue([]byte{0xf0, 0x0f})}},
+		{Src: "int 2\nint 64\nexpw", Stack: []VmValue{Uint64Value(1), Uint64Value(0)}},
+		{Src: "byte 0x80\nint 0\ngetbit\nint 0\nint 3\nint 1\nsetbit", Stack: []VmValue{Uint64Value(1), Uint64Value(8)}},
+		{Src: "byte \"abc\"\nsha256\nlen", Stack: []VmValue{Uint64Value(32)}},
+		{Src: "int 1\nstore 5\nload 5\nload 6", Stack: []VmValue{Uint64Value(1), Uint64Value(0)}},
+		{Src: "byte \"x\"\nbyte \"x\"\n==", Stack: []VmValue{Uint64Value(1)}},
+		{Src: "byte 0x0100\nbyte 0x01\nb-", Stack: []VmValue{BytesValue([]byte{0xff})}},
+		{Src: "byte 0x0f\nbyte 0xf000\nb|", Stack: []VmValue{BytesValue([]byte{0xf0, 0x0f})}},
+		{Src: "byte 0x000000000000000102\nint 1\nextract_uint64", Stack: []VmValue{Uint64Value(258)}},
+		{Src: "addr AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAY5HFKQ\nlen", Stack: []VmValue{Uint64Value(32)}},
+		{Src: "method \"add(uint64,uint64)uint128\"", Stack: []VmValue{BytesValue([]byte{0x8a, 0xa3, 0xb6, 0x1f})}},
+	}
+
+	for _, test := range tests {
+		vm := runConcrete("#pragma version 8\n" + test.Src)
+
+		// a program that leaves other than a single uint64 fails at its end
+		if failed := len(test.Stack) != 1 || test.Stack[0].T == VmTypeBytes; (vm.Error != nil) != failed {
+			t.Errorf("unexpected error in %q: %v", test.Src, vm.Error)
+			continue
+		}
+
+		if len(vm.Branches) != 1 {
+			t.Errorf("unexpected number of branches in %q: %d", test.Src, len(vm.Branches))
+			continue
+		}
+
+		items := vm.Branches[0].Stack.Items
+		if len(items) != len(test.Stack) {
+			t.Errorf("unexpected stack of %q: %v", test.Src, items)
+			continue
+		}
+
+		for i, v := range test.Stack {
+			if !items[i].equal(v) {
+				t.Errorf("unexpected stack item %d of %q: %v", i, test.Src, items[i])
+			}
+		}
+	}
+}
+
+func TestConcreteErrors(t *testing.T) {
+	tests := []struct {
+		Src  string
+		Line int
+	}{
+		{Src: "int 0xffffffffffffffff\nint 1\n+", Line: 3},
+		{Src: "int 1\nint 2\n-", Line: 3},
+		{Src: "int 1\nint 0\n/", Line: 3},
+		{Src: "int 1\nint 0\n%", Line: 3},
+		{Src: "int 0x100000000\nint 0x100000000\n*", Line: 3},
+		{Src: "int 1\nbyte \"a\"\n==", Line: 3},
+		{Src: "byte 0x010203040506070809\nbtoi", Line: 2},
+		{Src: "int 4096\nbzero\nbyte 0x00\nconcat", Line: 4},
+		{Src: "int 4097\nbzero", Line: 2},
+		{Src: "int 0\nassert", Line: 2},
+		{Src: "err", Line: 1},
+		{Src: "byte 0x01\nbyte 0x00\nb/", Line: 3},
+		{Src: "byte \"abc\"\nsubstring 2 1", Line: 2},
+		{Src: "int 1\nint 64\nshl", Line: 3},
+		{Src: "int 0\nint 0\nexp", Line: 3},
+		{Src: "byte 0x01\nbyte 0x02\nb-", Line: 3},
+	}
+
+	for _, test := range tests {
+		vm := runConcrete("#pragma version 8\n" + test.Src)
+		if vm.Error == nil {
+			t.Errorf("expected %q to fail", test.Src)
+			continue
+		}
+
+		if vm.Branch == nil || vm.Branch.Line != test.Line {
+			t.Errorf("unexpected failure location of %q: %v", test.Src, vm.Branch)
+		}
+	}
+}
+
+func TestConcreteBranches(t *testing.T) {
+	vm := runConcrete(`#pragma version 8
+int 0
+bnz skip
+int 1
+b end
+skip:
+int 2
+end:
+int 1
+int 3
+int 3
+match one three
+int 4
+b done
+one:
+int 5
+b done
+three:
+int 6
+done:`)
+
+	if vm.Error == nil {
+		t.Fatal("expected the two values left on the stack to fail the program")
+	}
+
+	if len(vm.Branches) != 1 {
+		t.Fatalf("unexpected number of branches: %d", len(vm.Branches))
+	}
+
+	items := vm.Branches[0].Stack.Items
+	if len(items) != 2 || !items[0].equal(Uint64Value(1)) || !items[1].equal(Uint64Value(6)) {
+		t.Errorf("unexpected stack: %v", items)
+	}
+}
+
+func TestScratchPerBranch(t *testing.T) {
+	vm := NewVm(Process(`#pragma version 8
+int 1
+store 0
+txn ApplicationID
+bnz other
+int 5
+store 0
+b end
+other:
+int 7
+store 0
+end:
+load 0`))
+	vm.Run()
+
+	if vm.Error != nil {
+		t.Fatal(vm.Error)
+	}
+
+	if len(vm.Branches) != 2 {
+		t.Fatalf("unexpected number of branches: %d", len(vm.Branches))
+	}
+
+	for i, expected := range []uint64{5, 7} {
+		b := vm.Branches[i]
+		if len(b.Stack.Items) != 1 || !b.Stack.Items[0].equal(Uint64Value(expected)) {
+			t.Errorf("unexpected stack of branch %d: %v", i, b.Stack.Items)
+		}
+	}
+}
+
+func TestScratchUnset(t *testing.T) {
+	for _, src := range []string{"load 3", "int 3\nloads"} {
+		vm := NewVm(Process("#pragma version 8\n" + src))
+		vm.Run()
+
+		if vm.Error != nil {
+			t.Fatal(vm.Error)
+		}
+
+		b := vm.Branches[0]
+		if len(b.Stack.Items) != 1 || !b.Stack.Items[0].equal(Uint64Value(0)) {
+			t.Errorf("unexpected stack for %s: %v", src, b.Stack.Items)
+		}
+	}
+}
+
+func TestConcreteEndErrors(t *testing.T) {
+	tests := []struct {
+		Src      string
+		Concrete bool
+		Line     int
+	}{
+		{Src: "int 1\nint 2", Concrete: true, Line: 2},
+		{Src: "byte 0x01\npop", Concrete: true, Line: 2},
+		{Src: "byte 0x01", Concrete: true, Line: 1},
+		{Src: "byte 0x01\nreturn", Concrete: true, Line: 2},
+		{Src: "int 1\nbtoi\nreturn", Concrete: true, Line: 2},
+		{Src: "int 1\n+\nreturn", Concrete: true, Line: 2},
+		{Src: "int 1\nbtoi\nreturn", Line: 2},
+	}
+
+	for _, test := range tests {
+		var opts []VmOption
+		if test.Concrete {
+			opts = append(opts, WithConcrete())
+		}
+
+		vm := NewVm(Process("#pragma version 8\n"+test.Src), opts...)
+		vm.Run()
+
+		if vm.Error == nil || vm.ErrorLine != test.Line {
+			t.Errorf("expected %q to fail at line %d: %v at %d", test.Src, test.Line, vm.Error, vm.ErrorLine)
+		}
+
+		if b := vm.Branches[0]; b.Outcome != VmErr || b.Err == nil {
+			t.Errorf("unexpected outcome of %q: %s (%v)", test.Src, b.Outcome, b.Err)
+		}
+	}
+}
+
+func TestOutcomes(t *testing.T) {
+	tests := []struct {
+		Src      string
+		Concrete bool
+		Outcome  VmOutcome
+	}{
+		{Src: "int 1\nreturn", Concrete: true, Outcome: VmPass},
+		{Src: "int 0", Concrete: true, Outcome: VmReject},
+		{Src: "int 1\nint 2", Concrete: true, Outcome: VmErr},
+		{Src: "byte 0x01", Concrete: true, Outcome: VmErr},
+		{Src: "err", Concrete: true, Outcome: VmErr},
+		{Src: "txn Fee", Outcome: VmUndecided},
+		{Src: "int 0\nassert\nint 1", Outcome: VmErr},
+	}
+
+	for _, test := range tests {
+		var opts []VmOption
+		if test.Concrete {
+			opts = append(opts, WithConcrete())
+		}
+
+		vm := NewVm(Process("#pragma version 8\n"+test.Src), opts...)
+		vm.Run()
+
+		if b := vm.Branches[0]; b.Outcome != test.Outcome {
+			t.Errorf("unexpected outcome of: %s: %s (%v)", test.Src, b.Outcome, b.Err)
+		}
+	}
+}
+
+func TestLogs(t *testing.T) {
+	vm := runConcrete(`#pragma version 8
+byte "event"
+log
+byte 0x151f7c75000000000000002a
+log
+int 1`)
+
+	if vm.Error != nil {
+		t.Fatal(vm.Error)
+	}
+
+	b := vm.Branches[0]
+
+	if len(b.Logs) != 2 || !b.Logs[0].equal(BytesValue([]byte("event"))) {
+		t.Errorf("unexpected logs: %v", b.Logs)
+	}
+
+	v, ok := b.ReturnValue()
+	if !ok || !v.equal(BytesValue([]byte{0, 0, 0, 0, 0, 0, 0, 42})) {
+		t.Errorf("unexpected return value: %v (%t)", v, ok)
+	}
+
+	if b.Outcome != VmPass || !b.Result.equal(Uint64Value(1)) {
+		t.Errorf("unexpected result: %s %v", b.Outcome, b.Result)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	hashes := "#pragma version 8\nbyte 0x00\n" + strings.Repeat("keccak256\n", 6) + "len"
+	calls := []types.SignedTxn{
+		{Txn: types.Transaction{Type: types.ApplicationCallTx}},
+		{Txn: types.Transaction{Type: types.ApplicationCallTx}},
+	}
+
+	tests := []struct {
+		Src    string
+		Opts   []VmOption
+		Err    bool
+		Budget uint64
+	}{
+		{Src: hashes, Err: true},
+		{Src: hashes, Opts: []VmOption{WithMode(ModeSig)}},
+		{Src: hashes, Opts: []VmOption{WithGroup(calls, 0)}},
+		{Src: hashes, Opts: []VmOption{WithGroup(calls, 0), WithGlobals(VmGlobals{LogicSigVersion: 3})}, Err: true},
+		{Src: "#pragma version 3\nbyte 0x00\n" + strings.Repeat("keccak256\n", 6) + "len", Opts: []VmOption{WithGroup(calls, 0)}},
+		{Src: "#pragma version 8\nglobal OpcodeBudget", Budget: 699},
+		{Src: "#pragma version 8\nglobal OpcodeBudget", Opts: []VmOption{WithGroup(calls, 1)}, Budget: 1399},
+		{Src: "#pragma version 8\nitxn_begin\nint appl\nitxn_field TypeEnum\nitxn_submit\nglobal OpcodeBudget", Budget: 1395},
+	}
+
+	for _, test := range tests {
+		vm := NewVm(Process(test.Src), append([]VmOption{WithConcrete()}, test.Opts...)...)
+		vm.Run()
+
+		if test.Err {
+			if vm.Error == nil || !strings.Contains(fmt.Sprint(vm.Error), "dynamic cost budget exceeded") {
+				t.Errorf("expected budget error for: %s: %v", test.Src, vm.Error)
+			}
+			continue
+		}
+
+		if vm.Error != nil {
+			t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			continue
+		}
+
+		if test.Budget != 0 {
+			items := vm.Branches[0].Stack.Items
+			if len(items) != 1 || !items[0].equal(Uint64Value(test.Budget)) {
+				t.Errorf("unexpected budget for: %s: %v", test.Src, items)
+			}
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	sig := []VmOption{WithMode(ModeSig)}
+
+	tests := []struct {
+		Src  string
+		Opts []VmOption
+		Err  string
+	}{
+		{Src: "#pragma version 8\nloop:\nint 1\nb loop", Opts: sig, Err: "stack overflow"},
+		{Src: "#pragma version 8\nf:\ncallsub f", Opts: sig, Err: "callsub depth limit"},
+		{Src: "#pragma version 8\nint 1\ncallsub f\nf:\nproto 2 0", Err: "requires 2 args with stack height 1"},
+		{Src: "#pragma version 8\ncallsub f\nint 1\nreturn\nf:\nproto 0 1\nretsub", Err: "retsub executed with stack below frame"},
+		{Src: "#pragma version 8\nint 1\ncallsub f\nf:\nproto 1 0\nframe_dig -2", Err: "frame_dig -2 in sub with 1 args"},
+		{Src: "#pragma version 8\ncallsub f\nf:\nproto 0 0\nframe_dig 0", Err: "frame_dig above stack"},
+		{Src: "#pragma version 8\nint 1\ncallsub f\nf:\nframe_bury 0", Err: "frame_bury with empty callstack"},
+		{Src: "#pragma version 8\nretsub", Err: "retsub with empty callstack"},
+		{Src: "#pragma version 8\nint 1\nbury 1", Err: "bury 1 with stack height 1"},
+		{Src: "#pragma version 8\narg 0", Opts: []VmOption{WithMode(ModeSig), WithArgs(make([]byte, MaxStringSize+1))}, Err: "exceeds the limit of 4096"},
+		{Src: "#pragma version 8\nint 1\nint 2\ncallsub f\nreturn\nf:\nproto 2 1\nint 3\nframe_dig -1\nframe_bury 0\nretsub"},
+	}
+
+	for _, test := range tests {
+		vm := NewVm(Process(test.Src), append([]VmOption{WithConcrete()}, test.Opts...)...)
+		vm.Run()
+
+		if test.Err == "" {
+			if vm.Error != nil {
+				t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			} else if b := vm.Branches[0]; b.Outcome != VmPass {
+				t.Errorf("unexpected outcome for: %s: %s", test.Src, b.Outcome)
+			}
+			continue
+		}
+
+		if vm.Error == nil || !strings.Contains(fmt.Sprint(vm.Error), test.Err) {
+			t.Errorf("expected error %q for: %s: %v", test.Err, test.Src, vm.Error)
+			continue
+		}
+
+		if b := vm.Branches[0]; b.Outcome != VmErr {
+			t.Errorf("unexpected outcome for: %s: %s", test.Src, b.Outcome)
+		}
+	}
+}
+
+func TestSymbolicLimits(t *testing.T) {
+	tests := []struct {
+		Src string
+		Err string
+	}{
+		{Src: "f:\ncallsub f", Err: "callsub depth limit"},
+		{Src: "int 1\ncallsub f\nf:\nproto 2 0", Err: "requires 2 args with stack height 1"},
+		{Src: "int 1\ncallsub f\nf:\nproto 1 0\nframe_dig -2", Err: "frame_dig -2 in sub with 1 args"},
+		{Src: "callsub f\nf:\nproto 0 0\nframe_dig 0", Err: "frame_dig above stack"},
+		{Src: "int 1\ncallsub f\nf:\nframe_bury 0", Err: "frame_bury with empty callstack"},
+		{Src: "retsub", Err: "retsub with empty callstack"},
+		{Src: "int 1\nbury 1", Err: "bury 1 with stack height 1"},
+	}
+
+	for _, test := range tests {
+		src := "#pragma version 8\ntxn Fee\nbnz ok\n" + test.Src + "\nok:\nint 1"
+
+		vm := NewVm(Process(src), WithMode(ModeSig))
+		vm.Run()
+
+		if vm.Error != nil {
+			t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			continue
+		}
+
+		failed, passed := false, false
+		for _, b := range vm.Branches {
+			switch b.Outcome {
+			case VmErr:
+				failed = failed || strings.Contains(fmt.Sprint(b.Err), test.Err)
+			case VmPass:
+				passed = true
+			}
+		}
+
+		if !failed || !passed {
+			t.Errorf("expected one branch to fail with %q and another to pass for: %s", test.Err, test.Src)
+		}
+	}
+}
+
+func TestSpecOpsExecutable(t *testing.T) {
+	for _, spec := range BuiltInLangSpec.Ops {
+		bs := []byte{byte(BuiltInLangSpec.EvalMaxVersion), spec.Opcode}
+		for _, arg := range langOpsImms[spec.Opcode] {
+			if arg.t == "int16" {
+				bs = append(bs, 0, 0)
+			} else {
+				bs = append(bs, 0)
+			}
+		}
+
+		l, err := Disassemble(bs)
+		if err != nil {
+			t.Errorf("failed to disassemble %s: %s", spec.Name, err)
+			continue
+		}
+
+		res := Process(l.String())
+
+		for _, ops := range []Listing{l, res.Listing} {
+			for _, op := range ops {
+				switch op.(type) {
+				case Nop, vmOp:
+				default:
+					t.Errorf("%s is not executable: %T", spec.Name, op)
+				}
+			}
+		}
+	}
+}
+
+func TestBranchMerge(t *testing.T) {
+	// the branches reach the same state under different conditions
+	vm := NewVm(Process("#pragma version 8\ntxn Fee\nbnz a\nint 1\nb end\na:\nint 1\nb end\nend:\nreturn"))
+	vm.Run()
+
+	outcomes := map[VmOutcome]int{}
+	for _, b := range vm.Branches {
+		outcomes[b.Outcome]++
+	}
+
+	if outcomes[VmPass] != 2 || outcomes[VmMerged] != 0 {
+		t.Errorf("unexpected outcomes: %v", outcomes)
+	}
+
+	vm = NewVm(Process("#pragma version 8\ntxn Fee\nreturn"))
+	vm.Step()
+
+	b := vm.Branches[0]
+	nb := b.clone()
+
+	if b.key() != nb.key() {
+		t.Errorf("expected the same state of the cloned branch")
+	}
+
+	nb.assume(b.Stack.Items[0], "!=", Uint64Value(0))
+
+	if b.key() == nb.key() {
+		t.Errorf("expected a different state of the branch with a constraint")
+	}
+}
+
+func TestBranchLimits(t *testing.T) {
+	loop := "#pragma version 8\ntxn Fee\nloop:\nint 1\n-\ndup\nbnz loop\nreturn"
+	router := "#pragma version 8\ntxn NumAppArgs\nswitch a b c d\nerr\na:\nb:\nc:\nd:\nint 1"
+
+	tests := []struct {
+		Src    string
+		Opts   []VmOption
+		Reason string
+	}{
+		{Src: loop, Reason: "loop unrolling bound of 16 reached"},
+		{Src: loop, Opts: []VmOption{WithMaxUnroll(0), WithMaxSteps(100)}, Reason: "step limit of 100 reached"},
+		{Src: loop, Opts: []VmOption{WithMaxBranches(3)}, Reason: "branch limit of 3 reached"},
+		{Src: router, Opts: []VmOption{WithMaxBranches(2)}, Reason: "branch limit of 2 reached"},
+	}
+
+	for _, test := range tests {
+		vm := NewVm(Process(test.Src), test.Opts...)
+		vm.Run()
+
+		if vm.Error != nil {
+			t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			continue
+		}
+
+		if vm.Branch != nil {
+			t.Errorf("vm did not finish: %s", test.Src)
+			continue
+		}
+
+		found := false
+		for _, c := range vm.Cuts {
+			if c.Reason == test.Reason {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("missing cut %q for: %s: %v", test.Reason, test.Src, vm.Cuts)
+		}
+	}
+}
+
+func TestPruning(t *testing.T) {
+	noop := []types.SignedTxn{{Txn: types.Transaction{Type: types.ApplicationCallTx}}}
+
+	tests := []struct {
+		Src      string
+		Opts     []VmOption
+		Branches int
+	}{
+		{Src: "int 1\nbnz a\nerr\na:\nint 1", Branches: 1},
+		{Src: "int 0\nbz a\nerr\na:\nint 1", Branches: 1},
+		{Src: "txn OnCompletion\nint 7\n==\nbnz a\nint 1\nreturn\na:\nerr", Branches: 1},
+		{Src: "txn NumAppArgs\nint 0\n>=\nassert\ntxn NumAppArgs\nint 16\n<=\nbz a\nint 1\nreturn\na:\nerr", Branches: 1},
+		{Src: "txn OnCompletion\nint NoOp\n==\nbnz a\nerr\na:\nint 1", Opts: []VmOption{WithGroup(noop, 0)}, Branches: 1},
+		{Src: "txn OnCompletion\nswitch a b c d e f\nerr\na:\nb:\nc:\nd:\ne:\nf:\nint 1", Branches: 6},
+		{Src: "txn OnCompletion\nint 2\n%\nswitch a b c\nerr\na:\nb:\nc:\nint 1", Branches: 2},
+		{Src: "int 1\nint 2\nint 2\nmatch a b\nerr\na:\nerr\nb:\nint 1", Branches: 1},
+		{Src: "int 9\nint 2\ntxn OnCompletion\nmatch a b\nint 1\nreturn\na:\nerr\nb:\nint 1", Branches: 2},
+	}
+
+	for _, test := range tests {
+		vm := NewVm(Process("#pragma version 8\n"+test.Src), test.Opts...)
+		vm.Run()
+
+		if vm.Error != nil {
+			t.Errorf("unexpected error for: %s: %v", test.Src, vm.Error)
+			continue
+		}
+
+		if len(vm.Branches) != test.Branches {
+			t.Errorf("unexpected number of branches for: %s: %d", test.Src, len(vm.Branches))
+			continue
+		}
+
+		for _, b := range vm.Branches {
+			if b.Outcome == VmErr {
+				t.Errorf("infeasible branch %s taken for: %s", b.Name, test.Src)
+			}
+		}
+	}
+}